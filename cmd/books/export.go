@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/server"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// exportExtensions are the export formats of the file extensions
+var exportExtensions = map[string]string{
+	".csv":    server.FormatCSV,
+	".ndjson": server.FormatNDJSON,
+	".jsonl":  server.FormatNDJSON,
+	".xlsx":   server.FormatXLSX,
+}
+
+func runExport(args []string) error {
+	flags, configPath := newFlagSet("export")
+	format := flags.String("format", "", "csv, ndjson or xlsx, taken from the file extension by default")
+	sort := flags.String("sort", "", "field to sort by, prefixed with - for descending order")
+	author := flags.String("author", "", "export the books of the author")
+	publisher := flags.String("publisher", "", "export the books of the publisher")
+	status := flags.String("status", "", "export the books of the status")
+	minRating := flags.Int("min-rating", 0, "export the books rated at least")
+	maxRating := flags.Int("max-rating", 0, "export the books rated at most")
+	publishedFrom := flags.String("published-from", "", "export the books published on or after the date, YYYY-MM-DD")
+	publishedTo := flags.String("published-to", "", "export the books published on or before the date, YYYY-MM-DD")
+	flags.Parse(args)
+
+	args = flags.Args()
+	if len(args) != 1 {
+		return errors.New("usage: books export [flags] <file|->")
+	}
+	path := args[0]
+
+	if *format == "" {
+		*format = exportExtensions[strings.ToLower(filepath.Ext(path))]
+		if *format == "" {
+			return fmt.Errorf("can't tell the format of %q, set --format", path)
+		}
+	}
+
+	query := storage.ListBooksQuery{
+		Sort:      *sort,
+		Author:    *author,
+		Publisher: *publisher,
+		Status:    models.BookStatus(*status),
+		MinRating: *minRating,
+		MaxRating: *maxRating,
+	}
+	var err error
+	if query.PublishedFrom, err = parseDateFlag("published-from", *publishedFrom); err != nil {
+		return err
+	}
+	if query.PublishedTo, err = parseDateFlag("published-to", *publishedTo); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load service config: %w", err)
+	}
+
+	store, err := newStorage(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initiate storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if path == "-" {
+		return server.ExportBooks(ctx, store, os.Stdout, *format, query)
+	}
+	return exportToFile(ctx, store, path, *format, query)
+}
+
+// exportToFile writes the export to the file, which is removed when the export fails
+func exportToFile(ctx context.Context, store storage.Storage, path, format string, query storage.ListBooksQuery) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	return server.ExportBooks(ctx, store, file, format, query)
+}
+
+func parseDateFlag(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &date, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alexkaplun/books-test/service/server"
+	"github.com/alexkaplun/books-test/storage"
+)
+
+// importExtensions are the import formats of the file extensions
+var importExtensions = map[string]string{
+	".csv":    server.FormatCSV,
+	".ndjson": server.FormatNDJSON,
+	".jsonl":  server.FormatNDJSON,
+}
+
+func runImport(args []string) error {
+	flags, configPath := newFlagSet("import")
+	format := flags.String("format", "", "csv or ndjson, taken from the file extension by default")
+	dryRun := flags.Bool("dry-run", false, "validate the books without creating them")
+	batchSize := flags.Int("batch-size", 500, "number of books created at once")
+	actor := flags.String("actor", "", "actor recorded in the audit log, anonymous by default")
+	flags.Parse(args)
+
+	args = flags.Args()
+	if len(args) != 1 {
+		return errors.New("usage: books import [flags] <file|->")
+	}
+	path := args[0]
+
+	if *format == "" {
+		*format = importExtensions[strings.ToLower(filepath.Ext(path))]
+		if *format == "" {
+			return fmt.Errorf("can't tell the format of %q, set --format", path)
+		}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load service config: %w", err)
+	}
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	store, err := newStorage(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initiate storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = storage.WithAuditInfo(ctx, storage.AuditInfo{Actor: *actor})
+
+	report, err := server.ImportBooks(ctx, store, input, server.ImportParams{
+		Format:    *format,
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, row := range report.Rows {
+		if row.Error == "" {
+			continue
+		}
+		fmt.Printf("row %d: %s\n", row.Row, row.Error)
+		for _, fieldErr := range row.Errors {
+			fmt.Printf("  %s: %s\n", fieldErr.Field, fieldErr.Message)
+		}
+	}
+
+	verb := "imported"
+	if report.DryRun {
+		verb = "valid"
+	}
+	fmt.Printf("%d rows, %d %s, %d failed\n", report.Total, report.Imported, verb, report.Failed)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows failed to import", report.Failed)
+	}
+	return nil
+}
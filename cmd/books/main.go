@@ -3,72 +3,63 @@ package main
 import (
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
 	"github.com/alexkaplun/books-test/config"
-	"github.com/alexkaplun/books-test/service/server"
-	"github.com/alexkaplun/books-test/storage"
 	"github.com/spf13/pflag"
 )
 
-var (
-	configPath string
-)
+const usage = `Usage: books <command> [flags]
 
-func init() {
-	pflag.StringVar(&configPath, "config", "", "config.toml")
-}
+Commands:
+  serve                     start the http server (default)
+  migrate up                apply all pending migrations
+  migrate down              revert the latest applied migration
+  migrate to <version>      migrate up or down to the given version
+  migrate status            list migrations and whether they are applied
+  migrate new <name>        create a new empty migration
+  import <file|->           import books from a CSV or NDJSON file, - reads stdin
+  export <file|->           export books to a CSV, NDJSON or XLSX file, - writes stdout
+
+Run 'books <command> --help' for the command flags.
+`
 
 func main() {
-	pflag.Parse()
+	args := os.Args[1:]
 
-	cfg, err := loadConfig(configPath)
-	if err != nil {
-		log.Fatalf("failed to load service config: %v", err)
+	// no command (or only flags) keeps the original behaviour of starting the server
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	storage, err := storage.NewPostgres(
-		storage.Params{
-			ConnString: fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-				cfg.Storage.Host, cfg.Storage.Port, cfg.Storage.User, cfg.Storage.Password, cfg.Storage.DBName),
-		},
-	)
-	if err != nil {
-		log.Fatalf("failed to initiate storage: %v", err)
+	var err error
+	switch command {
+	case "serve":
+		err = runServe(args)
+	case "migrate":
+		err = runMigrate(args)
+	case "import":
+		err = runImport(args)
+	case "export":
+		err = runExport(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	handler := server.NewHandler(server.HandlerParams{Storage: storage})
-
-	httpServer := &http.Server{
-		Addr: fmt.Sprintf(":%s", cfg.Server.Port),
-		Handler: server.NewRouter(
-			server.RouterParams{
-				Handler: handler,
-			},
-		),
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+	if err != nil {
+		log.Fatal(err)
 	}
+}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		fmt.Printf("starting http server on port %s...\n", cfg.Server.Port)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("listen and serve error: %s\n", err)
-			quit <- os.Kill
-			return
-		}
-		fmt.Println("closing http server...")
-	}()
-
-	<-quit
-	fmt.Println("shutting down...\n")
+func newFlagSet(name string) (*pflag.FlagSet, *string) {
+	flags := pflag.NewFlagSet(name, pflag.ExitOnError)
+	configPath := flags.String("config", "", "config.toml")
+	return flags, configPath
 }
 
 func loadConfig(configFilePath string) (*config.Config, error) {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexkaplun/books-test/config"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/migrations"
+)
+
+const migrationTemplate = "-- %s migration %04d_%s\n"
+
+var migrationDirections = []string{"up", "down"}
+
+var migrationNameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func runMigrate(args []string) error {
+	flags, configPath := newFlagSet("migrate")
+	dir := flags.String("dir", "storage/migrations", "migrations source directory, used by 'migrate new'")
+	flags.Parse(args)
+
+	args = flags.Args()
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// creating a migration only touches the source tree, no database is needed
+	if args[0] == "new" {
+		if len(args) != 2 {
+			return errors.New("usage: books migrate new <name>")
+		}
+		return newMigration(*dir, args[1])
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load service config: %w", err)
+	}
+
+	migrator, closeDB, err := openMigrator(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations("applied", applied)
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if reverted != nil {
+			printMigrations("reverted", []*storage.Migration{reverted})
+		}
+		return err
+	case "to":
+		if len(args) != 2 {
+			return errors.New("usage: books migrate to <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+		changed, err := migrator.To(ctx, version)
+		printMigrations("migrated", changed)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(statuses)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+func openMigrator(cfg config.StorageConfig) (*storage.Migrator, func() error, error) {
+	if cfg.Driver != "" && cfg.Driver != config.StorageDriverPostgres {
+		return nil, nil, fmt.Errorf("migrations are not supported by %q storage driver", cfg.Driver)
+	}
+
+	db, err := sql.Open("postgres", cfg.ConnString())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	migrator, err := storage.NewMigrator(db, migrations.FS)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return migrator, db.Close, nil
+}
+
+func newMigration(dir, name string) error {
+	if !migrationNameRe.MatchString(name) {
+		return fmt.Errorf("invalid migration name %q, use lowercase letters, digits and underscores", name)
+	}
+
+	existing, err := storage.LoadMigrations(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+
+	var version int64 = 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	for _, direction := range migrationDirections {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+		content := fmt.Sprintf(migrationTemplate, direction, version, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+		fmt.Println("created", path)
+	}
+
+	return nil
+}
+
+func printMigrations(action string, list []*storage.Migration) {
+	if len(list) == 0 {
+		fmt.Printf("no migrations %s\n", action)
+		return
+	}
+	for _, m := range list {
+		fmt.Printf("%s %04d_%s\n", action, m.Version, m.Name)
+	}
+}
+
+func printStatus(statuses []*storage.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	w.Flush()
+}
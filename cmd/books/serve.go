@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alexkaplun/books-test/config"
+	"github.com/alexkaplun/books-test/service/feed"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/metrics"
+	"github.com/alexkaplun/books-test/service/outbox"
+	"github.com/alexkaplun/books-test/service/scheduler"
+	"github.com/alexkaplun/books-test/service/server"
+	"github.com/alexkaplun/books-test/service/webhook"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+func runServe(args []string) error {
+	flags, configPath := newFlagSet("serve")
+	noMigrate := flags.Bool("no-migrate", false, "don't apply pending migrations on start, overrides storage.auto_migrate")
+	flags.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load service config: %w", err)
+	}
+	if *noMigrate {
+		cfg.Storage.AutoMigrate = false
+	}
+
+	logger, err := newLogger(cfg.Log)
+	if err != nil {
+		return fmt.Errorf("failed to initiate logger: %w", err)
+	}
+
+	storage, err := newStorage(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initiate storage: %w", err)
+	}
+	registry := newMetrics(storage)
+	storage = instrumentStorage(storage, registry)
+
+	sinks, err := newSinks(cfg.Outbox)
+	if err != nil {
+		return fmt.Errorf("failed to initiate event sinks: %w", err)
+	}
+	// events are queued for the webhooks subscribed through the API, which deliver them on their own
+	sinks = append(sinks, webhook.NewSink(storage))
+	dispatcher := outbox.NewDispatcher(outbox.Params{
+		Storage:     storage,
+		Sinks:       sinks,
+		BatchSize:   cfg.Outbox.BatchSize,
+		MaxAttempts: cfg.Outbox.MaxAttempts,
+		MinBackoff:  time.Duration(cfg.Outbox.MinBackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(cfg.Outbox.MaxBackoffSeconds) * time.Second,
+	})
+
+	webhooks := webhook.NewDeliverer(webhook.Params{
+		Storage:     storage,
+		BatchSize:   cfg.Webhooks.BatchSize,
+		MaxAttempts: cfg.Webhooks.MaxAttempts,
+		MinBackoff:  time.Duration(cfg.Webhooks.MinBackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(cfg.Webhooks.MaxBackoffSeconds) * time.Second,
+		Timeout:     time.Duration(cfg.Webhooks.TimeoutSeconds) * time.Second,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every replica streams the events committed by all of them
+	events := feed.NewHub(feed.Params{
+		Storage:    storage,
+		BufferSize: cfg.Events.BufferSize,
+	})
+	if err = events.Start(ctx); err != nil {
+		return fmt.Errorf("failed to listen to book events: %w", err)
+	}
+
+	// readiness fails from the moment the shutdown starts
+	health := server.NewHealth(server.HealthParams{Storage: storage})
+
+	handler := server.NewHandler(server.HandlerParams{
+		Storage:          storage,
+		LoanPeriod:       time.Duration(cfg.Loans.PeriodDays) * 24 * time.Hour,
+		HoldPickupWindow: time.Duration(cfg.Holds.PickupDays) * 24 * time.Hour,
+		Webhooks:         webhooks,
+		Events:           events,
+		Health:           health,
+		WriteTimeout:     time.Duration(cfg.Server.WriteTimeout) * time.Second,
+	})
+
+	// jobs run on a single replica at a time, elected through the storage
+	jobs := scheduler.New(storage,
+		scheduler.AccrueFinesJob(storage,
+			models.FinePolicy{
+				DailyRate: cfg.Fines.DailyRate,
+				GraceDays: cfg.Fines.GraceDays,
+				MaxAmount: cfg.Fines.MaxAmount,
+			},
+			time.Duration(cfg.Fines.IntervalMinutes)*time.Minute,
+		),
+		scheduler.ExpireHoldsJob(storage, time.Duration(cfg.Holds.ExpireIntervalMinutes)*time.Minute),
+		scheduler.PurgeBooksJob(storage,
+			time.Duration(cfg.Trash.RetentionDays)*24*time.Hour,
+			time.Duration(cfg.Trash.PurgeIntervalMinutes)*time.Minute,
+		),
+		scheduler.DispatchEventsJob(dispatcher, time.Duration(cfg.Outbox.IntervalSeconds)*time.Second),
+		scheduler.DeliverWebhooksJob(webhooks, time.Duration(cfg.Webhooks.IntervalSeconds)*time.Second),
+	)
+	jobs.Start(ctx)
+
+	httpServer := &http.Server{
+		Addr: fmt.Sprintf(":%s", cfg.Server.Port),
+		Handler: server.NewRouter(
+			server.RouterParams{
+				Handler: handler,
+				Metrics: registry,
+			},
+		),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		ErrorLog:     logger.StdLogger(logging.LevelError),
+	}
+
+	// event streams never go idle, they are ended so the shutdown doesn't wait for them
+	httpServer.RegisterOnShutdown(events.Close)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting http server", "port", cfg.Server.Port)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case <-quit:
+		health.Shutdown()
+		logger.Info("shutting down")
+		time.Sleep(time.Duration(cfg.Server.ShutdownDelaySeconds) * time.Second)
+		err = shutdownServer(httpServer, time.Duration(cfg.Server.ShutdownTimeoutSeconds)*time.Second, logger)
+	case err = <-serveErr:
+		err = fmt.Errorf("listen and serve error: %w", err)
+	}
+
+	// the jobs finish their current run
+	cancel()
+	jobs.Wait()
+
+	closeSinks(sinks, logger)
+	if closeErr := storage.Close(); closeErr != nil {
+		logger.Error("failed to close storage", "err", closeErr)
+	}
+	return err
+}
+
+// closeSinks closes the sinks holding resources, e.g. the file of the file sink
+func closeSinks(sinks []outbox.Sink, logger *logging.Logger) {
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Error("failed to close event sink", "sink", sink.Name(), "err", err)
+			}
+		}
+	}
+}
+
+// shutdownServer stops accepting connections and waits for the in-flight requests until the timeout,
+// then closes the connections still open
+func shutdownServer(httpServer *http.Server, timeout time.Duration, logger *logging.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+		return fmt.Errorf("failed to drain connections in %s: %w", timeout, err)
+	}
+	logger.Info("http server closed")
+	return nil
+}
+
+// newMetrics registers the runtime and the connection pool metrics
+func newMetrics(store storage.Storage) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	metrics.RegisterRuntime(registry)
+	metrics.RegisterDBStats(registry, store.DBStats)
+	return registry
+}
+
+// instrumentStorage returns the storage recording the metrics of its calls and logging the failed ones
+// with the logger of the request or the job making them
+func instrumentStorage(store storage.Storage, registry *metrics.Registry) storage.Storage {
+	return storage.Instrument(store, metrics.StorageObserver(registry), logging.StorageObserver())
+}
+
+// newLogger makes the configured logger the default one, the lines of the log package included
+func newLogger(cfg config.LogConfig) (*logging.Logger, error) {
+	logger, err := logging.New(logging.Params{
+		Output: os.Stderr,
+		Level:  cfg.Level,
+		Format: cfg.Format,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.StdLogger(logging.LevelInfo).Writer())
+	return logger, nil
+}
+
+func newStorage(cfg config.StorageConfig) (storage.Storage, error) {
+	switch cfg.Driver {
+	case "", config.StorageDriverPostgres:
+		return storage.NewPostgres(
+			storage.Params{
+				ConnString:     cfg.ConnString(),
+				SkipMigrations: !cfg.AutoMigrate,
+			},
+		)
+	case config.StorageDriverMemory:
+		return storage.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// newSinks returns the sinks of the configured outbox. Without sinks, events are dropped as delivered
+func newSinks(cfg config.OutboxConfig) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, outbox.NewHTTPSink(cfg.WebhookURL, 10*time.Second))
+	}
+	if cfg.File != "" {
+		sink, err := outbox.NewFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Stdout {
+		sinks = append(sinks, outbox.NewWriterSink("stdout", os.Stdout))
+	}
+	return sinks, nil
+}
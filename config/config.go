@@ -1,28 +1,175 @@
 package config
 
-import "github.com/BurntSushi/toml"
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
 
 type Config struct {
-	Storage StorageConfig `toml:"storage"`
-	Server  ServerConfig  `toml:"server"`
+	Storage  StorageConfig  `toml:"storage"`
+	Server   ServerConfig   `toml:"server"`
+	Loans    LoansConfig    `toml:"loans"`
+	Holds    HoldsConfig    `toml:"holds"`
+	Fines    FinesConfig    `toml:"fines"`
+	Trash    TrashConfig    `toml:"trash"`
+	Outbox   OutboxConfig   `toml:"outbox"`
+	Webhooks WebhooksConfig `toml:"webhooks"`
+	Events   EventsConfig   `toml:"events"`
+	Log      LogConfig      `toml:"log"`
 }
 
 type ServerConfig struct {
 	Port         string `toml:"port"`
 	ReadTimeout  int    `toml:"read_timeout"`
 	WriteTimeout int    `toml:"write_timeout"`
+	// ShutdownDelaySeconds keeps serving after the shutdown signal while the readiness fails,
+	// so load balancers stop routing requests to the replica first
+	ShutdownDelaySeconds int `toml:"shutdown_delay"`
+	// ShutdownTimeoutSeconds limits waiting for in-flight requests on shutdown,
+	// the connections still open are closed afterwards
+	ShutdownTimeoutSeconds int `toml:"shutdown_timeout"`
+}
+
+const (
+	StorageDriverPostgres = "postgres"
+	StorageDriverMemory   = "memory"
+)
+
+type LoansConfig struct {
+	// PeriodDays is the default loan duration
+	PeriodDays int `toml:"period_days"`
+}
+
+type HoldsConfig struct {
+	// PickupDays is how long a returned book waits for the member of the first hold
+	PickupDays int `toml:"pickup_days"`
+	// ExpireIntervalMinutes is how often holds past their pickup window are expired
+	ExpireIntervalMinutes int `toml:"expire_interval_minutes"`
+}
+
+type FinesConfig struct {
+	// DailyRate is charged for every day overdue past the grace period, in minor currency units
+	DailyRate int64 `toml:"daily_rate"`
+	// GraceDays are days overdue that are not charged
+	GraceDays int `toml:"grace_days"`
+	// MaxAmount caps a single fine, 0 means no cap
+	MaxAmount int64 `toml:"max_amount"`
+	// IntervalMinutes is how often overdue loans are fined
+	IntervalMinutes int `toml:"interval_minutes"`
+}
+
+type TrashConfig struct {
+	// RetentionDays is how long deleted books are kept in the trash before they are purged
+	RetentionDays int `toml:"retention_days"`
+	// PurgeIntervalMinutes is how often books past the retention are purged
+	PurgeIntervalMinutes int `toml:"purge_interval_minutes"`
+}
+
+type OutboxConfig struct {
+	// IntervalSeconds is how often pending events are delivered
+	IntervalSeconds int `toml:"interval_seconds"`
+	BatchSize       int `toml:"batch_size"`
+	// MaxAttempts is the number of deliveries of an event before it's given up, 0 means no limit
+	MaxAttempts int `toml:"max_attempts"`
+	// MinBackoffSeconds is the delay after the first failed delivery, doubled up to MaxBackoffSeconds
+	MinBackoffSeconds int `toml:"min_backoff_seconds"`
+	MaxBackoffSeconds int `toml:"max_backoff_seconds"`
+
+	// the sinks events are delivered to
+	WebhookURL string `toml:"webhook_url"`
+	File       string `toml:"file"`
+	Stdout     bool   `toml:"stdout"`
+}
+
+type WebhooksConfig struct {
+	// IntervalSeconds is how often pending deliveries to the webhooks are attempted
+	IntervalSeconds int `toml:"interval_seconds"`
+	BatchSize       int `toml:"batch_size"`
+	// MaxAttempts is the number of attempts of a delivery before it's dead, 0 means no limit
+	MaxAttempts int `toml:"max_attempts"`
+	// MinBackoffSeconds is the delay after the first failed attempt, doubled up to MaxBackoffSeconds
+	MinBackoffSeconds int `toml:"min_backoff_seconds"`
+	MaxBackoffSeconds int `toml:"max_backoff_seconds"`
+	// TimeoutSeconds limits every attempt
+	TimeoutSeconds int `toml:"timeout_seconds"`
+}
+
+type EventsConfig struct {
+	// BufferSize is the number of latest events kept to resume the event streams
+	BufferSize int `toml:"buffer_size"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `toml:"level"`
+	// Format is text or json
+	Format string `toml:"format"`
 }
 
 type StorageConfig struct {
+	Driver   string `toml:"driver"`
 	Host     string `toml:"host"`
 	Port     string `toml:"port"`
 	User     string `toml:"user"`
 	DBName   string `toml:"db_name" `
 	Password string `toml:"password"`
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool `toml:"auto_migrate"`
+}
+
+func (c StorageConfig) ConnString() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		c.Host, c.Port, c.User, c.Password, c.DBName)
 }
 
 func ParseConfig(path string) (*Config, error) {
-	var config Config
+	config := Config{
+		Server: ServerConfig{
+			ShutdownTimeoutSeconds: 30,
+		},
+		Storage: StorageConfig{
+			AutoMigrate: true,
+		},
+		Loans: LoansConfig{
+			PeriodDays: 14,
+		},
+		Holds: HoldsConfig{
+			PickupDays:            3,
+			ExpireIntervalMinutes: 5,
+		},
+		Fines: FinesConfig{
+			DailyRate:       25,
+			MaxAmount:       1000,
+			IntervalMinutes: 60,
+		},
+		Trash: TrashConfig{
+			RetentionDays:        30,
+			PurgeIntervalMinutes: 60,
+		},
+		Outbox: OutboxConfig{
+			IntervalSeconds:   5,
+			BatchSize:         100,
+			MaxAttempts:       20,
+			MinBackoffSeconds: 1,
+			MaxBackoffSeconds: 300,
+		},
+		Webhooks: WebhooksConfig{
+			IntervalSeconds:   5,
+			BatchSize:         100,
+			MaxAttempts:       10,
+			MinBackoffSeconds: 10,
+			MaxBackoffSeconds: 3600,
+			TimeoutSeconds:    10,
+		},
+		Events: EventsConfig{
+			BufferSize: 1000,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
 	_, err := toml.DecodeFile(path, &config)
 	return &config, err
 }
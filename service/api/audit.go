@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type AuditEntry struct {
+	ID     uuid.UUID `json:"id"`
+	BookID uuid.UUID `json:"bookId"`
+	// Action is one of Create, Update, Delete and Restore
+	Action    string `json:"action"`
+	Actor     string `json:"actor"`
+	RequestID string `json:"requestId,omitempty"`
+	// Before and After are snapshots of the book, Before is omitted for created books
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt string          `json:"createdAt"`
+}
+
+type ListAuditResponse struct {
+	Items      []*AuditEntry `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
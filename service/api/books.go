@@ -11,7 +11,6 @@ type UpsertBookRequest struct {
 	Publisher   string `json:"publisher"`
 	PublishDate string `json:"publishDate"`
 	Rating      int    `json:"rating"`
-	Status      string `json:"status"`
 }
 
 type CreateBookResponse struct {
@@ -23,7 +22,6 @@ func (m UpsertBookRequest) Validate() error {
 		validation.Field(&m.Title, validation.Required),
 		validation.Field(&m.Author, validation.Required),
 		validation.Field(&m.Rating, validation.Required, validation.Min(1), validation.Max(3)),
-		validation.Field(&m.Status, validation.Required, validation.In("CheckedIn", "CheckedOut")),
 		validation.Field(&m.PublishDate, validation.Date("2006-01-02")),
 	)
 }
@@ -38,4 +36,38 @@ type Book struct {
 	Status      string    `json:"status"`
 	CreatedAt   string    `json:"createdAt"`
 	UpdatedAt   string    `json:"updatedAt"`
+	// Version changes with every change of the book, it's also sent as the ETag of the book
+	Version int `json:"version"`
+	// Copies rolls up the physical copies of the book
+	Copies CopyCounts `json:"copies"`
+	// DeletedAt is set for books in the trash
+	DeletedAt string `json:"deletedAt,omitempty"`
+}
+
+type ListBooksResponse struct {
+	Items      []*Book `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+	TotalCount *int    `json:"totalCount,omitempty"`
+}
+
+type ListTrashResponse struct {
+	Items []*Book `json:"items"`
+}
+
+type SearchBooksResponse struct {
+	Items []*BookSearchHit `json:"items"`
+}
+
+type BookSearchHit struct {
+	Book       *Book          `json:"book"`
+	Rank       float64        `json:"rank"`
+	Highlights BookHighlights `json:"highlights"`
+}
+
+// BookHighlights hold the searched fields as HTML: the text is escaped and the matching words
+// are wrapped in <b></b>, so they are shown as is
+type BookHighlights struct {
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Publisher string `json:"publisher"`
 }
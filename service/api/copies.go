@@ -0,0 +1,46 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+)
+
+type UpsertCopyRequest struct {
+	Barcode   string `json:"barcode"`
+	Location  string `json:"location"`
+	Condition string `json:"condition"`
+	Status    string `json:"status"`
+}
+
+type CreateCopyResponse struct {
+	ID *uuid.UUID `json:"id"`
+}
+
+func (m UpsertCopyRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Barcode, validation.Required, validation.Length(1, 64)),
+		validation.Field(&m.Location, validation.Length(0, 255)),
+		validation.Field(&m.Condition, validation.Required, validation.In("New", "Good", "Fair", "Poor", "Damaged")),
+		validation.Field(&m.Status, validation.Required, validation.In("Available", "InRepair", "Lost", "Withdrawn")),
+	)
+}
+
+type Copy struct {
+	ID        uuid.UUID `json:"id"`
+	BookID    uuid.UUID `json:"bookId"`
+	Barcode   string    `json:"barcode"`
+	Location  string    `json:"location"`
+	Condition string    `json:"condition"`
+	Status    string    `json:"status"`
+	CreatedAt string    `json:"createdAt"`
+	UpdatedAt string    `json:"updatedAt"`
+}
+
+type CopyCounts struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+}
+
+type ListCopiesResponse struct {
+	Items []*Copy `json:"items"`
+}
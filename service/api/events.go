@@ -0,0 +1,19 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// Event is a change of a book as delivered to the event sinks
+type Event struct {
+	// ID increases with every change, consumers can use it to drop duplicates
+	ID int64 `json:"id"`
+	// Type is one of book.created, book.updated, book.deleted and book.restored
+	Type       string    `json:"type"`
+	BookID     uuid.UUID `json:"bookId"`
+	OccurredAt string    `json:"occurredAt"`
+	// Book is the snapshot of the book after the change, deleted books have deletedAt set
+	Book json.RawMessage `json:"book"`
+}
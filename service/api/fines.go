@@ -0,0 +1,21 @@
+package api
+
+import "github.com/google/uuid"
+
+type Fine struct {
+	ID       uuid.UUID `json:"id"`
+	LoanID   uuid.UUID `json:"loanId"`
+	MemberID uuid.UUID `json:"memberId"`
+	// Amount is in cents
+	Amount    int64  `json:"amount"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+	PaidAt    string `json:"paidAt,omitempty"`
+}
+
+type ListFinesResponse struct {
+	Items []*Fine `json:"items"`
+	// Outstanding is the total of open fines, in cents
+	Outstanding int64 `json:"outstanding"`
+}
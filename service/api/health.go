@@ -0,0 +1,22 @@
+package api
+
+// Health statuses of the service and of its checks
+const (
+	HealthOK      = "ok"
+	HealthFailing = "failing"
+)
+
+// HealthResponse is the detailed health of the service, failing when any check fails
+type HealthResponse struct {
+	Status string         `json:"status"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	// Name is the checked dependency, e.g. database
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// LatencyMs is how long the check took in milliseconds
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
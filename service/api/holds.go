@@ -0,0 +1,33 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/google/uuid"
+)
+
+type PlaceHoldRequest struct {
+	MemberID string `json:"memberId"`
+}
+
+func (m PlaceHoldRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.MemberID, validation.Required, is.UUID),
+	)
+}
+
+type Hold struct {
+	ID       uuid.UUID `json:"id"`
+	BookID   uuid.UUID `json:"bookId"`
+	MemberID uuid.UUID `json:"memberId"`
+	Status   string    `json:"status"`
+	// Position is the 1-based place in the queue, set only for active holds
+	Position  int    `json:"position,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	ReadyAt   string `json:"readyAt,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+type ListHoldsResponse struct {
+	Items []*Hold `json:"items"`
+}
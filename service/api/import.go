@@ -0,0 +1,23 @@
+package api
+
+import "github.com/google/uuid"
+
+// ImportReport is the result of a bulk import with a row for every record of the input
+type ImportReport struct {
+	DryRun bool `json:"dryRun"`
+	Total  int  `json:"total"`
+	// Imported is the number of books created, or the number of valid records in a dry run
+	Imported int          `json:"imported"`
+	Failed   int          `json:"failed"`
+	Rows     []*ImportRow `json:"rows"`
+}
+
+type ImportRow struct {
+	// Row is the number of the record in the input starting at 1, the CSV header is not counted
+	Row int `json:"row"`
+	// ID is the id of the created book
+	ID *uuid.UUID `json:"id,omitempty"`
+	// Error is why the record was not imported, with the invalid fields in Errors
+	Error  string       `json:"error,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
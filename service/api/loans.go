@@ -0,0 +1,34 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/google/uuid"
+)
+
+type CheckoutRequest struct {
+	MemberID string `json:"memberId"`
+	// DueDate is the last day of the loan, defaults to the configured loan period
+	DueDate string `json:"dueDate"`
+}
+
+func (m CheckoutRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.MemberID, validation.Required, is.UUID),
+		validation.Field(&m.DueDate, validation.Date("2006-01-02")),
+	)
+}
+
+type Loan struct {
+	ID           uuid.UUID  `json:"id"`
+	BookID       uuid.UUID  `json:"bookId"`
+	MemberID     *uuid.UUID `json:"memberId"`
+	Borrower     string     `json:"borrower"`
+	CheckedOutAt string     `json:"checkedOutAt"`
+	DueAt        string     `json:"dueAt"`
+	ReturnedAt   string     `json:"returnedAt,omitempty"`
+}
+
+type ListLoansResponse struct {
+	Items []*Loan `json:"items"`
+}
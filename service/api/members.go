@@ -0,0 +1,44 @@
+package api
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/google/uuid"
+)
+
+type UpsertMemberRequest struct {
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	CardNumber     string `json:"cardNumber"`
+	Status         string `json:"status"`
+	BorrowingLimit int    `json:"borrowingLimit"`
+}
+
+type CreateMemberResponse struct {
+	ID *uuid.UUID `json:"id"`
+}
+
+func (m UpsertMemberRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&m.Email, validation.Required, validation.Length(1, 255), is.Email),
+		validation.Field(&m.CardNumber, validation.Required, validation.Length(1, 64)),
+		validation.Field(&m.Status, validation.Required, validation.In("Active", "Suspended")),
+		validation.Field(&m.BorrowingLimit, validation.Required, validation.Min(1), validation.Max(100)),
+	)
+}
+
+type Member struct {
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	CardNumber     string    `json:"cardNumber"`
+	Status         string    `json:"status"`
+	BorrowingLimit int       `json:"borrowingLimit"`
+	CreatedAt      string    `json:"createdAt"`
+	UpdatedAt      string    `json:"updatedAt"`
+}
+
+type ListMembersResponse struct {
+	Items []*Member `json:"items"`
+}
@@ -0,0 +1,40 @@
+package api
+
+// Problem is the RFC 7807 application/problem+json body of every error response
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the failed request
+	Instance string `json:"instance,omitempty"`
+	// Code identifies the error for clients, see the Code constants. Storage errors
+	// have their own codes, e.g. book_not_found
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+	// Errors lists the invalid fields of the request, set with CodeValidationFailed
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	// Field is the JSON name of the invalid field
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidBody          = "invalid_body"
+	CodeValidationFailed     = "validation_failed"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInvalidData          = "invalid_data"
+	CodeInternal             = "internal_error"
+	CodeUnavailable          = "unavailable"
+	CodeTimeout              = "timeout"
+	CodeCanceled             = "canceled"
+)
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"regexp"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"github.com/google/uuid"
+)
+
+// eventTypes are the types of book events webhooks subscribe to
+var eventTypes = []interface{}{"book.created", "book.updated", "book.deleted", "book.restored"}
+
+var webhookURL = regexp.MustCompile(`^https?://`)
+
+type UpsertWebhookRequest struct {
+	URL string `json:"url"`
+	// EventTypes are the events delivered to the webhook, all events when empty
+	EventTypes []string `json:"eventTypes"`
+	// Secret signs the deliveries. Webhooks created without one get a generated secret,
+	// updates without one keep the current secret
+	Secret string `json:"secret"`
+	// Active defaults to true on create, updates without it keep the current state.
+	// Inactive webhooks get no deliveries
+	Active *bool `json:"active"`
+}
+
+// CreateWebhookResponse is the only response with the secret of the webhook
+type CreateWebhookResponse struct {
+	ID     *uuid.UUID `json:"id"`
+	Secret string     `json:"secret"`
+}
+
+func (m UpsertWebhookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.URL, validation.Required, validation.Length(1, 2048), validation.Match(webhookURL), is.URL),
+		validation.Field(&m.EventTypes, validation.Each(validation.In(eventTypes...))),
+		validation.Field(&m.Secret, validation.Length(16, 255)),
+	)
+}
+
+type Webhook struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`
+	Active     bool      `json:"active"`
+	CreatedAt  string    `json:"createdAt"`
+	UpdatedAt  string    `json:"updatedAt"`
+}
+
+type ListWebhooksResponse struct {
+	Items []*Webhook `json:"items"`
+}
+
+type WebhookDelivery struct {
+	ID        uuid.UUID `json:"id"`
+	WebhookID uuid.UUID `json:"webhookId"`
+	// EventID is the id of the book event, not set for test deliveries
+	EventID   *int64 `json:"eventId,omitempty"`
+	EventType string `json:"eventType"`
+	// Status is Pending, Delivered or Dead once the delivery ran out of attempts
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// NextAttemptAt is set for pending deliveries
+	NextAttemptAt  string `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int    `json:"lastStatusCode,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	// Payload is the body posted to the webhook
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   string          `json:"createdAt"`
+	DeliveredAt string          `json:"deliveredAt,omitempty"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Items []*WebhookDelivery `json:"items"`
+}
+
+// WebhookTestEvent is posted to a webhook by POST /webhooks/:id/test
+type WebhookTestEvent struct {
+	Type       string    `json:"type"`
+	WebhookID  uuid.UUID `json:"webhookId"`
+	OccurredAt string    `json:"occurredAt"`
+}
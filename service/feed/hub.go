@@ -0,0 +1,168 @@
+// Package feed fans the events of book changes out to live subscribers, e.g. the SSE stream.
+//
+// Every replica listens to the events committed by all replicas and keeps the latest ones in
+// a bounded replay buffer, so clients reconnecting to any replica resume after the last event
+// they received. Subscribers too slow to keep up are dropped and resume the same way. When the
+// replica may have missed events, all subscribers are dropped and resume with a reset.
+package feed
+
+import (
+	"context"
+	"sync"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/outbox"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// subscriberBuffer is the number of events a subscriber can fall behind before it's dropped
+const subscriberBuffer = 64
+
+type Params struct {
+	Storage storage.EventFeed
+	// BufferSize is the number of latest events kept for resuming, defaults to 1000
+	BufferSize int
+}
+
+type Hub struct {
+	store      storage.EventFeed
+	bufferSize int
+
+	mu sync.Mutex
+	// buffer holds the latest events in the order they were received
+	buffer      []*api.Event
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+func NewHub(params Params) *Hub {
+	h := &Hub{
+		store:       params.Storage,
+		bufferSize:  params.BufferSize,
+		subscribers: make(map[*Subscription]bool),
+	}
+	if h.bufferSize <= 0 {
+		h.bufferSize = 1000
+	}
+	return h
+}
+
+// Start listens to the events until the context is done
+func (h *Hub) Start(ctx context.Context) error {
+	return h.store.ListenEvents(ctx, h.publish, h.reset)
+}
+
+// Subscription receives the events published after it was made
+type Subscription struct {
+	// Replay are the buffered events received after the last event of the subscriber
+	Replay []*api.Event
+	// Reset is set when the last event of the subscriber is no longer buffered,
+	// the events in between are missed
+	Reset bool
+
+	hub    *Hub
+	events chan *api.Event
+}
+
+// Events returns the channel of the events, closed when the subscriber is dropped or closed
+func (s *Subscription) Events() <-chan *api.Event {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.unsubscribe(s)
+}
+
+// Subscribe subscribes to the events. With lastEventID, the buffered events received after
+// that event are replayed, nil means no replay
+func (h *Hub) Subscribe(lastEventID *int64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{
+		hub:    h,
+		events: make(chan *api.Event, subscriberBuffer),
+	}
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	if lastEventID != nil {
+		sub.Replay, sub.Reset = h.replay(*lastEventID)
+	}
+	h.subscribers[sub] = true
+	return sub
+}
+
+// Close drops all subscribers, so their streams end and the clients resume elsewhere, e.g. when
+// the server shuts down. The subscriptions made afterwards are closed right away
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.unsubscribe(sub)
+	}
+}
+
+// replay returns the buffered events after the event. Event ids are assigned before the changes
+// commit, so events are replayed in the order they were received rather than by id. When the event
+// is not buffered, the events with greater ids are returned and reset is set. Callers must hold the lock
+func (h *Hub) replay(lastEventID int64) (events []*api.Event, reset bool) {
+	for i := len(h.buffer) - 1; i >= 0; i-- {
+		if h.buffer[i].ID == lastEventID {
+			return append(events, h.buffer[i+1:]...), false
+		}
+	}
+
+	for _, event := range h.buffer {
+		if event.ID > lastEventID {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+func (h *Hub) publish(in *models.Event) {
+	event := outbox.ConvertEvent(in)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.buffer) == h.bufferSize {
+		h.buffer = append(h.buffer[:0], h.buffer[1:]...)
+	}
+	h.buffer = append(h.buffer, event)
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// reset drops the buffer and the subscribers after events were missed, so they resume with a reset
+func (h *Hub) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buffer = h.buffer[:0]
+	for sub := range h.subscribers {
+		h.unsubscribe(sub)
+	}
+}
+
+// unsubscribe drops the subscriber, closing its channel. Callers must hold the lock
+func (h *Hub) unsubscribe(sub *Subscription) {
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
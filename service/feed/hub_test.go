@@ -0,0 +1,122 @@
+package feed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := storage.NewMemory()
+	hub := NewHub(Params{Storage: store, BufferSize: 2})
+	require.NoError(t, hub.Start(ctx))
+
+	sub := hub.Subscribe(nil)
+	defer sub.Close()
+	assert.Empty(t, sub.Replay)
+
+	bookID, err := store.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	event := <-sub.Events()
+	assert.Equal(t, "book.created", event.Type)
+	assert.Equal(t, *bookID, event.BookID)
+
+	require.NoError(t, store.DeleteBook(ctx, *bookID, 0))
+	_, err = store.RestoreBook(ctx, *bookID)
+	require.NoError(t, err)
+	assert.Equal(t, "book.deleted", (<-sub.Events()).Type)
+	assert.Equal(t, "book.restored", (<-sub.Events()).Type)
+
+	// the events after a buffered one are replayed
+	resumed := hub.Subscribe(&event.ID)
+	defer resumed.Close()
+	assert.True(t, resumed.Reset)
+	require.Len(t, resumed.Replay, 2)
+	assert.Equal(t, "book.deleted", resumed.Replay[0].Type)
+
+	resumed = hub.Subscribe(&resumed.Replay[0].ID)
+	defer resumed.Close()
+	assert.False(t, resumed.Reset)
+	require.Len(t, resumed.Replay, 1)
+	assert.Equal(t, "book.restored", resumed.Replay[0].Type)
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := storage.NewMemory()
+	hub := NewHub(Params{Storage: store})
+	require.NoError(t, hub.Start(ctx))
+
+	sub := hub.Subscribe(nil)
+	for i := 0; i <= subscriberBuffer; i++ {
+		_, err := store.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+		require.NoError(t, err)
+	}
+
+	var received int
+	for range sub.Events() {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+	sub.Close()
+}
+
+// lossyFeed hands the listener to the test, to publish events and report lost ones
+type lossyFeed struct {
+	publish func(event *models.Event)
+	lost    func()
+}
+
+func (f *lossyFeed) ListenEvents(_ context.Context, fn func(event *models.Event), lost func()) error {
+	f.publish, f.lost = fn, lost
+	return nil
+}
+
+func TestHubReset(t *testing.T) {
+	feed := &lossyFeed{}
+	now := time.Now()
+	hub := NewHub(Params{Storage: feed})
+	require.NoError(t, hub.Start(context.Background()))
+
+	sub := hub.Subscribe(nil)
+	feed.publish(&models.Event{ID: 1, Type: models.EventBookCreated, CreatedAt: &now})
+	event := <-sub.Events()
+
+	// the subscribers are dropped once events may have been missed
+	feed.lost()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	sub.Close()
+
+	// and resume with a reset, though their last event was buffered
+	feed.publish(&models.Event{ID: 3, Type: models.EventBookDeleted, CreatedAt: &now})
+	resumed := hub.Subscribe(&event.ID)
+	defer resumed.Close()
+	assert.True(t, resumed.Reset)
+	require.Len(t, resumed.Replay, 1)
+	assert.Equal(t, int64(3), resumed.Replay[0].ID)
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub(Params{Storage: storage.NewMemory()})
+
+	sub := hub.Subscribe(nil)
+	hub.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	sub.Close()
+
+	// subscriptions made after closing end right away
+	sub = hub.Subscribe(nil)
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+	sub.Close()
+}
@@ -0,0 +1,301 @@
+// Package logging writes leveled, structured log lines as text or JSON.
+//
+// Lines carry a message and key-value fields. Loggers derived with With add their fields
+// to every line, the logger of a request is passed along in its context, so the lines
+// written while serving it are correlated by the request id.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a line, lines below the level of the logger are dropped
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseLevel returns the level of the name, info when it's empty
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: must be debug, info, warn or error", name)
+}
+
+// Formats of the lines
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type Params struct {
+	// Output is where the lines are written, os.Stderr if not set
+	Output io.Writer
+	// Level is debug, info, warn or error, info if not set
+	Level string
+	// Format is FormatText or FormatJSON, FormatText if not set
+	Format string
+}
+
+// Logger writes the lines of its level and above with its fields. It's safe for concurrent use
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// output is shared by a logger and the loggers derived from it
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	json   bool
+	buffer bytes.Buffer
+}
+
+func New(params Params) (*Logger, error) {
+	level, err := ParseLevel(params.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &output{w: params.Output, level: level}
+	if out.w == nil {
+		out.w = os.Stderr
+	}
+	switch params.Format {
+	case "", FormatText:
+	case FormatJSON:
+		out.json = true
+	default:
+		return nil, fmt.Errorf("unknown log format %q: must be text or json", params.Format)
+	}
+
+	return &Logger{out: out}, nil
+}
+
+// With returns a logger adding the fields, given as alternating keys and values, to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if len(keyvals) == 0 {
+		return l
+	}
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	return &Logger{out: l.out, fields: append(fields, keyvals...)}
+}
+
+// Enabled tells whether the lines of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Log writes the line with the fields of the logger followed by the keyvals
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now().UTC().Format(timeLayout)
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	buf := &l.out.buffer
+	buf.Reset()
+	if l.out.json {
+		buf.WriteByte('{')
+		writeJSONField(buf, "time", now)
+		buf.WriteByte(',')
+		writeJSONField(buf, "level", level.String())
+		buf.WriteByte(',')
+		writeJSONField(buf, "msg", msg)
+		eachField(l.fields, keyvals, func(key string, value interface{}) {
+			buf.WriteByte(',')
+			writeJSONField(buf, key, value)
+		})
+		buf.WriteString("}\n")
+	} else {
+		writeTextField(buf, "time", now)
+		buf.WriteByte(' ')
+		writeTextField(buf, "level", level.String())
+		buf.WriteByte(' ')
+		writeTextField(buf, "msg", msg)
+		eachField(l.fields, keyvals, func(key string, value interface{}) {
+			buf.WriteByte(' ')
+			writeTextField(buf, key, value)
+		})
+		buf.WriteByte('\n')
+	}
+	l.out.w.Write(buf.Bytes())
+}
+
+// StdLogger returns a standard logger writing every line it's given as a line of the level,
+// for the packages logging through the log package
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(stdWriter{logger: l, level: level}, "", 0)
+}
+
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.logger.Log(w.level, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// eachField calls fn with the key and the value of every field, a key without a value
+// is given a placeholder
+func eachField(fields, keyvals []interface{}, fn func(key string, value interface{})) {
+	for _, list := range [][]interface{}{fields, keyvals} {
+		for i := 0; i < len(list); i += 2 {
+			key, ok := list[i].(string)
+			if !ok {
+				key = fmt.Sprint(list[i])
+			}
+			var value interface{} = "(MISSING)"
+			if i+1 < len(list) {
+				value = list[i+1]
+			}
+			fn(key, value)
+		}
+	}
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	encoded, _ := json.Marshal(key)
+	buf.Write(encoded)
+	buf.WriteByte(':')
+
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
+
+func writeTextField(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteString(key)
+	buf.WriteByte('=')
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(value)
+	}
+	if needsQuoting(s) {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+// needsQuoting tells whether the text value can't be told apart from the next field as is
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	logger, _ := New(Params{})
+	defaultLogger.Store(logger)
+}
+
+// Default returns the logger of the lines not tied to a context, text at info level to os.Stderr
+// until SetDefault is called
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the default logger
+func SetDefault(logger *Logger) {
+	defaultLogger.Store(logger)
+}
+
+type loggerKey struct{}
+
+// NewContext returns the context carrying the logger
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the context, the default logger if it has none
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerText(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Params{Output: &out, Level: "info"})
+	require.NoError(t, err)
+
+	logger = logger.With("request_id", "abc")
+	logger.Debug("dropped")
+	logger.Info("book read", "title", "Go in Action", "rating", 3, "note", `a "quote"`, "empty", "", "dangling")
+	logger.Error("failed", "err", errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^time=\S+ level=info msg="book read" request_id=abc title="Go in Action" rating=3 `+
+		`note="a \\"quote\\"" empty="" dangling=\(MISSING\)$`, lines[0])
+	assert.Regexp(t, `^time=\S+ level=error msg=failed request_id=abc err=boom$`, lines[1])
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Params{Output: &out, Level: "warn", Format: FormatJSON})
+	require.NoError(t, err)
+
+	logger.Info("dropped")
+	logger.With("job", "accrue-fines").Warn("slow", "took", time.Second, "err", errors.New("boom"), "count", 2)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &fields))
+	assert.Equal(t, "warn", fields["level"])
+	assert.Equal(t, "slow", fields["msg"])
+	assert.Equal(t, "accrue-fines", fields["job"])
+	assert.Equal(t, "1s", fields["took"])
+	assert.Equal(t, "boom", fields["err"])
+	assert.Equal(t, float64(2), fields["count"])
+	_, err = time.Parse(time.RFC3339, fields["time"].(string))
+	assert.NoError(t, err)
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Params{Level: "verbose"})
+	assert.Error(t, err)
+	_, err = New(Params{Format: "xml"})
+	assert.Error(t, err)
+
+	level, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+}
+
+func TestContext(t *testing.T) {
+	assert.Same(t, Default(), FromContext(context.Background()))
+
+	logger, err := New(Params{})
+	require.NoError(t, err)
+	assert.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
+
+func TestStorageObserver(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(Params{Output: &out, Level: "info", Format: FormatJSON})
+	require.NoError(t, err)
+	ctx := NewContext(context.Background(), logger.With("request_id", "abc"))
+
+	observe := StorageObserver()
+	observe(ctx, "GetBook", time.Millisecond, nil)
+	observe(ctx, "GetBook", time.Millisecond, storage.ErrBookNotFound)
+	observe(ctx, "ListBooks", time.Millisecond, context.Canceled)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	observe(canceled, "ListBooks", time.Millisecond, errors.New("canceling statement due to user request"))
+	observe(ctx, "ListBooks", 1500*time.Microsecond, errors.New("boom"))
+
+	// only the internal error is logged at info level
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &fields))
+	assert.Equal(t, "error", fields["level"])
+	assert.Equal(t, "abc", fields["request_id"])
+	assert.Equal(t, "ListBooks", fields["method"])
+	assert.Equal(t, "internal", fields["kind"])
+	assert.Equal(t, 1.5, fields["duration_ms"])
+	assert.Equal(t, "boom", fields["err"])
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+)
+
+// StorageObserver returns the observer logging failed storage calls with the logger of their context,
+// see storage.Instrument. Errors the storage can't recover from are logged as errors, the expected
+// ones, e.g. not found or conflicts, at debug level. Calls canceled by their context are not logged,
+// whatever the driver reports
+func StorageObserver() storage.Observer {
+	return func(ctx context.Context, method string, duration time.Duration, err error) {
+		if err == nil || errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+
+		kind := storage.KindOf(err)
+		level := LevelDebug
+		switch kind {
+		case storage.KindInternal, storage.KindUnavailable, storage.KindTimeout:
+			level = LevelError
+		}
+		FromContext(ctx).Log(level, "storage call failed",
+			"method", method,
+			"kind", kind.String(),
+			"duration_ms", DurationMillis(duration),
+			"err", err,
+		)
+	}
+}
+
+// DurationMillis returns the duration in milliseconds with microsecond precision
+func DurationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Number of requests.", "route", "status")
+	latency := r.NewHistogram("latency_seconds", "Latency\nof requests.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("temperature", "Current temperature.", func() float64 { return 21.5 })
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "500")
+	requests.Inc(`say "hi"`+"\n", "200")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+
+	var out bytes.Buffer
+	require.NoError(t, r.Write(&out))
+	assert.Equal(t, `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="500"} 2
+requests_total{route="/b",status="200"} 1
+requests_total{route="say \"hi\"\n",status="200"} 1
+# HELP latency_seconds Latency\nof requests.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 21.5
+`, out.String())
+
+	assert.Panics(t, func() { r.NewCounter("requests_total", "Duplicate.") })
+	assert.Panics(t, func() { requests.Inc("/a") })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, out.String(), rec.Body.String())
+}
+
+func TestStorageObserver(t *testing.T) {
+	r := NewRegistry()
+	observe := StorageObserver(r)
+	ctx := context.Background()
+	observe(ctx, "GetBook", 20*time.Millisecond, nil)
+	observe(ctx, "GetBook", 2*time.Millisecond, storage.ErrBookNotFound)
+	observe(ctx, "ListBooks", time.Millisecond, errors.New("boom"))
+
+	var out bytes.Buffer
+	require.NoError(t, r.Write(&out))
+	assert.Contains(t, out.String(), `storage_call_duration_seconds_count{method="GetBook"} 2`+"\n")
+	assert.Contains(t, out.String(), `storage_call_duration_seconds_bucket{method="GetBook",le="0.005"} 1`+"\n")
+	assert.Contains(t, out.String(), `storage_errors_total{method="GetBook",kind="not_found"} 1`+"\n")
+	assert.Contains(t, out.String(), `storage_errors_total{method="ListBooks",kind="internal"} 1`+"\n")
+}
+
+func TestRuntimeAndDBStats(t *testing.T) {
+	r := NewRegistry()
+	RegisterRuntime(r)
+	RegisterDBStats(r, func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 3, WaitDuration: 1500 * time.Millisecond}
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, r.Write(&out))
+	assert.Contains(t, out.String(), "# TYPE go_goroutines gauge\ngo_goroutines ")
+	assert.Contains(t, out.String(), "# TYPE go_gc_cycles_total counter\n")
+	assert.Contains(t, out.String(), "\ndb_pool_open_connections 3\n")
+	assert.Contains(t, out.String(), "\ndb_pool_wait_seconds_total 1.5\n")
+}
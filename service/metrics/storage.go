@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+)
+
+// StorageObserver registers the metrics of storage calls and returns the observer recording them,
+// see storage.Instrument
+func StorageObserver(r *Registry) storage.Observer {
+	latency := r.NewHistogram("storage_call_duration_seconds", "Latency of storage calls by method.",
+		DefaultBuckets, "method")
+	errs := r.NewCounter("storage_errors_total", "Number of failed storage calls by method and error kind.",
+		"method", "kind")
+
+	return func(_ context.Context, method string, duration time.Duration, err error) {
+		latency.Observe(duration.Seconds(), method)
+		if err != nil {
+			errs.Inc(method, storage.KindOf(err).String())
+		}
+	}
+}
+
+// RegisterDBStats registers the gauges and counters of the connection pool read from stats
+func RegisterDBStats(r *Registry, stats func() sql.DBStats) {
+	var current sql.DBStats
+	r.OnScrape(func() {
+		current = stats()
+	})
+
+	r.NewGaugeFunc("db_pool_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(current.MaxOpenConnections)
+	})
+	r.NewGaugeFunc("db_pool_open_connections", "Number of established connections, in use and idle.", func() float64 {
+		return float64(current.OpenConnections)
+	})
+	r.NewGaugeFunc("db_pool_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(current.InUse)
+	})
+	r.NewGaugeFunc("db_pool_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(current.Idle)
+	})
+	r.NewCounterFunc("db_pool_wait_total", "Total number of connections waited for.", func() float64 {
+		return float64(current.WaitCount)
+	})
+	r.NewCounterFunc("db_pool_wait_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+		return current.WaitDuration.Seconds()
+	})
+	r.NewCounterFunc("db_pool_max_idle_closed_total", "Total number of connections closed due to the idle limit.", func() float64 {
+		return float64(current.MaxIdleClosed)
+	})
+	r.NewCounterFunc("db_pool_max_idle_time_closed_total", "Total number of connections closed due to the idle time.", func() float64 {
+		return float64(current.MaxIdleTimeClosed)
+	})
+	r.NewCounterFunc("db_pool_max_lifetime_closed_total", "Total number of connections closed due to the lifetime.", func() float64 {
+		return float64(current.MaxLifetimeClosed)
+	})
+}
@@ -0,0 +1,149 @@
+// Package outbox delivers the book events queued in the storage outbox to the sinks.
+//
+// Events are committed together with the changes of the books, so none is lost when
+// the service stops, and are removed once every sink took them. A failed delivery is
+// retried with exponential backoff, also to the sinks that already took the event,
+// so sinks must tolerate duplicates (at-least-once delivery). The retried events don't
+// hold up the next ones, sinks order the events by id if they need to.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+type Params struct {
+	Storage storage.OutboxStorage
+	Sinks   []Sink
+	// BatchSize is the number of events fetched at once, defaults to 100
+	BatchSize int
+	// MaxAttempts is the number of deliveries of an event before it's marked failed, 0 means no limit
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed delivery, doubled with every next one up to MaxBackoff.
+	// They default to a second and 5 minutes
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type Dispatcher struct {
+	store       storage.OutboxStorage
+	sinks       []Sink
+	batchSize   int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+func NewDispatcher(params Params) *Dispatcher {
+	d := &Dispatcher{
+		store:       params.Storage,
+		sinks:       params.Sinks,
+		batchSize:   params.BatchSize,
+		maxAttempts: params.MaxAttempts,
+		minBackoff:  params.MinBackoff,
+		maxBackoff:  params.MaxBackoff,
+	}
+	if d.batchSize <= 0 {
+		d.batchSize = 100
+	}
+	if d.minBackoff <= 0 {
+		d.minBackoff = time.Second
+	}
+	if d.maxBackoff <= 0 {
+		d.maxBackoff = 5 * time.Minute
+	}
+	return d
+}
+
+// Dispatch delivers the pending events by id until none is left or a whole batch failed.
+// A failed event is retried after its backoff while the events after it are delivered,
+// so events may reach the sinks out of order. It returns the number of events delivered
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var delivered int
+	for {
+		events, err := d.store.PendingEvents(ctx, d.batchSize)
+		if err != nil {
+			return delivered, err
+		}
+
+		var batchDelivered int
+		for _, event := range events {
+			if err = d.deliver(ctx, event); err != nil {
+				if err = d.fail(ctx, event, err); err != nil {
+					return delivered, err
+				}
+				continue
+			}
+
+			if err = d.store.AckEvent(ctx, event.ID); err != nil {
+				return delivered, err
+			}
+			batchDelivered++
+		}
+
+		delivered += batchDelivered
+		if len(events) < d.batchSize || batchDelivered == 0 {
+			return delivered, nil
+		}
+	}
+}
+
+// deliver hands the event to every sink, it fails if any of them failed
+func (d *Dispatcher) deliver(ctx context.Context, event *models.Event) error {
+	msg := ConvertEvent(event)
+
+	var failures []string
+	for _, sink := range d.sinks {
+		if err := sink.Deliver(ctx, msg); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sink.Name(), err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to deliver event %d: %s", event.ID, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (d *Dispatcher) fail(ctx context.Context, event *models.Event, cause error) error {
+	attempts := event.Attempts + 1
+	if d.maxAttempts > 0 && attempts >= d.maxAttempts {
+		return d.store.FailEvent(ctx, event.ID, cause.Error())
+	}
+	return d.store.RetryEvent(ctx, event.ID, d.backoff(attempts), cause.Error())
+}
+
+// backoff returns the delay after the given number of failed deliveries
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	return Backoff(d.minBackoff, d.maxBackoff, attempts)
+}
+
+// Backoff returns the delay after the given number of failed deliveries, min doubled
+// with every failure after the first one up to max
+func Backoff(min, max time.Duration, attempts int) time.Duration {
+	delay := min
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// ConvertEvent returns the event in the form delivered to consumers
+func ConvertEvent(in *models.Event) *api.Event {
+	return &api.Event{
+		ID:         in.ID,
+		Type:       string(in.Type),
+		BookID:     in.BookID,
+		OccurredAt: in.CreatedAt.Format(time.RFC3339),
+		Book:       in.Payload,
+	}
+}
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySink fails the first deliveries
+type flakySink struct {
+	failures  int
+	delivered []*api.Event
+}
+
+func (s *flakySink) Name() string {
+	return "flaky"
+}
+
+func (s *flakySink) Deliver(_ context.Context, event *api.Event) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.delivered = append(s.delivered, event)
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+
+	var received []api.Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event api.Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received = append(received, event)
+	}))
+	defer receiver.Close()
+
+	var file bytes.Buffer
+	sink := &flakySink{failures: 1}
+	d := NewDispatcher(Params{
+		Storage:    store,
+		Sinks:      []Sink{NewHTTPSink(receiver.URL, time.Second), NewWriterSink("buffer", &file), sink},
+		MinBackoff: time.Millisecond,
+	})
+
+	bookID, err := store.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteBook(ctx, *bookID, 0))
+
+	// the first event fails on one sink and is retried after the backoff, the second one goes on
+	delivered, err := d.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	require.Len(t, sink.delivered, 1)
+	assert.Equal(t, "book.deleted", sink.delivered[0].Type)
+
+	time.Sleep(5 * time.Millisecond)
+	delivered, err = d.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, "book.created", sink.delivered[1].Type)
+	assert.Equal(t, *bookID, sink.delivered[1].BookID)
+
+	// at least once: sinks that took the failed event get it again
+	require.Len(t, received, 3)
+	assert.Equal(t, []string{"book.created", "book.deleted", "book.created"},
+		[]string{received[0].Type, received[1].Type, received[2].Type})
+	var lines int
+	for scanner := bufio.NewScanner(&file); scanner.Scan(); lines++ {
+		var event api.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+	}
+	assert.Equal(t, 3, lines)
+
+	events, err := store.PendingEvents(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestDispatchGivesUp(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	d := NewDispatcher(Params{
+		Storage:     store,
+		Sinks:       []Sink{&flakySink{failures: 2}},
+		MaxAttempts: 2,
+		MinBackoff:  time.Millisecond,
+	})
+
+	_, err := store.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(2 * time.Millisecond)
+		delivered, err := d.Dispatch(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, delivered)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	events, err := store.PendingEvents(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(Params{MinBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 4*time.Second, d.backoff(3))
+	assert.Equal(t, 10*time.Second, d.backoff(5))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Deliver(context.Background(), &api.Event{ID: 1, Type: "book.created"}))
+	require.NoError(t, sink.Close())
+	assert.Error(t, sink.Deliver(context.Background(), &api.Event{ID: 2, Type: "book.updated"}))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var event api.Event
+	require.NoError(t, json.Unmarshal(content, &event))
+	assert.Equal(t, int64(1), event.ID)
+
+	// writers of other sinks are not theirs to close
+	var buffer bytes.Buffer
+	assert.NoError(t, NewWriterSink("buffer", &buffer).Close())
+}
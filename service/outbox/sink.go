@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+)
+
+// Sink delivers events to a consumer. A delivery is successful once Deliver returns no error
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event *api.Event) error
+}
+
+// HTTPSink posts every event as JSON to the URL, any status but 2xx fails the delivery
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSink) Name() string {
+	return "webhook " + s.url
+}
+
+func (s *HTTPSink) Deliver(ctx context.Context, event *api.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WriterSink writes every event as a line of JSON (NDJSON) to the writer
+type WriterSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+	// closer is the file opened by NewFileSink
+	closer io.Closer
+}
+
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{
+		name: name,
+		w:    w,
+	}
+}
+
+// NewFileSink appends the events to the NDJSON file, creating it if needed
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewWriterSink("file "+path, file)
+	sink.closer = file
+	return sink, nil
+}
+
+// Close closes the file of a file sink, the writers given to NewWriterSink are left open
+func (s *WriterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closer.Close()
+}
+
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+func (s *WriterSink) Deliver(_ context.Context, event *api.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(payload, '\n'))
+	return err
+}
@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/outbox"
+	"github.com/alexkaplun/books-test/service/webhook"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// AccrueFinesJob fines overdue loans by the policy
+func AccrueFinesJob(store storage.Storage, policy models.FinePolicy, interval time.Duration) Job {
+	return Job{
+		Name:     "accrue-fines",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			accrued, err := store.AccrueFines(ctx, policy)
+			if err != nil {
+				return err
+			}
+			if accrued > 0 {
+				logging.FromContext(ctx).Info("accrued fines", "fines", accrued)
+			}
+			return nil
+		},
+	}
+}
+
+// ExpireHoldsJob expires holds that were not picked up in time, so the books move on
+// to the next member even when nobody touches them
+func ExpireHoldsJob(store storage.Storage, interval time.Duration) Job {
+	return Job{
+		Name:     "expire-holds",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			expired, err := store.ExpireHolds(ctx)
+			if err != nil {
+				return err
+			}
+			if expired > 0 {
+				logging.FromContext(ctx).Info("expired holds", "books", expired)
+			}
+			return nil
+		},
+	}
+}
+
+// PurgeBooksJob permanently deletes books kept in the trash for longer than the retention
+func PurgeBooksJob(store storage.Storage, retention, interval time.Duration) Job {
+	return Job{
+		Name:     "purge-books",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			purged, err := store.PurgeBooks(ctx, retention)
+			if err != nil {
+				return err
+			}
+			if purged > 0 {
+				logging.FromContext(ctx).Info("purged books from the trash", "books", purged)
+			}
+			return nil
+		},
+	}
+}
+
+// DispatchEventsJob delivers the events of book changes from the outbox. Running it on a single
+// replica keeps the replicas from delivering the same events at once
+func DispatchEventsJob(dispatcher *outbox.Dispatcher, interval time.Duration) Job {
+	return Job{
+		Name:     "dispatch-events",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			delivered, err := dispatcher.Dispatch(ctx)
+			if err != nil {
+				return err
+			}
+			if delivered > 0 {
+				logging.FromContext(ctx).Info("delivered events", "events", delivered)
+			}
+			return nil
+		},
+	}
+}
+
+// DeliverWebhooksJob attempts the pending deliveries of events to the webhooks
+func DeliverWebhooksJob(deliverer *webhook.Deliverer, interval time.Duration) Job {
+	return Job{
+		Name:     "deliver-webhooks",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			delivered, err := deliverer.Deliver(ctx)
+			if err != nil {
+				return err
+			}
+			if delivered > 0 {
+				logging.FromContext(ctx).Info("delivered webhook deliveries", "deliveries", delivered)
+			}
+			return nil
+		},
+	}
+}
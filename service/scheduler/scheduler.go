@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/logging"
+)
+
+// Locker elects the replica running a job
+type Locker interface {
+	// TryLock takes the lock of the key without waiting, see storage.Storage
+	TryLock(ctx context.Context, key int64) (unlock func(), acquired bool, err error)
+}
+
+// Job is run every Interval by a single replica at a time
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Scheduler struct {
+	locker Locker
+	jobs   []Job
+	wg     sync.WaitGroup
+}
+
+func New(locker Locker, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		locker: locker,
+		jobs:   jobs,
+	}
+}
+
+// Start runs every job right away and then on its interval until ctx is done.
+// Jobs without a positive interval are disabled
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		if job.Interval <= 0 {
+			continue
+		}
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+}
+
+// Wait blocks until all jobs stopped after the Start context is done
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce runs the job if no other replica is running it. The job logs with its name
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	logger := logging.FromContext(ctx).With("job", job.Name)
+	ctx = logging.NewContext(ctx, logger)
+
+	unlock, acquired, err := s.locker.TryLock(ctx, lockKey(job.Name))
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("failed to lock job", "err", err)
+		}
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer unlock()
+
+	if err = job.Run(ctx); err != nil && ctx.Err() == nil {
+		logger.Error("failed to run job", "err", err)
+	}
+}
+
+// lockKey derives the lock key of the job from its name
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("books-scheduler:" + name))
+	return int64(h.Sum64())
+}
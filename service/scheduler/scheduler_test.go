@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerSingleLeader(t *testing.T) {
+	store := storage.NewMemory()
+
+	var runs int
+	job := Job{
+		Name:     "test",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			runs++
+			return nil
+		},
+	}
+
+	// another replica is running the job
+	unlock, acquired, err := store.TryLock(context.Background(), lockKey(job.Name))
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	s := New(store, job)
+	s.runOnce(context.Background(), job)
+	assert.Equal(t, 0, runs)
+
+	unlock()
+	s.runOnce(context.Background(), job)
+	assert.Equal(t, 1, runs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	cancel()
+	s.Wait()
+	assert.Equal(t, 2, runs)
+}
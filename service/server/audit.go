@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// actorHeader names who makes the request. The service has no authentication,
+// so it's trusted as sent
+const actorHeader = "X-Actor"
+
+// validActor limits the actors accepted from clients
+var validActor = regexp.MustCompile(`^[^\x00-\x1f\x7f]{1,255}$`)
+
+// withAuditInfo records the actor and the id of the request with the changes it makes.
+// It expects the request id to be set already
+func withAuditInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := storage.AuditInfo{
+			Actor:     storage.AnonymousActor,
+			RequestID: RequestID(r.Context()),
+		}
+		if actor := r.Header.Get(actorHeader); validActor.MatchString(actor) {
+			info.Actor = actor
+		}
+
+		next.ServeHTTP(w, r.WithContext(storage.WithAuditInfo(r.Context(), info)))
+	})
+}
+
+func (h *Handler) listBookHistoryHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	query, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse audit query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.storage.ListBookHistory(r.Context(), bookID, *query)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list book history", "err", err)
+		writeStorageError(w, r, err, "failed to list book history")
+		return
+	}
+
+	jsonOK(w, &api.ListAuditResponse{
+		Items:      convertAuditEntriesFromDB(result.Entries),
+		NextCursor: result.NextCursor,
+	})
+}
+
+func (h *Handler) listAuditHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	query, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse audit query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.storage.ListAudit(r.Context(), *query)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list audit", "err", err)
+		writeStorageError(w, r, err, "failed to list audit")
+		return
+	}
+
+	jsonOK(w, &api.ListAuditResponse{
+		Items:      convertAuditEntriesFromDB(result.Entries),
+		NextCursor: result.NextCursor,
+	})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) createCopyHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	var req api.UpsertCopyRequest
+	if err = parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	item := convertCopyToDB(&req)
+	item.BookID = bookID
+
+	id, err := h.storage.CreateCopy(r.Context(), item)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to save copy to DB", "err", err)
+		writeStorageError(w, r, err, "failed to save copy to DB")
+		return
+	}
+
+	jsonOK(w, &api.CreateCopyResponse{
+		ID: id,
+	})
+}
+
+func (h *Handler) updateCopyHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookID, copyID, ok := parseCopyPath(w, r, p)
+	if !ok {
+		return
+	}
+
+	var req api.UpsertCopyRequest
+	if err := parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	item := convertCopyToDB(&req)
+	item.ID = copyID
+	item.BookID = bookID
+
+	if err := h.storage.UpdateCopy(r.Context(), item); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to update copy", "err", err)
+		writeStorageError(w, r, err, "failed to update copy")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) deleteCopyHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookID, copyID, ok := parseCopyPath(w, r, p)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteCopy(r.Context(), bookID, copyID); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to delete copy", "err", err)
+		writeStorageError(w, r, err, "failed to delete copy")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) getCopyHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookID, copyID, ok := parseCopyPath(w, r, p)
+	if !ok {
+		return
+	}
+
+	item, err := h.storage.GetCopy(r.Context(), bookID, copyID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to find copy", "err", err)
+		writeStorageError(w, r, err, "failed to find copy")
+		return
+	}
+
+	jsonOK(w, convertCopyFromDB(item))
+}
+
+func (h *Handler) listCopiesHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	copies, err := h.storage.ListCopies(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list copies", "err", err)
+		writeStorageError(w, r, err, "failed to list copies")
+		return
+	}
+
+	jsonOK(w, &api.ListCopiesResponse{
+		Items: convertCopiesFromDB(copies),
+	})
+}
+
+// parseCopyPath parses the book and the copy ids of /books/:id/copies/:copyId,
+// writing the error response when any is invalid
+func parseCopyPath(w http.ResponseWriter, r *http.Request, p httprouter.Params) (uuid.UUID, uuid.UUID, bool) {
+	bookID, err := uuid.Parse(p.ByName("id"))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	copyID, err := uuid.Parse(p.ByName("copyId"))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse copy id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse copy id")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return bookID, copyID, true
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+const mediaTypeProblem = "application/problem+json"
+
+// statusClientClosedRequest is the status of requests the client went away from, it's only logged
+const statusClientClosedRequest = 499
+
+// errorCodes are the problem codes of storage errors
+var errorCodes = map[error]string{
+	storage.ErrBookNotFound:          "book_not_found",
+	storage.ErrBookVersionMismatch:   "book_changed",
+	storage.ErrBookCheckedOut:        "book_checked_out",
+	storage.ErrBookNotCheckedOut:     "book_not_checked_out",
+	storage.ErrBookAvailable:         "book_available",
+	storage.ErrBookOnHold:            "book_on_hold",
+	storage.ErrHoldNotFound:          "hold_not_found",
+	storage.ErrHoldExists:            "hold_exists",
+	storage.ErrHoldOwnLoan:           "hold_own_loan",
+	storage.ErrHoldNotActive:         "hold_not_active",
+	storage.ErrCopyNotFound:          "copy_not_found",
+	storage.ErrCopyExists:            "copy_exists",
+	storage.ErrFineNotFound:          "fine_not_found",
+	storage.ErrFinePaid:              "fine_paid",
+	storage.ErrFineAccruing:          "fine_accruing",
+	storage.ErrWebhookNotFound:       "webhook_not_found",
+	storage.ErrMemberNotFound:        "member_not_found",
+	storage.ErrMemberExists:          "member_exists",
+	storage.ErrMemberHasLoans:        "member_has_loans",
+	storage.ErrMemberHasHolds:        "member_has_holds",
+	storage.ErrMemberSuspended:       "member_suspended",
+	storage.ErrBorrowingLimitReached: "borrowing_limit_reached",
+	storage.ErrInvalidCursor:         "invalid_cursor",
+	storage.ErrInvalidSort:           "invalid_sort",
+	storage.ErrEmptySearchQuery:      "empty_search_query",
+	errPatchTestFailed:               "patch_test_failed",
+}
+
+// statusCodes are the problem codes of errors without their own code
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           api.CodeBadRequest,
+	http.StatusNotFound:             api.CodeNotFound,
+	http.StatusMethodNotAllowed:     api.CodeMethodNotAllowed,
+	http.StatusConflict:             api.CodeConflict,
+	http.StatusPreconditionFailed:   api.CodePreconditionFailed,
+	http.StatusUnsupportedMediaType: api.CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:  api.CodeInvalidData,
+	http.StatusInternalServerError:  api.CodeInternal,
+	http.StatusServiceUnavailable:   api.CodeUnavailable,
+	http.StatusGatewayTimeout:       api.CodeTimeout,
+	statusClientClosedRequest:       api.CodeCanceled,
+}
+
+// kindStatuses are the response statuses of storage error kinds, internal errors are 500
+var kindStatuses = map[storage.Kind]int{
+	storage.KindNotFound:     http.StatusNotFound,
+	storage.KindConflict:     http.StatusConflict,
+	storage.KindInvalid:      http.StatusUnprocessableEntity,
+	storage.KindPrecondition: http.StatusPreconditionFailed,
+	storage.KindUnavailable:  http.StatusServiceUnavailable,
+	storage.KindTimeout:      http.StatusGatewayTimeout,
+	storage.KindCanceled:     statusClientClosedRequest,
+}
+
+// writeError writes the problem of the error, coded by the error when it's known
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	writeProblem(w, r, status, errorCode(status, err), err.Error())
+}
+
+// writeStorageError writes the problem of the storage error with the status of its kind.
+// Internal errors are written with the fallback detail, so they don't leak to clients. Calls failed
+// after the client went away are not the storage's fault, Postgres reports their cancellation as a timeout
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		writeProblem(w, r, statusClientClosedRequest, api.CodeCanceled, "request canceled")
+		return
+	}
+
+	status, ok := kindStatuses[storage.KindOf(err)]
+	if !ok {
+		writeProblem(w, r, http.StatusInternalServerError, api.CodeInternal, fallback)
+		return
+	}
+
+	writeProblem(w, r, status, errorCode(status, err), storageErrorDetail(err, fallback))
+}
+
+// storageErrorDetail describes the storage error for clients. Database errors are described
+// without the driver message and internal errors with the fallback
+func storageErrorDetail(err error, fallback string) string {
+	if _, ok := kindStatuses[storage.KindOf(err)]; !ok {
+		return fallback
+	}
+
+	var storageErr *storage.Error
+	if errors.As(err, &storageErr) && storageErr.Unwrap() != nil {
+		return storageErr.Message()
+	}
+	return err.Error()
+}
+
+func errorCode(status int, err error) string {
+	for known, knownCode := range errorCodes {
+		if errors.Is(err, known) {
+			return knownCode
+		}
+	}
+	return statusCodes[status]
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	sendProblem(w, &api.Problem{
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: RequestID(r.Context()),
+	})
+}
+
+// writeValidationProblem writes the problem listing the invalid fields of the validation error
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, err error) {
+	problem := &api.Problem{
+		Status:    http.StatusBadRequest,
+		Code:      api.CodeValidationFailed,
+		Detail:    "the request has invalid fields",
+		Instance:  r.URL.Path,
+		RequestID: RequestID(r.Context()),
+	}
+
+	if problem.Errors = fieldErrors(err); problem.Errors == nil {
+		problem.Detail = err.Error()
+	}
+
+	sendProblem(w, problem)
+}
+
+// fieldErrors lists the invalid fields of the validation error ordered by field, nil for other errors
+func fieldErrors(err error) []api.FieldError {
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) {
+		return nil
+	}
+
+	var errs []api.FieldError
+	for field, fieldErr := range fieldErrs {
+		errs = append(errs, api.FieldError{
+			Field:   field,
+			Message: fieldErr.Error(),
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+func sendProblem(w http.ResponseWriter, problem *api.Problem) {
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	if problem.RequestID == "" {
+		problem.RequestID = w.Header().Get(requestIDHeader)
+	}
+
+	payload, err := json.Marshal(problem)
+	if err != nil {
+		logging.Default().Error("failed to marshal problem", "request_id", problem.RequestID, "err", err)
+		w.WriteHeader(problem.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(payload)
+}
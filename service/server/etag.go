@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// bookETag returns the strong entity tag of the book, derived from its version
+func bookETag(book *models.Book) string {
+	return strconv.Quote(strconv.Itoa(book.Version))
+}
+
+// etagListed reports whether the etag is in the If-Match or If-None-Match header value.
+// Weak comparison, used by If-None-Match, ignores the W/ prefix of listed tags
+func etagListed(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion evaluates the If-Match header of the request against the current book.
+// It returns the version the write must apply to, 0 when the request is unconditional,
+// and writes the error response when the precondition fails
+func (h *Handler) ifMatchVersion(w http.ResponseWriter, r *http.Request, bookID uuid.UUID) (int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, true
+	}
+
+	current, err := h.storage.GetBook(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to find book", "err", err)
+		writeStorageError(w, r, err, "failed to find book")
+		return 0, false
+	}
+
+	if !etagListed(ifMatch, bookETag(current), false) {
+		writeError(w, r, http.StatusPreconditionFailed, storage.ErrBookVersionMismatch)
+		return 0, false
+	}
+
+	return current.Version, true
+}
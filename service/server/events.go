@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// heartbeatInterval keeps idle streams open through proxies
+const heartbeatInterval = 15 * time.Second
+
+// bookEventsHandler streams the events of book changes as Server-Sent Events. Clients resume
+// after the last event they received with Last-Event-ID, which EventSource sends when reconnecting
+func (h *Handler) bookEventsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logging.FromContext(r.Context()).Error("failed to stream events", "err", "response writer can't flush")
+		writeProblem(w, r, http.StatusInternalServerError, api.CodeInternal, "failed to stream events")
+		return
+	}
+
+	var lastEventID *int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			logging.FromContext(r.Context()).Info("failed to parse last event id", "err", err)
+			writeProblem(w, r, http.StatusBadRequest, api.CodeBadRequest, "invalid Last-Event-ID: must be an event id")
+			return
+		}
+		lastEventID = &id
+	}
+
+	// every write renews the write deadline of the server, so the stream outlives the write timeout
+	// while a client that stops reading fails the next write
+	extendWriteDeadline(w, h.writeTimeout)
+
+	sub := h.events.Subscribe(lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// reset tells the client that events were missed, it has to reload the books
+	if sub.Reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range sub.Replay {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			// dropped for falling behind, the client resumes from the replay buffer
+			if !ok {
+				return
+			}
+			extendWriteDeadline(w, h.writeTimeout)
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			extendWriteDeadline(w, h.writeTimeout)
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *api.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+	return err
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// exportContentTypes are the content types of the export formats
+var exportContentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+	FormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportColumn is a column of CSV and XLSX exports, named like the JSON field
+type exportColumn struct {
+	name  string
+	value func(book *api.Book) string
+	// numeric columns are written as numbers to XLSX
+	numeric bool
+}
+
+var exportColumns = []exportColumn{
+	{name: "id", value: func(b *api.Book) string { return b.ID.String() }},
+	{name: "title", value: func(b *api.Book) string { return b.Title }},
+	{name: "author", value: func(b *api.Book) string { return b.Author }},
+	{name: "publisher", value: func(b *api.Book) string { return b.Publisher }},
+	{name: "publishDate", value: func(b *api.Book) string { return b.PublishDate }},
+	{name: "rating", value: func(b *api.Book) string { return strconv.Itoa(b.Rating) }, numeric: true},
+	{name: "status", value: func(b *api.Book) string { return b.Status }},
+	{name: "createdAt", value: func(b *api.Book) string { return b.CreatedAt }},
+	{name: "updatedAt", value: func(b *api.Book) string { return b.UpdatedAt }},
+	{name: "version", value: func(b *api.Book) string { return strconv.Itoa(b.Version) }, numeric: true},
+	{name: "copiesTotal", value: func(b *api.Book) string { return strconv.Itoa(b.Copies.Total) }, numeric: true},
+	{name: "copiesAvailable", value: func(b *api.Book) string { return strconv.Itoa(b.Copies.Available) }, numeric: true},
+}
+
+// ExportBooks writes the books matching the filters of the query to the output in the format,
+// as they are read from the storage. Only a batch of books is held in memory at a time
+func ExportBooks(ctx context.Context, store storage.Storage, output io.Writer, format string, query storage.ListBooksQuery) error {
+	buf := bufio.NewWriter(output)
+
+	var writer exportWriter
+	switch format {
+	case FormatCSV:
+		writer = newCSVExportWriter(buf)
+	case FormatNDJSON:
+		writer = &ndjsonExportWriter{encoder: json.NewEncoder(buf)}
+	case FormatXLSX:
+		writer = newXLSXExportWriter(buf)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	err := store.ExportBooks(ctx, query, func(book *models.Book) error {
+		return writer.write(convertBookFromDB(book))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = writer.close(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// exportWriter writes the books of an export one at a time
+type exportWriter interface {
+	write(book *api.Book) error
+	// close completes the output after the last book
+	close() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVExportWriter(output io.Writer) *csvExportWriter {
+	w := &csvExportWriter{
+		writer: csv.NewWriter(output),
+		record: make([]string, len(exportColumns)),
+	}
+	for i, column := range exportColumns {
+		w.record[i] = column.name
+	}
+	// the header is buffered until the first flush, like the books
+	w.writer.Write(w.record)
+	return w
+}
+
+func (w *csvExportWriter) write(book *api.Book) error {
+	for i, column := range exportColumns {
+		w.record[i] = column.value(book)
+	}
+	return w.writer.Write(w.record)
+}
+
+func (w *csvExportWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) write(book *api.Book) error {
+	return w.encoder.Encode(book)
+}
+
+func (w *ndjsonExportWriter) close() error {
+	return nil
+}
+
+// The parts of a workbook with a single sheet, other than the sheet itself
+const (
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+
+	xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Books" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+
+	xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+
+	xlsxSheetStart = xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd   = `</sheetData></worksheet>`
+)
+
+// xlsxExportWriter streams the workbook as a zip archive, the sheet is the last part
+// and is written row by row with inline strings, so nothing is kept for the end
+type xlsxExportWriter struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	err     error
+}
+
+func newXLSXExportWriter(output io.Writer) *xlsxExportWriter {
+	w := &xlsxExportWriter{archive: zip.NewWriter(output)}
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		w.writePart(part.name, part.content)
+	}
+
+	if w.err == nil {
+		w.sheet, w.err = w.archive.Create("xl/worksheets/sheet1.xml")
+	}
+	w.writeString(xlsxSheetStart)
+
+	header := &strings.Builder{}
+	header.WriteString("<row>")
+	for _, column := range exportColumns {
+		writeXLSXCell(header, column.name, false)
+	}
+	header.WriteString("</row>")
+	w.writeString(header.String())
+
+	return w
+}
+
+func (w *xlsxExportWriter) writePart(name, content string) {
+	if w.err != nil {
+		return
+	}
+	var part io.Writer
+	if part, w.err = w.archive.Create(name); w.err == nil {
+		_, w.err = io.WriteString(part, content)
+	}
+}
+
+func (w *xlsxExportWriter) writeString(s string) {
+	if w.err == nil {
+		_, w.err = io.WriteString(w.sheet, s)
+	}
+}
+
+func (w *xlsxExportWriter) write(book *api.Book) error {
+	row := &strings.Builder{}
+	row.WriteString("<row>")
+	for _, column := range exportColumns {
+		writeXLSXCell(row, column.value(book), column.numeric)
+	}
+	row.WriteString("</row>")
+
+	w.writeString(row.String())
+	return w.err
+}
+
+func (w *xlsxExportWriter) close() error {
+	w.writeString(xlsxSheetEnd)
+	if w.err != nil {
+		return w.err
+	}
+	return w.archive.Close()
+}
+
+// writeXLSXCell writes a cell without a reference, cells are placed one after another
+func writeXLSXCell(row *strings.Builder, value string, numeric bool) {
+	if numeric {
+		row.WriteString("<c><v>")
+		row.WriteString(value)
+		row.WriteString("</v></c>")
+		return
+	}
+
+	row.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	// invalid XML characters are replaced, so the sheet stays readable
+	xml.EscapeText(row, []byte(value))
+	row.WriteString("</t></is></c>")
+}
+
+// exportResponse sets the headers of the export on the first write, so errors
+// occurring before anything is written are still sent as problems. Every write moves
+// the write deadline by the timeout, so exports of any size are streamed while clients
+// that stop reading are still cut off
+type exportResponse struct {
+	http.ResponseWriter
+	format  string
+	timeout time.Duration
+	started bool
+}
+
+func (r *exportResponse) Write(p []byte) (int, error) {
+	extendWriteDeadline(r.ResponseWriter, r.timeout)
+	if !r.started {
+		r.started = true
+		r.Header().Set("Content-Type", exportContentTypes[r.format])
+		r.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books.%s"`, r.format))
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// exportBooksHandler streams the books matching the list filters in the format param, CSV by default.
+// The paging params are ignored
+func (h *Handler) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	defer h.guardPanic()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		writeError(w, r, http.StatusBadRequest, errors.New("invalid format: must be csv, ndjson or xlsx"))
+		return
+	}
+
+	query, err := parseListBooksQuery(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse export query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	response := &exportResponse{ResponseWriter: w, format: format, timeout: h.writeTimeout}
+	if err = ExportBooks(r.Context(), h.storage, response, format, *query); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to export books", "err", err)
+		if response.started {
+			// the status is sent already, abort the response so clients don't take it as complete
+			panic(http.ErrAbortHandler)
+		}
+		if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrInvalidSort) {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		writeStorageError(w, r, err, "failed to export books")
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) listMemberFinesHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	memberIDStr := p.ByName("id")
+	memberID, err := uuid.Parse(memberIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse member id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse member id")
+		return
+	}
+
+	fines, err := h.storage.ListMemberFines(r.Context(), memberID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list fines", "err", err)
+		writeStorageError(w, r, err, "failed to list fines")
+		return
+	}
+
+	resp := &api.ListFinesResponse{
+		Items: make([]*api.Fine, len(fines)),
+	}
+	for i, fine := range fines {
+		resp.Items[i] = convertFineFromDB(fine)
+		if fine.Status == models.FineStatusOpen {
+			resp.Outstanding += fine.Amount
+		}
+	}
+
+	jsonOK(w, resp)
+}
+
+func (h *Handler) payFineHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	fineIDStr := p.ByName("id")
+	fineID, err := uuid.Parse(fineIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse fine id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse fine id")
+		return
+	}
+
+	fine, err := h.storage.PayFine(r.Context(), fineID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to pay fine", "err", err)
+		writeStorageError(w, r, err, "failed to pay fine")
+		return
+	}
+
+	jsonOK(w, convertFineFromDB(fine))
+}
@@ -1,30 +1,82 @@
 package server
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"runtime/debug"
+	"time"
 
 	"github.com/alexkaplun/books-test/storage/models"
 
 	"github.com/google/uuid"
 
 	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/feed"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/webhook"
 	"github.com/alexkaplun/books-test/storage"
 	"github.com/julienschmidt/httprouter"
 )
 
+const (
+	defaultLoanPeriod       = 14 * 24 * time.Hour
+	defaultHoldPickupWindow = 3 * 24 * time.Hour
+)
+
 type Handler struct {
-	storage storage.Storage
+	storage          storage.Storage
+	loanPeriod       time.Duration
+	holdPickupWindow time.Duration
+	webhooks         *webhook.Deliverer
+	events           *feed.Hub
+	health           *Health
+	writeTimeout     time.Duration
 }
 
 type HandlerParams struct {
 	Storage storage.Storage
+	// LoanPeriod is the default loan duration, 14 days if not set
+	LoanPeriod time.Duration
+	// HoldPickupWindow is how long a returned book waits for the member of the first hold,
+	// 3 days if not set
+	HoldPickupWindow time.Duration
+	// Webhooks sends the test deliveries of webhooks, a deliverer with the defaults if not set
+	Webhooks *webhook.Deliverer
+	// Events feeds the stream of book events, it's required and must be started
+	Events *feed.Hub
+	// Health checks the readiness, with the defaults if not set
+	Health *Health
+	// WriteTimeout is the write timeout of the server. Exports are given that long for every
+	// write rather than for the whole response, no timeout if not set
+	WriteTimeout time.Duration
 }
 
 func NewHandler(params HandlerParams) *Handler {
-	return &Handler{
-		storage: params.Storage,
+	h := &Handler{
+		storage:          params.Storage,
+		loanPeriod:       params.LoanPeriod,
+		holdPickupWindow: params.HoldPickupWindow,
+		webhooks:         params.Webhooks,
+		events:           params.Events,
+		health:           params.Health,
+		writeTimeout:     params.WriteTimeout,
+	}
+	if h.loanPeriod <= 0 {
+		h.loanPeriod = defaultLoanPeriod
+	}
+	if h.holdPickupWindow <= 0 {
+		h.holdPickupWindow = defaultHoldPickupWindow
+	}
+	if h.webhooks == nil {
+		h.webhooks = webhook.NewDeliverer(webhook.Params{Storage: params.Storage})
+	}
+	if h.health == nil {
+		h.health = NewHealth(HealthParams{Storage: params.Storage})
 	}
+	return h
 }
 
 func (h *Handler) createBookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -32,28 +84,28 @@ func (h *Handler) createBookHandler(w http.ResponseWriter, r *http.Request, _ ht
 
 	var req api.UpsertBookRequest
 	if err := parseBody(r.Body, &req); err != nil {
-		log.Printf("failed to parse request body. err: %v\n", err)
-		http.Error(w, "failed to parse request body", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
 		return
 	}
 
 	if err := req.Validate(); err != nil {
-		log.Printf("failed to validate request. err: %v\n", err)
-		http.Error(w, "failed to validate request", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
 		return
 	}
 
 	book, err := convertBookToDB(&req)
 	if err != nil {
-		log.Printf("failed to convert book request to DB. err: %v\n", err)
-		http.Error(w, "failed to convert book request to DB", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to convert book request to DB", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to convert book request to DB")
 		return
 	}
 
 	id, err := h.storage.CreateBook(r.Context(), book)
 	if err != nil {
-		log.Printf("failed to save book to DB. err: %v\n", err)
-		http.Error(w, "failed to save book to DB", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Warn("failed to save book to DB", "err", err)
+		writeStorageError(w, r, err, "failed to save book to DB")
 		return
 	}
 
@@ -70,14 +122,19 @@ func (h *Handler) deleteBookHandler(w http.ResponseWriter, r *http.Request, p ht
 	bookIDStr := p.ByName("id")
 	bookID, err := uuid.Parse(bookIDStr)
 	if err != nil {
-		log.Printf("failed to parse book id. err: %v\n", err)
-		http.Error(w, "failed to parse book id", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
 		return
 	}
 
-	if err := h.storage.DeleteBook(r.Context(), bookID); err != nil {
-		log.Printf("failed to delete book. err: %v\n", err)
-		http.Error(w, "failed to delete book", http.StatusInternalServerError)
+	version, ok := h.ifMatchVersion(w, r, bookID)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.DeleteBook(r.Context(), bookID, version); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to delete book", "err", err)
+		writeStorageError(w, r, err, "failed to delete book")
 		return
 	}
 
@@ -90,45 +147,148 @@ func (h *Handler) updateBookHandler(w http.ResponseWriter, r *http.Request, p ht
 	bookIDStr := p.ByName("id")
 	bookID, err := uuid.Parse(bookIDStr)
 	if err != nil {
-		log.Printf("failed to parse book id. err: %v\n", err)
-		http.Error(w, "failed to parse book id", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
 		return
 	}
 
 	var req api.UpsertBookRequest
 	if err = parseBody(r.Body, &req); err != nil {
-		log.Printf("failed to parse request body. err: %v\n", err)
-		http.Error(w, "failed to parse request body", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
 		return
 	}
 
 	if err = req.Validate(); err != nil {
-		log.Printf("failed to validate request. err: %v\n", err)
-		http.Error(w, "failed to validate request", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
 		return
 	}
 
 	var book *models.Book
 	book, err = convertBookToDB(&req)
 	if err != nil {
-		log.Printf("failed to convert book request to DB. err: %v\n", err)
-		http.Error(w, "failed to convert book request to DB", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to convert book request to DB", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to convert book request to DB")
 		return
 	}
 
 	book.ID = bookID
 
+	var ok bool
+	if book.Version, ok = h.ifMatchVersion(w, r, bookID); !ok {
+		return
+	}
+
 	if err = h.storage.UpdateBook(r.Context(), book); err != nil {
-		log.Printf("failed to update book. err: %v\n", err)
-		if err == storage.ErrBookNotFound {
-			http.Error(w, "book not found", http.StatusNotFound)
+		logging.FromContext(r.Context()).Warn("failed to update book", "err", err)
+		writeStorageError(w, r, err, "failed to update book")
+		return
+	}
+
+	w.Header().Set("ETag", bookETag(book))
+	jsonOK(w, nil)
+}
+
+// patchBookHandler applies a JSON Merge Patch or, with the application/json-patch+json
+// content type, a JSON Patch to the writable fields of the book and saves only the changed ones
+func (h *Handler) patchBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	applyPatch := applyMergePatch
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		switch {
+		case err != nil:
+			writeProblem(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType, "unsupported content type")
+			return
+		case mediaType == mediaTypeJSONPatch:
+			applyPatch = applyJSONPatch
+		case mediaType != mediaTypeMergePatch && mediaType != "application/json":
+			writeProblem(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType, "unsupported content type")
 			return
 		}
-		http.Error(w, "failed to update book", http.StatusInternalServerError)
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(patch) == 0 {
+		logging.FromContext(r.Context()).Info("failed to read request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
 		return
 	}
 
-	jsonOK(w, nil)
+	current, err := h.storage.GetBook(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to find book", "err", err)
+		writeStorageError(w, r, err, "failed to find book")
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagListed(ifMatch, bookETag(current), false) {
+		writeError(w, r, http.StatusPreconditionFailed, storage.ErrBookVersionMismatch)
+		return
+	}
+
+	doc, err := json.Marshal(convertBookToUpsert(current))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to marshal book", "err", err)
+		writeProblem(w, r, http.StatusInternalServerError, api.CodeInternal, "failed to patch book")
+		return
+	}
+
+	if doc, err = applyPatch(doc, patch); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to apply patch", "err", err)
+		if errors.Is(err, errPatchTestFailed) {
+			writeError(w, r, http.StatusConflict, err)
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to apply patch")
+		return
+	}
+
+	// read-only fields such as status can't be patched
+	var req api.UpsertBookRequest
+	if err = decodeStrict(doc, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse patched book", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse patched book")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate patched book", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	book, err := convertBookToDB(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to convert book request to DB", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to convert book request to DB")
+		return
+	}
+	book.ID = bookID
+	// the conditional patch applies only to the version the If-Match was checked against
+	if r.Header.Get("If-Match") != "" {
+		book.Version = current.Version
+	}
+
+	patched, err := h.storage.PatchBook(r.Context(), book, changedBookFields(current, book))
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to patch book", "err", err)
+		writeStorageError(w, r, err, "failed to patch book")
+		return
+	}
+
+	w.Header().Set("ETag", bookETag(patched))
+	jsonOK(w, convertBookFromDB(patched))
 }
 
 func (h *Handler) getBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -137,41 +297,91 @@ func (h *Handler) getBookHandler(w http.ResponseWriter, r *http.Request, p httpr
 	bookIDStr := p.ByName("id")
 	bookID, err := uuid.Parse(bookIDStr)
 	if err != nil {
-		log.Printf("failed to parse book id. err: %v\n", err)
-		http.Error(w, "failed to parse book id", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
 		return
 	}
 
 	book, err := h.storage.GetBook(r.Context(), bookID)
 	if err != nil {
-		log.Printf("failed to find book. err: %v\n", err)
-		if err == storage.ErrBookNotFound {
-			http.Error(w, "book not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "failed to find book", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Warn("failed to find book", "err", err)
+		writeStorageError(w, r, err, "failed to find book")
+		return
+	}
+
+	etag := bookETag(book)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagListed(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	jsonOK(w, convertBookFromDB(book))
 }
 
-// TODO: paging
 func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	defer h.guardPanic()
 
-	books, err := h.storage.ListBooks(r.Context())
+	query, err := parseListBooksQuery(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse list query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.storage.ListBooks(r.Context(), *query)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list book", "err", err)
+		if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrInvalidSort) {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		writeStorageError(w, r, err, "failed to list books")
+		return
+	}
+
+	jsonOK(w, &api.ListBooksResponse{
+		Items:      convertBooksFromDB(result.Books),
+		NextCursor: result.NextCursor,
+		TotalCount: result.TotalCount,
+	})
+}
+
+func (h *Handler) searchBooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	limit, err := parseIntParam(r.URL.Query(), "limit")
 	if err != nil {
-		log.Printf("failed to list book. err: %v\n", err)
-		http.Error(w, "failed to list books", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Info("failed to parse search query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	jsonOK(w, convertBooksFromDB(books))
+	hits, err := h.storage.SearchBooks(r.Context(), storage.SearchBooksQuery{
+		Query: r.URL.Query().Get("q"),
+		Limit: limit,
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to search books", "err", err)
+		if errors.Is(err, storage.ErrEmptySearchQuery) {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		writeStorageError(w, r, err, "failed to search books")
+		return
+	}
+
+	jsonOK(w, &api.SearchBooksResponse{
+		Items: convertSearchHitsFromDB(hits),
+	})
 }
 
 func (h *Handler) guardPanic() {
 	if p := recover(); p != nil {
-		log.Printf("caught panic: %v\n", p)
+		// the server closes the connection of aborted responses
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+		logging.Default().Error("caught panic", "panic", p, "stack", string(debug.Stack()))
 	}
 }
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/julienschmidt/httprouter"
+)
+
+const defaultHealthTimeout = 2 * time.Second
+
+var errShuttingDown = errors.New("the server is shutting down")
+
+type HealthParams struct {
+	Storage storage.HealthStorage
+	// Timeout limits every check, 2 seconds if not set
+	Timeout time.Duration
+}
+
+// Health checks whether the service is ready to serve requests. Readiness fails as soon as
+// the shutdown starts, so orchestrators stop routing requests to the replica before it stops
+type Health struct {
+	store        storage.HealthStorage
+	timeout      time.Duration
+	shuttingDown int32
+}
+
+func NewHealth(params HealthParams) *Health {
+	h := &Health{
+		store:   params.Storage,
+		timeout: params.Timeout,
+	}
+	if h.timeout <= 0 {
+		h.timeout = defaultHealthTimeout
+	}
+	return h
+}
+
+// Shutdown fails the readiness from now on
+func (h *Health) Shutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func (h *Health) isShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+// Check runs all checks of the readiness
+func (h *Health) Check(ctx context.Context) *api.HealthResponse {
+	resp := &api.HealthResponse{
+		Status: api.HealthOK,
+		Checks: []*api.HealthCheck{
+			h.check(ctx, "database", h.store.Ping),
+			h.check(ctx, "migrations", h.store.CheckMigrations),
+			h.check(ctx, "shutdown", func(context.Context) error {
+				if h.isShuttingDown() {
+					return errShuttingDown
+				}
+				return nil
+			}),
+		},
+	}
+
+	for _, check := range resp.Checks {
+		if check.Status != api.HealthOK {
+			resp.Status = api.HealthFailing
+		}
+	}
+	return resp
+}
+
+func (h *Health) check(ctx context.Context, name string, fn func(ctx context.Context) error) *api.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	check := &api.HealthCheck{
+		Name:      name,
+		Status:    api.HealthOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		check.Status = api.HealthFailing
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// livenessHandler reports that the process is up, it checks no dependencies
+func (h *Handler) livenessHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	jsonOK(w, nil)
+}
+
+// readinessHandler fails with 503 while the database is unreachable or not migrated
+// and once the shutdown starts
+func (h *Handler) readinessHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.health.isShuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, errShuttingDown)
+		return
+	}
+
+	health := h.health.Check(r.Context())
+	if health.Status == api.HealthOK {
+		fmt.Fprintln(w, "OK")
+		return
+	}
+
+	var failed []string
+	for _, check := range health.Checks {
+		if check.Status != api.HealthOK {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Error))
+		}
+	}
+	logging.FromContext(r.Context()).Warn("service is not ready", "err", strings.Join(failed, "; "))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, strings.Join(failed, "\n"))
+}
+
+// healthHandler returns the result of every check, with 503 when any of them fails
+func (h *Handler) healthHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	health := h.health.Check(r.Context())
+
+	status := http.StatusOK
+	if health.Status != api.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
@@ -3,14 +3,20 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
 	"github.com/alexkaplun/books-test/storage/models"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
 )
 
 func jsonOK(w http.ResponseWriter, resp interface{}) {
@@ -23,8 +29,12 @@ func jsonOK(w http.ResponseWriter, resp interface{}) {
 	} else {
 		payload, err = json.Marshal(resp)
 		if err != nil {
-			log.Printf("failed to marshal response body. err: %v\n", err)
-			http.Error(w, "failed to marshal response body", http.StatusInternalServerError)
+			logging.Default().Error("failed to marshal response body", "request_id", w.Header().Get(requestIDHeader), "err", err)
+			sendProblem(w, &api.Problem{
+				Status: http.StatusInternalServerError,
+				Code:   api.CodeInternal,
+				Detail: "failed to marshal response body",
+			})
 			return
 		}
 	}
@@ -56,7 +66,6 @@ func convertBookToDB(in *api.UpsertBookRequest) (*models.Book, error) {
 		Author:    in.Author,
 		Publisher: in.Publisher,
 		Rating:    in.Rating,
-		Status:    models.BookStatus(in.Status),
 	}
 
 	if len(in.PublishDate) != 0 {
@@ -71,6 +80,44 @@ func convertBookToDB(in *api.UpsertBookRequest) (*models.Book, error) {
 	return book, nil
 }
 
+// convertBookToUpsert returns the writable fields of the book
+func convertBookToUpsert(in *models.Book) *api.UpsertBookRequest {
+	req := &api.UpsertBookRequest{
+		Title:     in.Title,
+		Author:    in.Author,
+		Publisher: in.Publisher,
+		Rating:    in.Rating,
+	}
+
+	if in.PublishDate != nil {
+		req.PublishDate = in.PublishDate.Format("2006-01-02")
+	}
+
+	return req
+}
+
+// changedBookFields lists the fields that differ between the current and the patched book
+func changedBookFields(current, patched *models.Book) []storage.BookField {
+	var fields []storage.BookField
+	if current.Title != patched.Title {
+		fields = append(fields, storage.BookFieldTitle)
+	}
+	if current.Author != patched.Author {
+		fields = append(fields, storage.BookFieldAuthor)
+	}
+	if current.Publisher != patched.Publisher {
+		fields = append(fields, storage.BookFieldPublisher)
+	}
+	if (current.PublishDate == nil) != (patched.PublishDate == nil) ||
+		(current.PublishDate != nil && !current.PublishDate.Equal(*patched.PublishDate)) {
+		fields = append(fields, storage.BookFieldPublishDate)
+	}
+	if current.Rating != patched.Rating {
+		fields = append(fields, storage.BookFieldRating)
+	}
+	return fields
+}
+
 func convertBookFromDB(in *models.Book) *api.Book {
 	book := &api.Book{
 		ID:        in.ID,
@@ -81,11 +128,19 @@ func convertBookFromDB(in *models.Book) *api.Book {
 		Status:    string(in.Status),
 		CreatedAt: in.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: in.UpdatedAt.Format(time.RFC3339),
+		Version:   in.Version,
+		Copies: api.CopyCounts{
+			Total:     in.Copies.Total,
+			Available: in.Copies.Available,
+		},
 	}
 
 	if in.PublishDate != nil {
 		book.PublishDate = in.PublishDate.Format("2006-01-02")
 	}
+	if in.DeletedAt != nil {
+		book.DeletedAt = in.DeletedAt.Format(time.RFC3339)
+	}
 
 	return book
 }
@@ -97,3 +152,380 @@ func convertBooksFromDB(in []*models.Book) []*api.Book {
 	}
 	return books
 }
+
+// convertCheckoutToDB builds the loan of the checkout request. A loan is due at the end
+// of its due date (UTC), which defaults to the loan period from the checkout
+func convertCheckoutToDB(bookID uuid.UUID, in *api.CheckoutRequest, loanPeriod time.Duration) (*models.Loan, error) {
+	memberID, err := uuid.Parse(in.MemberID)
+	if err != nil {
+		return nil, err
+	}
+
+	loan := &models.Loan{
+		BookID:   bookID,
+		MemberID: &memberID,
+		Period:   loanPeriod,
+	}
+
+	if len(in.DueDate) != 0 {
+		dueDate, err := time.Parse("2006-01-02", in.DueDate)
+		if err != nil {
+			return nil, err
+		}
+
+		loan.DueAt = dueDate.AddDate(0, 0, 1)
+		if loan.DueAt.Before(time.Now()) {
+			return nil, validation.Errors{"dueDate": errors.New("must not be in the past")}
+		}
+	}
+
+	return loan, nil
+}
+
+func convertLoanFromDB(in *models.Loan) *api.Loan {
+	loan := &api.Loan{
+		ID:           in.ID,
+		BookID:       in.BookID,
+		MemberID:     in.MemberID,
+		Borrower:     in.Borrower,
+		CheckedOutAt: in.CheckedOutAt.Format(time.RFC3339),
+		DueAt:        in.DueAt.Format(time.RFC3339),
+	}
+
+	if in.ReturnedAt != nil {
+		loan.ReturnedAt = in.ReturnedAt.Format(time.RFC3339)
+	}
+
+	return loan
+}
+
+func convertLoansFromDB(in []*models.Loan) []*api.Loan {
+	loans := make([]*api.Loan, len(in))
+	for i, v := range in {
+		loans[i] = convertLoanFromDB(v)
+	}
+	return loans
+}
+
+func convertHoldFromDB(in *models.Hold) *api.Hold {
+	hold := &api.Hold{
+		ID:        in.ID,
+		BookID:    in.BookID,
+		MemberID:  in.MemberID,
+		Status:    string(in.Status),
+		CreatedAt: in.CreatedAt.Format(time.RFC3339),
+	}
+
+	if in.ReadyAt != nil {
+		hold.ReadyAt = in.ReadyAt.Format(time.RFC3339)
+	}
+	if in.ExpiresAt != nil {
+		hold.ExpiresAt = in.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return hold
+}
+
+// convertHoldQueueFromDB converts active holds in queue order
+func convertHoldQueueFromDB(in []*models.Hold) []*api.Hold {
+	holds := make([]*api.Hold, len(in))
+	for i, v := range in {
+		holds[i] = convertHoldFromDB(v)
+		holds[i].Position = i + 1
+	}
+	return holds
+}
+
+func convertCopyToDB(in *api.UpsertCopyRequest) *models.Copy {
+	return &models.Copy{
+		Barcode:   in.Barcode,
+		Location:  in.Location,
+		Condition: models.CopyCondition(in.Condition),
+		Status:    models.CopyStatus(in.Status),
+	}
+}
+
+func convertCopyFromDB(in *models.Copy) *api.Copy {
+	return &api.Copy{
+		ID:        in.ID,
+		BookID:    in.BookID,
+		Barcode:   in.Barcode,
+		Location:  in.Location,
+		Condition: string(in.Condition),
+		Status:    string(in.Status),
+		CreatedAt: in.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: in.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func convertCopiesFromDB(in []*models.Copy) []*api.Copy {
+	copies := make([]*api.Copy, len(in))
+	for i, v := range in {
+		copies[i] = convertCopyFromDB(v)
+	}
+	return copies
+}
+
+func convertMemberToDB(in *api.UpsertMemberRequest) *models.Member {
+	return &models.Member{
+		Name:           in.Name,
+		Email:          in.Email,
+		CardNumber:     in.CardNumber,
+		Status:         models.MemberStatus(in.Status),
+		BorrowingLimit: in.BorrowingLimit,
+	}
+}
+
+func convertMemberFromDB(in *models.Member) *api.Member {
+	return &api.Member{
+		ID:             in.ID,
+		Name:           in.Name,
+		Email:          in.Email,
+		CardNumber:     in.CardNumber,
+		Status:         string(in.Status),
+		BorrowingLimit: in.BorrowingLimit,
+		CreatedAt:      in.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      in.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func convertMembersFromDB(in []*models.Member) []*api.Member {
+	members := make([]*api.Member, len(in))
+	for i, v := range in {
+		members[i] = convertMemberFromDB(v)
+	}
+	return members
+}
+
+func convertSearchHitsFromDB(in []*storage.BookSearchHit) []*api.BookSearchHit {
+	hits := make([]*api.BookSearchHit, len(in))
+	for i, v := range in {
+		hits[i] = &api.BookSearchHit{
+			Book: convertBookFromDB(v.Book),
+			Rank: v.Rank,
+			Highlights: api.BookHighlights{
+				Title:     v.Highlights.Title,
+				Author:    v.Highlights.Author,
+				Publisher: v.Highlights.Publisher,
+			},
+		}
+	}
+	return hits
+}
+
+func parseListBooksQuery(values url.Values) (*storage.ListBooksQuery, error) {
+	query := &storage.ListBooksQuery{
+		Cursor:    values.Get("cursor"),
+		Sort:      values.Get("sort"),
+		Author:    values.Get("author"),
+		Publisher: values.Get("publisher"),
+		Status:    models.BookStatus(values.Get("status")),
+		WithTotal: values.Get("includeTotal") == "true",
+	}
+
+	var err error
+	if query.Limit, err = parseIntParam(values, "limit"); err != nil {
+		return nil, err
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("invalid limit: must be positive")
+	}
+	if query.MinRating, err = parseIntParam(values, "minRating"); err != nil {
+		return nil, err
+	}
+	if query.MaxRating, err = parseIntParam(values, "maxRating"); err != nil {
+		return nil, err
+	}
+	if query.PublishedFrom, err = parseDateParam(values, "publishedFrom"); err != nil {
+		return nil, err
+	}
+	if query.PublishedTo, err = parseDateParam(values, "publishedTo"); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+func parseAuditQuery(values url.Values) (*storage.AuditQuery, error) {
+	query := &storage.AuditQuery{
+		Cursor: values.Get("cursor"),
+	}
+
+	var err error
+	if query.Limit, err = parseIntParam(values, "limit"); err != nil {
+		return nil, err
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("invalid limit: must be positive")
+	}
+	if query.From, err = parseTimeParam(values, "from"); err != nil {
+		return nil, err
+	}
+	if query.To, err = parseTimeParam(values, "to"); err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+func parseWebhookDeliveryQuery(values url.Values) (*storage.WebhookDeliveryQuery, error) {
+	query := &storage.WebhookDeliveryQuery{
+		Status: models.WebhookDeliveryStatus(values.Get("status")),
+	}
+
+	switch query.Status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliveryDelivered, models.WebhookDeliveryDead:
+	default:
+		return nil, errors.New("invalid status: must be one of Pending, Delivered and Dead")
+	}
+
+	var err error
+	if query.Limit, err = parseIntParam(values, "limit"); err != nil {
+		return nil, err
+	}
+	if query.Limit < 0 {
+		return nil, errors.New("invalid limit: must be positive")
+	}
+
+	return query, nil
+}
+
+func parseIntParam(values url.Values, name string) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: must be an integer", name)
+	}
+	return res, nil
+}
+
+func parseDateParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be a date in YYYY-MM-DD format", name)
+	}
+	return &date, nil
+}
+
+func parseTimeParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	res, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be a time in RFC 3339 format", name)
+	}
+	return &res, nil
+}
+
+func convertFineFromDB(in *models.Fine) *api.Fine {
+	fine := &api.Fine{
+		ID:        in.ID,
+		LoanID:    in.LoanID,
+		MemberID:  in.MemberID,
+		Amount:    in.Amount,
+		Status:    string(in.Status),
+		CreatedAt: in.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: in.UpdatedAt.Format(time.RFC3339),
+	}
+
+	if in.PaidAt != nil {
+		fine.PaidAt = in.PaidAt.Format(time.RFC3339)
+	}
+
+	return fine
+}
+
+func convertAuditEntriesFromDB(in []*models.AuditEntry) []*api.AuditEntry {
+	entries := make([]*api.AuditEntry, len(in))
+	for i, v := range in {
+		entries[i] = &api.AuditEntry{
+			ID:        v.ID,
+			BookID:    v.BookID,
+			Action:    string(v.Action),
+			Actor:     v.Actor,
+			RequestID: v.RequestID,
+			Before:    v.Before,
+			After:     v.After,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return entries
+}
+
+func convertWebhookToDB(in *api.UpsertWebhookRequest) *models.Webhook {
+	webhook := &models.Webhook{
+		URL:    in.URL,
+		Secret: in.Secret,
+	}
+	for _, eventType := range in.EventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, models.EventType(eventType))
+	}
+	return webhook
+}
+
+func convertWebhookFromDB(in *models.Webhook) *api.Webhook {
+	webhook := &api.Webhook{
+		ID:         in.ID,
+		URL:        in.URL,
+		EventTypes: make([]string, len(in.EventTypes)),
+		Active:     in.Active,
+		CreatedAt:  in.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  in.UpdatedAt.Format(time.RFC3339),
+	}
+	for i, eventType := range in.EventTypes {
+		webhook.EventTypes[i] = string(eventType)
+	}
+	return webhook
+}
+
+func convertWebhooksFromDB(in []*models.Webhook) []*api.Webhook {
+	webhooks := make([]*api.Webhook, len(in))
+	for i, v := range in {
+		webhooks[i] = convertWebhookFromDB(v)
+	}
+	return webhooks
+}
+
+func convertWebhookDeliveryFromDB(in *models.WebhookDelivery) *api.WebhookDelivery {
+	delivery := &api.WebhookDelivery{
+		ID:             in.ID,
+		WebhookID:      in.WebhookID,
+		EventID:        in.EventID,
+		EventType:      string(in.EventType),
+		Status:         string(in.Status),
+		Attempts:       in.Attempts,
+		LastStatusCode: in.LastStatusCode,
+		LastError:      in.LastError,
+		Payload:        in.Payload,
+		CreatedAt:      in.CreatedAt.Format(time.RFC3339),
+	}
+
+	if in.Status == models.WebhookDeliveryPending && in.NextAttemptAt != nil {
+		delivery.NextAttemptAt = in.NextAttemptAt.Format(time.RFC3339)
+	}
+	if in.DeliveredAt != nil {
+		delivery.DeliveredAt = in.DeliveredAt.Format(time.RFC3339)
+	}
+
+	return delivery
+}
+
+func convertWebhookDeliveriesFromDB(in []*models.WebhookDelivery) []*api.WebhookDelivery {
+	deliveries := make([]*api.WebhookDelivery, len(in))
+	for i, v := range in {
+		deliveries[i] = convertWebhookDeliveryFromDB(v)
+	}
+	return deliveries
+}
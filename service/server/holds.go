@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) placeHoldHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	var req api.PlaceHoldRequest
+	if err = parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	hold, err := h.storage.PlaceHold(r.Context(), &models.Hold{
+		BookID:       bookID,
+		MemberID:     uuid.MustParse(req.MemberID),
+		PickupWindow: h.holdPickupWindow,
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to place hold", "err", err)
+		writeStorageError(w, r, err, "failed to place hold")
+		return
+	}
+
+	jsonOK(w, convertHoldFromDB(hold))
+}
+
+func (h *Handler) listHoldsHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	holds, err := h.storage.ListHolds(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list holds", "err", err)
+		writeStorageError(w, r, err, "failed to list holds")
+		return
+	}
+
+	jsonOK(w, &api.ListHoldsResponse{
+		Items: convertHoldQueueFromDB(holds),
+	})
+}
+
+func (h *Handler) cancelHoldHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	holdIDStr := p.ByName("holdId")
+	holdID, err := uuid.Parse(holdIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse hold id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse hold id")
+		return
+	}
+
+	hold, err := h.storage.CancelHold(r.Context(), bookID, holdID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to cancel hold", "err", err)
+		writeStorageError(w, r, err, "failed to cancel hold")
+		return
+	}
+
+	jsonOK(w, convertHoldFromDB(hold))
+}
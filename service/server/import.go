@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Formats of bulk imports and exports, XLSX is only exported
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+const (
+	defaultImportBatchSize = 500
+	// maxImportRecordSize limits the size of an NDJSON line
+	maxImportRecordSize = 1 << 20
+)
+
+// ErrInvalidImport is returned when the input of an import can't be read at all
+var ErrInvalidImport = errors.New("invalid import")
+
+// importFormats are the import formats of the content types
+var importFormats = map[string]string{
+	"text/csv":             FormatCSV,
+	"application/x-ndjson": FormatNDJSON,
+	"application/ndjson":   FormatNDJSON,
+}
+
+// importColumns set the fields of the CSV columns, named like the JSON fields
+var importColumns = map[string]func(req *api.UpsertBookRequest, value string) error{
+	"title": func(req *api.UpsertBookRequest, value string) error {
+		req.Title = value
+		return nil
+	},
+	"author": func(req *api.UpsertBookRequest, value string) error {
+		req.Author = value
+		return nil
+	},
+	"publisher": func(req *api.UpsertBookRequest, value string) error {
+		req.Publisher = value
+		return nil
+	},
+	"publishdate": func(req *api.UpsertBookRequest, value string) error {
+		req.PublishDate = value
+		return nil
+	},
+	"rating": func(req *api.UpsertBookRequest, value string) error {
+		if value = strings.TrimSpace(value); value == "" {
+			return nil
+		}
+		rating, err := strconv.Atoi(value)
+		if err != nil {
+			return validation.Errors{"rating": errors.New("must be an integer")}
+		}
+		req.Rating = rating
+		return nil
+	},
+}
+
+// exportedColumns are the lowercased names of the exported columns
+var exportedColumns = func() map[string]bool {
+	columns := make(map[string]bool, len(exportColumns))
+	for _, column := range exportColumns {
+		columns[strings.ToLower(column.name)] = true
+	}
+	return columns
+}()
+
+func skipColumn(*api.UpsertBookRequest, string) error {
+	return nil
+}
+
+type ImportParams struct {
+	// Format is FormatCSV or FormatNDJSON
+	Format string
+	// DryRun validates the records without creating the books
+	DryRun bool
+	// BatchSize is the number of books created at once, defaults to 500
+	BatchSize int
+}
+
+// ImportBooks reads the books from the input one record at a time, validates them like POST /books
+// and creates the valid ones in batches. Invalid records are reported and skipped, so are the records
+// of a batch the storage failed to create. Reading stops at the first record that can't be read past.
+// It fails when the input can't be read at all, with ErrInvalidImport, or the context is done
+func ImportBooks(ctx context.Context, store storage.Storage, input io.Reader, params ImportParams) (*api.ImportReport, error) {
+	reader, err := newImportReader(input, params.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := params.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	report := &api.ImportReport{
+		DryRun: params.DryRun,
+		Rows:   []*api.ImportRow{},
+	}
+
+	var (
+		batch     []*models.Book
+		batchRows []*api.ImportRow
+	)
+	flush := func() error {
+		defer func() {
+			batch, batchRows = batch[:0], batchRows[:0]
+		}()
+		if len(batch) == 0 {
+			return nil
+		}
+		if params.DryRun {
+			report.Imported += len(batch)
+			return nil
+		}
+
+		if err := store.ImportBooks(ctx, batch); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			logging.FromContext(ctx).Warn("failed to import books", "err", err)
+			for _, row := range batchRows {
+				row.Error = storageErrorDetail(err, "failed to import books")
+			}
+			report.Failed += len(batch)
+			return nil
+		}
+
+		for i, row := range batchRows {
+			id := batch[i].ID
+			row.ID = &id
+		}
+		report.Imported += len(batch)
+		return nil
+	}
+
+	for n := 1; ; n++ {
+		req, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		row := &api.ImportRow{Row: n}
+		report.Rows = append(report.Rows, row)
+		report.Total++
+
+		// errors of the reader other than a malformed record end the input
+		var recordErr *importRecordError
+		readFailed := err != nil && !errors.As(err, &recordErr) && fieldErrors(err) == nil
+
+		var book *models.Book
+		if err == nil {
+			book, err = convertImportRecord(req)
+		}
+		if err != nil {
+			row.Error = err.Error()
+			if row.Errors = fieldErrors(err); row.Errors != nil {
+				row.Error = "the record has invalid fields"
+			}
+			report.Failed++
+
+			if readFailed {
+				break
+			}
+			continue
+		}
+
+		batch = append(batch, book)
+		batchRows = append(batchRows, row)
+		if len(batch) == batchSize {
+			if err = flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = flush(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func convertImportRecord(req *api.UpsertBookRequest) (*models.Book, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	return convertBookToDB(req)
+}
+
+// importReader reads the book requests of the input one record at a time
+type importReader interface {
+	// next returns the next record or io.EOF after the last one. The reader goes on after
+	// an importRecordError, other errors end the input
+	next() (*api.UpsertBookRequest, error)
+}
+
+// importRecordError is the error of a single malformed record
+type importRecordError struct {
+	msg string
+}
+
+func (e *importRecordError) Error() string {
+	return e.msg
+}
+
+func newImportReader(input io.Reader, format string) (importReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVImportReader(input)
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(nil, maxImportRecordSize)
+		return &ndjsonImportReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidImport, format)
+	}
+}
+
+// csvImportReader reads CSV with a header naming the columns, in any order
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns []func(req *api.UpsertBookRequest, value string) error
+}
+
+func newCSVImportReader(input io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(input)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: missing CSV header", ErrInvalidImport)
+		}
+		return nil, fmt.Errorf("%w: failed to read CSV header: %v", ErrInvalidImport, err)
+	}
+
+	r := &csvImportReader{reader: reader}
+	seen := make(map[string]bool)
+	for i, name := range header {
+		if i == 0 {
+			// spreadsheets may start the file with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		column, ok := importColumns[name]
+		if !ok && exportedColumns[name] {
+			// exports are imported back without their read-only columns
+			column, ok = skipColumn, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", ErrInvalidImport, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: duplicate CSV column %q", ErrInvalidImport, name)
+		}
+		seen[name] = true
+		r.columns = append(r.columns, column)
+	}
+
+	return r, nil
+}
+
+func (r *csvImportReader) next() (*api.UpsertBookRequest, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &importRecordError{msg: "invalid CSV record: " + parseErr.Err.Error()}
+		}
+		return nil, err
+	}
+
+	var req api.UpsertBookRequest
+	for i, value := range record {
+		if err = r.columns[i](&req, value); err != nil {
+			return nil, err
+		}
+	}
+	return &req, nil
+}
+
+// ndjsonImportReader reads a JSON book request per line, blank lines are skipped
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *ndjsonImportReader) next() (*api.UpsertBookRequest, error) {
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var req api.UpsertBookRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			return nil, &importRecordError{msg: "invalid JSON record: " + err.Error()}
+		}
+		return &req, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the input: %w", err)
+	}
+	return nil, io.EOF
+}
+
+// importBooksHandler imports the books of the CSV or NDJSON body, see ImportBooks.
+// The format is taken from the format param or the content type
+func (h *Handler) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	defer h.guardPanic()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[mediaType]
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedMediaType,
+			"unsupported import format, send text/csv or application/x-ndjson")
+		return
+	}
+
+	report, err := ImportBooks(r.Context(), h.storage, r.Body, ImportParams{
+		Format: format,
+		DryRun: r.URL.Query().Get("dryRun") == "true",
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidImport) {
+			logging.FromContext(r.Context()).Info("failed to read import", "err", err)
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		logging.FromContext(r.Context()).Warn("failed to import books", "err", err)
+		writeStorageError(w, r, err, "failed to import books")
+		return
+	}
+
+	jsonOK(w, report)
+}
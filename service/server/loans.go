@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) checkoutBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	var req api.CheckoutRequest
+	if err = parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	loan, err := convertCheckoutToDB(bookID, &req, h.loanPeriod)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to convert checkout request to DB", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	if loan, err = h.storage.CheckoutBook(r.Context(), loan); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to checkout book", "err", err)
+		writeStorageError(w, r, err, "failed to checkout book")
+		return
+	}
+
+	jsonOK(w, convertLoanFromDB(loan))
+}
+
+func (h *Handler) returnBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	loan, err := h.storage.ReturnBook(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to return book", "err", err)
+		writeStorageError(w, r, err, "failed to return book")
+		return
+	}
+
+	jsonOK(w, convertLoanFromDB(loan))
+}
+
+func (h *Handler) listLoansHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	loans, err := h.storage.ListLoans(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list loans", "err", err)
+		writeStorageError(w, r, err, "failed to list loans")
+		return
+	}
+
+	jsonOK(w, &api.ListLoansResponse{
+		Items: convertLoansFromDB(loans),
+	})
+}
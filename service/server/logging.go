@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/logging"
+)
+
+// withLogging passes the logger of the request, tagged with its id, in the request context
+// and logs every request when it's done. Failed requests are logged as errors
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := logging.FromContext(r.Context()).With("request_id", RequestID(r.Context()))
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		// logged on panics too, aborted responses are logged with the status sent
+		defer func() {
+			info := requestInfoOf(r)
+			status := recorder.statusCode()
+			keyvals := []interface{}{
+				"method", r.Method,
+				"route", info.route,
+				"path", r.URL.Path,
+				"status", status,
+				"duration_ms", logging.DurationMillis(time.Since(start)),
+			}
+			if info.bookID != "" {
+				keyvals = append(keyvals, "book_id", info.bookID)
+			}
+
+			level := logging.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = logging.LevelError
+			}
+			logger.Log(level, "request", keyvals...)
+		}()
+
+		next.ServeHTTP(recorder, r.WithContext(logging.NewContext(r.Context(), logger)))
+	})
+}
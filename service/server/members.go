@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) createMemberHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	var req api.UpsertMemberRequest
+	if err := parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	id, err := h.storage.CreateMember(r.Context(), convertMemberToDB(&req))
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to save member to DB", "err", err)
+		writeStorageError(w, r, err, "failed to save member to DB")
+		return
+	}
+
+	jsonOK(w, &api.CreateMemberResponse{
+		ID: id,
+	})
+}
+
+func (h *Handler) updateMemberHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	memberIDStr := p.ByName("id")
+	memberID, err := uuid.Parse(memberIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse member id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse member id")
+		return
+	}
+
+	var req api.UpsertMemberRequest
+	if err = parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	member := convertMemberToDB(&req)
+	member.ID = memberID
+
+	if err = h.storage.UpdateMember(r.Context(), member); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to update member", "err", err)
+		writeStorageError(w, r, err, "failed to update member")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) deleteMemberHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	memberIDStr := p.ByName("id")
+	memberID, err := uuid.Parse(memberIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse member id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse member id")
+		return
+	}
+
+	if err = h.storage.DeleteMember(r.Context(), memberID); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to delete member", "err", err)
+		writeStorageError(w, r, err, "failed to delete member")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) getMemberHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	memberIDStr := p.ByName("id")
+	memberID, err := uuid.Parse(memberIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse member id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse member id")
+		return
+	}
+
+	member, err := h.storage.GetMember(r.Context(), memberID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to find member", "err", err)
+		writeStorageError(w, r, err, "failed to find member")
+		return
+	}
+
+	jsonOK(w, convertMemberFromDB(member))
+}
+
+func (h *Handler) listMembersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	members, err := h.storage.ListMembers(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list members", "err", err)
+		writeStorageError(w, r, err, "failed to list members")
+		return
+	}
+
+	jsonOK(w, &api.ListMembersResponse{
+		Items: convertMembersFromDB(members),
+	})
+}
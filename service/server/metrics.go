@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// unmatchedRoute labels the requests not matching any route, so unknown paths don't add series
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels the requests of methods not in knownMethods, so made up methods don't add series
+const otherMethod = "other"
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// withMetrics counts the requests and observes their latency by route, method and status.
+// The route is recorded by the router, see withRequestInfo
+func withMetrics(next http.Handler, registry *metrics.Registry) http.Handler {
+	requests := registry.NewCounter("http_requests_total", "Number of HTTP requests by route, method and status.",
+		"route", "method", "status")
+	latency := registry.NewHistogram("http_request_duration_seconds", "Latency of HTTP requests by route, method and status.",
+		metrics.DefaultBuckets, "route", "method", "status")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		// recorded on panics too, aborted responses are counted with the status sent
+		defer func() {
+			route, method := requestInfoOf(r).route, methodLabel(r.Method)
+			status := strconv.Itoa(recorder.statusCode())
+			requests.Inc(route, method, status)
+			latency.Observe(time.Since(start).Seconds(), route, method, status)
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// methodLabel returns the label of the request method
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+type requestInfoKey struct{}
+
+// requestInfo is what routing tells about the request, recorded for the metrics and the logs
+type requestInfo struct {
+	route string
+	// bookID is the id param of the book routes, as sent
+	bookID string
+}
+
+// withRequestInfo lets the router record the route of the request for the middlewares it wraps
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{route: unmatchedRoute}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+// requestInfoOf returns the info recorded for the request, a discarded one outside withRequestInfo
+func requestInfoOf(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{route: unmatchedRoute}
+}
+
+// recordRoute records the route the request matched and the book id of the book routes
+func recordRoute(r *http.Request, route string, p httprouter.Params) {
+	info := requestInfoOf(r)
+	info.route = route
+	info.bookID = ""
+	if strings.HasPrefix(route, "/books/:id") {
+		info.bookID = p.ByName("id")
+	}
+}
+
+// withRoute records the route of the handle for the metrics and the logs
+func withRoute(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		recordRoute(r, route, p)
+		handle(w, r, p)
+	}
+}
+
+// routes registers the handles of httprouter with their routes recorded for the metrics and the logs
+type routes struct {
+	*httprouter.Router
+}
+
+func (r routes) Handle(method, path string, handle httprouter.Handle) {
+	r.Router.Handle(method, path, withRoute(path, handle))
+}
+
+func (r routes) GET(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodGet, path, handle)
+}
+
+func (r routes) POST(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPost, path, handle)
+}
+
+func (r routes) PUT(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPut, path, handle)
+}
+
+func (r routes) PATCH(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPatch, path, handle)
+}
+
+func (r routes) DELETE(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, handle)
+}
+
+// statusRecorder records the status of the response. It flushes like the wrapped writer,
+// so streams are not buffered
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection of the wrapped writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the status sent, 200 when the handler wrote nothing
+func (w *statusRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
-	"log"
 	"net/http"
 	"runtime/debug"
 
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/metrics"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -14,26 +16,139 @@ type Router struct {
 
 type RouterParams struct {
 	Handler *Handler
+	// Metrics registers the metrics of the requests and is served on /metrics,
+	// a new registry if not set
+	Metrics *metrics.Registry
 }
 
 func NewRouter(params RouterParams) *Router {
-	router := httprouter.New()
+	router := routes{Router: httprouter.New()}
 	router.PanicHandler = panicHandler
+	router.NotFound = http.HandlerFunc(notFound)
+	router.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
 	h := params.Handler
 
 	router.POST("/books", h.createBookHandler)
 	router.DELETE("/books/:id", h.deleteBookHandler)
 	router.PUT("/books/:id", h.updateBookHandler)
-	router.GET("/books/:id", h.getBookHandler)
+	router.PATCH("/books/:id", h.patchBookHandler)
+	router.GET("/books/:id", withStaticSegments("id", h.getBookHandler, map[string]httprouter.Handle{
+		"search": withRoute("/books/search", h.searchBooks),
+		"trash":  withRoute("/books/trash", h.listTrashHandler),
+		"events": withRoute("/books/events", h.bookEventsHandler),
+	}))
 	router.GET("/books", h.listBooks)
+	router.POST("/books/:id/restore", h.restoreBookHandler)
+	router.GET("/books/:id/history", h.listBookHistoryHandler)
+
+	router.POST("/books/:id/checkout", h.checkoutBookHandler)
+	router.POST("/books/:id/return", h.returnBookHandler)
+	router.GET("/books/:id/loans", h.listLoansHandler)
+
+	router.POST("/books/:id/holds", h.placeHoldHandler)
+	router.GET("/books/:id/holds", h.listHoldsHandler)
+	router.DELETE("/books/:id/holds/:holdId", h.cancelHoldHandler)
+
+	router.POST("/books/:id/copies", h.createCopyHandler)
+	router.GET("/books/:id/copies", h.listCopiesHandler)
+	router.PUT("/books/:id/copies/:copyId", h.updateCopyHandler)
+	router.DELETE("/books/:id/copies/:copyId", h.deleteCopyHandler)
+	router.GET("/books/:id/copies/:copyId", h.getCopyHandler)
+
+	router.POST("/members", h.createMemberHandler)
+	router.DELETE("/members/:id", h.deleteMemberHandler)
+	router.PUT("/members/:id", h.updateMemberHandler)
+	router.GET("/members/:id", h.getMemberHandler)
+	router.GET("/members", h.listMembersHandler)
+	router.GET("/members/:id/fines", h.listMemberFinesHandler)
+
+	router.POST("/fines/:id/pay", h.payFineHandler)
+
+	router.GET("/audit", h.listAuditHandler)
+
+	router.POST("/webhooks", h.createWebhookHandler)
+	router.GET("/webhooks", h.listWebhooksHandler)
+	router.PUT("/webhooks/:id", h.updateWebhookHandler)
+	router.DELETE("/webhooks/:id", h.deleteWebhookHandler)
+	router.GET("/webhooks/:id", h.getWebhookHandler)
+	router.POST("/webhooks/:id/test", h.testWebhookHandler)
+	router.GET("/webhooks/:id/deliveries", h.listWebhookDeliveriesHandler)
+
+	registry := params.Metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+	router.GET("/healthz", h.livenessHandler)
+	router.GET("/readyz", h.readinessHandler)
+	router.GET("/health", h.healthHandler)
+
+	router.GET("/metrics", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		registry.ServeHTTP(w, r)
+	})
+
+	actions := map[string]actionRoute{
+		"/books:import": {method: http.MethodPost, handle: h.importBooksHandler},
+		"/books:export": {method: http.MethodGet, handle: h.exportBooksHandler},
+	}
 
 	return &Router{
-		Handler: router,
+		Handler: withRequestInfo(
+			withMetrics(withRequestID(withLogging(withAuditInfo(withActions(router, actions)))), registry),
+		),
+	}
+}
+
+// actionRoute is the handler of a custom method path, e.g. /books:import
+type actionRoute struct {
+	method string
+	handle http.HandlerFunc
+}
+
+// withActions dispatches requests to the custom method paths before the router.
+// httprouter takes the colon of /books:import for a wildcard
+func withActions(next http.Handler, actions map[string]actionRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action, ok := actions[r.URL.Path]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recordRoute(r, r.URL.Path, nil)
+		if r.Method != action.method {
+			w.Header().Set("Allow", action.method)
+			methodNotAllowed(w, r)
+			return
+		}
+		action.handle(w, r)
+	})
+}
+
+// withStaticSegments dispatches requests whose wildcard param equals one of the static
+// segments to their own handles. httprouter doesn't allow registering a static path
+// segment next to a wildcard, e.g. /books/search next to /books/:id
+func withStaticSegments(param string, handle httprouter.Handle, static map[string]httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if h, ok := static[p.ByName(param)]; ok {
+			h(w, r, p)
+			return
+		}
+		handle(w, r, p)
 	}
 }
 
 func panicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
-	log.Println(r.URL.Path, err)
-	debug.PrintStack()
-	w.WriteHeader(http.StatusInternalServerError)
+	// the server closes the connection of aborted responses
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	logging.FromContext(r.Context()).Error("caught panic", "panic", err, "stack", string(debug.Stack()))
+	writeProblem(w, r, http.StatusInternalServerError, api.CodeInternal, "internal error")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, api.CodeNotFound, "no such resource")
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "method is not allowed for the resource")
 }
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// extendWriteDeadline lets the response be written past the write timeout of the server, which
+// otherwise limits the whole response from the moment the request was read. The deadline is moved
+// to the timeout from now, or cleared when the timeout is 0. Writers that can't set a deadline,
+// such as test recorders, are left as they are
+func extendWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	http.NewResponseController(w).SetWriteDeadline(deadline)
+}
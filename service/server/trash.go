@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) listTrashHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	books, err := h.storage.ListTrash(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list trash", "err", err)
+		writeStorageError(w, r, err, "failed to list trash")
+		return
+	}
+
+	jsonOK(w, &api.ListTrashResponse{
+		Items: convertBooksFromDB(books),
+	})
+}
+
+func (h *Handler) restoreBookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	bookIDStr := p.ByName("id")
+	bookID, err := uuid.Parse(bookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse book id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse book id")
+		return
+	}
+
+	book, err := h.storage.RestoreBook(r.Context(), bookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to restore book", "err", err)
+		writeStorageError(w, r, err, "failed to restore book")
+		return
+	}
+
+	w.Header().Set("ETag", bookETag(book))
+	jsonOK(w, convertBookFromDB(book))
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/webhook"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *Handler) createWebhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	var req api.UpsertWebhookRequest
+	if err := parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	hook := convertWebhookToDB(&req)
+	hook.Active = req.Active == nil || *req.Active
+	if hook.Secret == "" {
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			logging.FromContext(r.Context()).Info("failed to generate webhook secret", "err", err)
+			writeProblem(w, r, http.StatusInternalServerError, api.CodeInternal, "failed to generate webhook secret")
+			return
+		}
+		hook.Secret = secret
+	}
+
+	id, err := h.storage.CreateWebhook(r.Context(), hook)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to save webhook to DB", "err", err)
+		writeStorageError(w, r, err, "failed to save webhook to DB")
+		return
+	}
+
+	jsonOK(w, &api.CreateWebhookResponse{
+		ID:     id,
+		Secret: hook.Secret,
+	})
+}
+
+func (h *Handler) updateWebhookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	webhookIDStr := p.ByName("id")
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse webhook id")
+		return
+	}
+
+	var req api.UpsertWebhookRequest
+	if err = parseBody(r.Body, &req); err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse request body", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidBody, "failed to parse request body")
+		return
+	}
+
+	if err = req.Validate(); err != nil {
+		logging.FromContext(r.Context()).Info("failed to validate request", "err", err)
+		writeValidationProblem(w, r, err)
+		return
+	}
+
+	hook := convertWebhookToDB(&req)
+	hook.ID = webhookID
+
+	if err = h.storage.UpdateWebhook(r.Context(), hook, req.Active); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to update webhook", "err", err)
+		writeStorageError(w, r, err, "failed to update webhook")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) deleteWebhookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	webhookIDStr := p.ByName("id")
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse webhook id")
+		return
+	}
+
+	if err = h.storage.DeleteWebhook(r.Context(), webhookID); err != nil {
+		logging.FromContext(r.Context()).Warn("failed to delete webhook", "err", err)
+		writeStorageError(w, r, err, "failed to delete webhook")
+		return
+	}
+
+	jsonOK(w, nil)
+}
+
+func (h *Handler) getWebhookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	webhookIDStr := p.ByName("id")
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse webhook id")
+		return
+	}
+
+	hook, err := h.storage.GetWebhook(r.Context(), webhookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to find webhook", "err", err)
+		writeStorageError(w, r, err, "failed to find webhook")
+		return
+	}
+
+	jsonOK(w, convertWebhookFromDB(hook))
+}
+
+func (h *Handler) listWebhooksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer h.guardPanic()
+
+	webhooks, err := h.storage.ListWebhooks(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list webhooks", "err", err)
+		writeStorageError(w, r, err, "failed to list webhooks")
+		return
+	}
+
+	jsonOK(w, &api.ListWebhooksResponse{
+		Items: convertWebhooksFromDB(webhooks),
+	})
+}
+
+// testWebhookHandler sends a test event to the webhook and responds with the delivery,
+// also when the webhook failed to take it
+func (h *Handler) testWebhookHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	webhookIDStr := p.ByName("id")
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse webhook id")
+		return
+	}
+
+	delivery, err := h.webhooks.Test(r.Context(), webhookID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to test webhook", "err", err)
+		writeStorageError(w, r, err, "failed to test webhook")
+		return
+	}
+
+	jsonOK(w, convertWebhookDeliveryFromDB(delivery))
+}
+
+func (h *Handler) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	defer h.guardPanic()
+
+	webhookIDStr := p.ByName("id")
+	webhookID, err := uuid.Parse(webhookIDStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook id", "err", err)
+		writeProblem(w, r, http.StatusBadRequest, api.CodeInvalidID, "failed to parse webhook id")
+		return
+	}
+
+	query, err := parseWebhookDeliveryQuery(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("failed to parse webhook delivery query", "err", err)
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	deliveries, err := h.storage.ListWebhookDeliveries(r.Context(), webhookID, *query)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("failed to list webhook deliveries", "err", err)
+		writeStorageError(w, r, err, "failed to list webhook deliveries")
+		return
+	}
+
+	jsonOK(w, &api.ListWebhookDeliveriesResponse{
+		Items: convertWebhookDeliveriesFromDB(deliveries),
+	})
+}
@@ -1,41 +1,88 @@
 package service_test
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/feed"
+	"github.com/alexkaplun/books-test/service/logging"
+	"github.com/alexkaplun/books-test/service/metrics"
+	"github.com/alexkaplun/books-test/service/server"
+	"github.com/alexkaplun/books-test/service/webhook"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-const (
-	baseURL = "http://localhost:8080/books"
-)
-
 var (
-	client = http.DefaultClient
-	book   = &api.Book{
+	baseURL string
+	client  = http.DefaultClient
+	book    = &api.Book{
 		Title:       "1",
 		Author:      "2",
 		Publisher:   "3",
 		PublishDate: "2021-07-07",
 		Rating:      2,
-		Status:      "CheckedOut",
+		Status:      "CheckedIn",
 	}
 )
 
-// Since we are running tests against dockerized app, we are not able to use httptest package and
-// test handlers separately
-//
-// We may also want to set up test data prior to running test and clean up after
+// Tests run against the full router served by httptest, backed by the in-memory storage,
+// so no database is required. Set BOOKS_TEST_URL to run them against a deployed app instead,
+// e.g. BOOKS_TEST_URL=http://localhost:8080
+func TestMain(m *testing.M) {
+	if url := os.Getenv("BOOKS_TEST_URL"); url != "" {
+		baseURL = url + "/books"
+		os.Exit(m.Run())
+	}
+
+	registry := metrics.NewRegistry()
+	store := storage.Instrument(storage.NewMemory(), metrics.StorageObserver(registry), logging.StorageObserver())
+	ctx, cancel := context.WithCancel(context.Background())
+	events := feed.NewHub(feed.Params{Storage: store})
+	if err := events.Start(ctx); err != nil {
+		panic(err)
+	}
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: events})
+	srv := httptest.NewServer(server.NewRouter(server.RouterParams{Handler: handler, Metrics: registry}))
+	baseURL = srv.URL + "/books"
+
+	code := m.Run()
+	srv.Close()
+	cancel()
+	os.Exit(code)
+}
+
+// startEvents returns the started feed of the book events of the store, it stops with the test
+func startEvents(t *testing.T, store storage.EventFeed) *feed.Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	events := feed.NewHub(feed.Params{Storage: store})
+	require.NoError(t, events.Start(ctx))
+	return events
+}
 
 func TestCreateBook(t *testing.T) {
 	cases := map[string]struct {
@@ -79,23 +126,12 @@ func TestCreateBook(t *testing.T) {
 					}`,
 			expectedCode: http.StatusBadRequest,
 		},
-		"invalid status": {
-			payload: `{
-						"title": "some title",
-						"author": "some author",
-						"publisher": "some publisher",
-						"rating": 1,
-						"status": "NotCheckedIn"
-					}`,
-			expectedCode: http.StatusBadRequest,
-		},
 		"valid payload": {
 			payload: `{
 						"title": "some title",
 						"author": "some author",
 						"publisher": "some publisher",
-						"rating": 1,
-						"status": "CheckedIn"
+						"rating": 1
 					}`,
 			expectedCode: http.StatusOK,
 		},
@@ -201,10 +237,87 @@ func TestListBooks(t *testing.T) {
 	require.NoError(t, err)
 
 	// unmarshal response
-	var getResp []*api.Book
+	var getResp api.ListBooksResponse
 	require.NoError(t, json.Unmarshal(body, &getResp))
 
-	assert.NotEmpty(t, getResp)
+	assert.NotEmpty(t, getResp.Items)
+}
+
+func TestListBooksPaging(t *testing.T) {
+	// use a unique author to filter out books created by other tests
+	author := uuid.New().String()
+	for i := 0; i < 5; i++ {
+		_, err := createBook(&api.Book{Title: fmt.Sprint(i), Author: author, Rating: 1, Status: "CheckedIn"})
+		require.NoError(t, err)
+	}
+
+	var (
+		titles []string
+		cursor string
+	)
+	for {
+		url := fmt.Sprintf("%s?author=%s&sort=title&limit=2&includeTotal=true&cursor=%s", baseURL, author, cursor)
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var page api.ListBooksResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		resp.Body.Close()
+
+		require.NotNil(t, page.TotalCount)
+		assert.Equal(t, 5, *page.TotalCount)
+		assert.LessOrEqual(t, len(page.Items), 2)
+		for _, item := range page.Items {
+			titles = append(titles, item.Title)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, titles)
+
+	for name, query := range map[string]string{
+		"bad limit":  "limit=abc",
+		"bad sort":   "sort=unknown",
+		"bad cursor": "cursor=abc",
+		"bad date":   "publishedFrom=yesterday",
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Get(baseURL + "?" + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	// use a unique word to filter out books created by other tests
+	word := strings.ReplaceAll(uuid.New().String(), "-", "")
+	_, err := createBook(&api.Book{Title: "Searching " + word, Author: "someone", Rating: 1, Status: "CheckedIn"})
+	require.NoError(t, err)
+	_, err = createBook(&api.Book{Title: "Other", Author: word[:10] + " Smith", Rating: 1, Status: "CheckedIn"})
+	require.NoError(t, err)
+
+	resp, err := client.Get(baseURL + "/search?q=" + word[:8])
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var searchResp api.SearchBooksResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&searchResp))
+	require.Len(t, searchResp.Items, 2)
+	assert.Equal(t, "Searching "+word, searchResp.Items[0].Book.Title)
+	assert.Equal(t, "Searching <b>"+word+"</b>", searchResp.Items[0].Highlights.Title)
+	assert.Equal(t, "<b>"+word[:10]+"</b> Smith", searchResp.Items[1].Highlights.Author)
+
+	resp, err = client.Get(baseURL + "/search?q=")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
 func TestDeleteBook(t *testing.T) {
@@ -220,10 +333,9 @@ func TestDeleteBook(t *testing.T) {
 			bookID:       "i am bad id",
 			expectedCode: http.StatusBadRequest,
 		},
-		// we expect 200 on missing id as DELETE method is implemented as an idempotent operation
 		"missing id": {
 			bookID:       uuid.New().String(),
-			expectedCode: http.StatusOK,
+			expectedCode: http.StatusNotFound,
 		},
 		// in this test we don't check that the book was actually deleted
 		"valid": {
@@ -260,7 +372,6 @@ func TestUpdateBook(t *testing.T) {
 		Publisher:   "some publisher",
 		PublishDate: "2017-01-12",
 		Rating:      3,
-		Status:      "CheckedIn",
 	}
 
 	// marshall updatedBook payload
@@ -321,7 +432,8 @@ func TestUpdateBook(t *testing.T) {
 				assert.Equal(t, updatedBook.Publisher, getBook.Publisher)
 				assert.Equal(t, updatedBook.PublishDate, getBook.PublishDate)
 				assert.Equal(t, updatedBook.Rating, getBook.Rating)
-				assert.Equal(t, updatedBook.Status, getBook.Status)
+				// status is changed only by checking the book out and in
+				assert.Equal(t, "CheckedIn", getBook.Status)
 				assert.NotEmpty(t, getBook.CreatedAt)
 				assert.NotEmpty(t, getBook.UpdatedAt)
 				assert.NotEqual(t, getBook.CreatedAt, getBook.UpdatedAt)
@@ -330,44 +442,1489 @@ func TestUpdateBook(t *testing.T) {
 	}
 }
 
-func createBook(book *api.Book) (*uuid.UUID, error) {
-	upsertBookRequest := &api.UpsertBookRequest{
-		Title:       book.Title,
-		Author:      book.Author,
-		Publisher:   book.Publisher,
-		PublishDate: book.PublishDate,
-		Rating:      book.Rating,
-		Status:      book.Status,
+func TestPatchBook(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name         string
+		bookID       string
+		contentType  string
+		payload      string
+		expectedCode int
+		// check is called with the patched book on success
+		check func(t *testing.T, got *api.Book)
+	}{
+		{
+			name:         "bad id",
+			bookID:       "i am bad id",
+			contentType:  "application/merge-patch+json",
+			payload:      `{"rating": 3}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing id",
+			bookID:       uuid.New().String(),
+			contentType:  "application/merge-patch+json",
+			payload:      `{"rating": 3}`,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "merge patch",
+			bookID:       id.String(),
+			contentType:  "application/merge-patch+json",
+			payload:      `{"rating": 3, "publisher": null}`,
+			expectedCode: http.StatusOK,
+			check: func(t *testing.T, got *api.Book) {
+				assert.Equal(t, 3, got.Rating)
+				assert.Empty(t, got.Publisher)
+				assert.Equal(t, book.Title, got.Title)
+				assert.Equal(t, book.Author, got.Author)
+				assert.Equal(t, book.PublishDate, got.PublishDate)
+			},
+		},
+		{
+			name:         "json patch",
+			bookID:       id.String(),
+			contentType:  "application/json-patch+json",
+			payload:      `[{"op": "test", "path": "/rating", "value": 3}, {"op": "replace", "path": "/title", "value": "patched"}, {"op": "remove", "path": "/publishDate"}]`,
+			expectedCode: http.StatusOK,
+			check: func(t *testing.T, got *api.Book) {
+				assert.Equal(t, "patched", got.Title)
+				assert.Empty(t, got.PublishDate)
+				assert.Equal(t, 3, got.Rating)
+			},
+		},
+		{
+			name:         "failed test",
+			bookID:       id.String(),
+			contentType:  "application/json-patch+json",
+			payload:      `[{"op": "test", "path": "/rating", "value": 1}, {"op": "replace", "path": "/title", "value": "lost"}]`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "invalid result",
+			bookID:       id.String(),
+			contentType:  "application/merge-patch+json",
+			payload:      `{"title": null}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "read-only field",
+			bookID:       id.String(),
+			contentType:  "application/merge-patch+json",
+			payload:      `{"status": "CheckedOut"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unsupported content type",
+			bookID:       id.String(),
+			contentType:  "text/plain",
+			payload:      `{"rating": 1}`,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
 	}
 
-	payload, err := json.Marshal(upsertBookRequest)
-	if err != nil {
-		return nil, err
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/%s", baseURL, test.bookID), strings.NewReader(test.payload))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", test.contentType)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, test.expectedCode, resp.StatusCode)
+			if test.check != nil {
+				var patched api.Book
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&patched))
+				test.check(t, &patched)
+			}
+		})
 	}
 
-	req, err := http.NewRequest(http.MethodPost, baseURL, bytes.NewReader(payload))
-	if err != nil {
-		return nil, err
+	// failed patches leave the book intact
+	got, err := getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, "patched", got.Title)
+	assert.Equal(t, "CheckedIn", got.Status)
+}
+
+func TestBookETag(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+	url := fmt.Sprintf("%s/%s", baseURL, id)
+
+	do := func(method, body string, headers map[string]string) *http.Response {
+		var payload io.Reader
+		if body != "" {
+			payload = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, url, payload)
+		require.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	update := `{"title": "etag", "author": "2", "rating": 1}`
+
+	resp := do(http.MethodGet, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	resp = do(http.MethodGet, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp = do(http.MethodPut, update, map[string]string{"If-Match": `"100"`})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = do(http.MethodPut, update, map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+
+	// the stale tag no longer matches any write
+	resp = do(http.MethodGet, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodPatch, `{"rating": 2}`, map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = do(http.MethodPatch, `{"rating": 2}`, map[string]string{"If-Match": `"2"`})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+
+	// changes of the copies change the book too
+	resp, err = client.Post(url+"/copies", "application/json", strings.NewReader(
+		`{"barcode": "`+uuid.New().String()+`", "condition": "New", "status": "Available"}`,
+	))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodDelete, "", map[string]string{"If-Match": `"3"`})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = do(http.MethodDelete, "", map[string]string{"If-Match": `"4"`})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestCheckoutAndReturnBook(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+	bookURL := fmt.Sprintf("%s/%s", baseURL, id)
+
+	memberID, err := createMember("reader", 5)
+	require.NoError(t, err)
+	checkout := fmt.Sprintf(`{"memberId": "%s", "dueDate": "2100-01-01"}`, memberID)
+
+	// returning a book that is not checked out is a conflict
+	resp, err := client.Post(bookURL+"/return", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	cases := map[string]struct {
+		bookID       string
+		payload      string
+		expectedCode int
+	}{
+		"bad id": {
+			bookID:       "i am bad id",
+			payload:      checkout,
+			expectedCode: http.StatusBadRequest,
+		},
+		"missing id": {
+			bookID:       uuid.New().String(),
+			payload:      checkout,
+			expectedCode: http.StatusNotFound,
+		},
+		"no member": {
+			bookID:       id.String(),
+			payload:      `{"dueDate": "2100-01-01"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"missing member": {
+			bookID:       id.String(),
+			payload:      fmt.Sprintf(`{"memberId": "%s"}`, uuid.New()),
+			expectedCode: http.StatusNotFound,
+		},
+		"due date in the past": {
+			bookID:       id.String(),
+			payload:      fmt.Sprintf(`{"memberId": "%s", "dueDate": "2000-01-01"}`, memberID),
+			expectedCode: http.StatusBadRequest,
+		},
+		"valid": {
+			bookID:       id.String(),
+			payload:      checkout,
+			expectedCode: http.StatusOK,
+		},
+		"double checkout": {
+			bookID:       id.String(),
+			payload:      checkout,
+			expectedCode: http.StatusConflict,
+		},
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got non 200 status: %s", resp.Status)
+	// map iteration order is random, while "double checkout" must run after "valid"
+	for _, name := range []string{"bad id", "missing id", "no member", "missing member", "due date in the past", "valid", "double checkout"} {
+		test := cases[name]
+		t.Run(name, func(t *testing.T) {
+			url := fmt.Sprintf("%s/%s/checkout", baseURL, test.bookID)
+			resp, err := client.Post(url, "application/json", strings.NewReader(test.payload))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.expectedCode, resp.StatusCode)
+			if test.expectedCode == http.StatusOK {
+				var loan api.Loan
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&loan))
+				assert.Equal(t, "reader", loan.Borrower)
+				assert.Equal(t, memberID, loan.MemberID)
+				assert.Equal(t, "2100-01-02T00:00:00Z", loan.DueAt)
+				assert.Empty(t, loan.ReturnedAt)
+			}
+		})
 	}
 
+	got, err := getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, "CheckedOut", got.Status)
+
+	resp, err = client.Post(bookURL+"/return", "application/json", nil)
+	require.NoError(t, err)
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var returned api.Loan
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&returned))
+	assert.NotEmpty(t, returned.ReturnedAt)
+
+	got, err = getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, "CheckedIn", got.Status)
+
+	resp, err = client.Get(bookURL + "/loans")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var loans api.ListLoansResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&loans))
+	require.Len(t, loans.Items, 1)
+	assert.Equal(t, returned.ID, loans.Items[0].ID)
+}
+
+func TestMembers(t *testing.T) {
+	memberID, err := createMember("member", 1)
+	require.NoError(t, err)
+	memberURL := fmt.Sprintf("%s/members/%s", serverURL(), memberID)
+
+	resp, err := client.Get(memberURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var member api.Member
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&member))
+	assert.Equal(t, "member", member.Name)
+
+	// duplicated card numbers and emails are rejected
+	dup := fmt.Sprintf(`{"name": "dup", "email": "%s", "cardNumber": "%s", "status": "Active", "borrowingLimit": 1}`,
+		strings.ToUpper(member.Email), uuid.New())
+	resp, err = client.Post(serverURL()+"/members", "application/json", strings.NewReader(dup))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, err = client.Post(serverURL()+"/members", "application/json", strings.NewReader(`{"name": "no email"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the borrowing limit of 1 allows a single checked out book
+	first, err := createBook(book)
+	require.NoError(t, err)
+	second, err := createBook(book)
+	require.NoError(t, err)
+	checkout := fmt.Sprintf(`{"memberId": "%s"}`, memberID)
+
+	resp, err = client.Post(fmt.Sprintf("%s/%s/checkout", baseURL, first), "application/json", strings.NewReader(checkout))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Post(fmt.Sprintf("%s/%s/checkout", baseURL, second), "application/json", strings.NewReader(checkout))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// members with books checked out can't be deleted
+	req, err := http.NewRequest(http.MethodDelete, memberURL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// suspended members can't borrow
+	resp, err = client.Post(fmt.Sprintf("%s/%s/return", baseURL, first), "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	update := fmt.Sprintf(`{"name": "renamed", "email": "%s", "cardNumber": "%s", "status": "Suspended", "borrowingLimit": 1}`,
+		member.Email, member.CardNumber)
+	req, err = http.NewRequest(http.MethodPut, memberURL, strings.NewReader(update))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Post(fmt.Sprintf("%s/%s/checkout", baseURL, second), "application/json", strings.NewReader(checkout))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, err = client.Get(memberURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&member))
+	assert.Equal(t, "renamed", member.Name)
+	assert.Equal(t, "Suspended", member.Status)
+
+	req, err = http.NewRequest(http.MethodDelete, memberURL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(memberURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHolds(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+	bookURL := fmt.Sprintf("%s/%s", baseURL, id)
+
+	var members []*uuid.UUID
+	for _, name := range []string{"borrower", "first", "second"} {
+		memberID, err := createMember(name, 5)
+		require.NoError(t, err)
+		members = append(members, memberID)
 	}
+	borrower, first, second := members[0], members[1], members[2]
 
-	var createResp api.CreateBookResponse
-	if err := json.Unmarshal(body, &createResp); err != nil {
-		return nil, err
+	post := func(url string, memberID *uuid.UUID) *http.Response {
+		resp, err := client.Post(url, "application/json", strings.NewReader(fmt.Sprintf(`{"memberId": "%s"}`, memberID)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
 	}
+	listHolds := func() []*api.Hold {
+		resp, err := client.Get(bookURL + "/holds")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
 
-	return createResp.ID, nil
+		var holds api.ListHoldsResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&holds))
+		return holds.Items
+	}
+
+	// available books can't be held
+	assert.Equal(t, http.StatusConflict, post(bookURL+"/holds", first).StatusCode)
+
+	require.Equal(t, http.StatusOK, post(bookURL+"/checkout", borrower).StatusCode)
+	assert.Equal(t, http.StatusOK, post(bookURL+"/holds", first).StatusCode)
+	assert.Equal(t, http.StatusOK, post(bookURL+"/holds", second).StatusCode)
+	assert.Equal(t, http.StatusConflict, post(bookURL+"/holds", first).StatusCode)
+	assert.Equal(t, http.StatusConflict, post(bookURL+"/holds", borrower).StatusCode)
+
+	holds := listHolds()
+	require.Len(t, holds, 2)
+	assert.Equal(t, *first, holds[0].MemberID)
+	assert.Equal(t, 1, holds[0].Position)
+	assert.Equal(t, "Waiting", holds[0].Status)
+	assert.Equal(t, *second, holds[1].MemberID)
+	assert.Equal(t, 2, holds[1].Position)
+
+	// the returned book is kept for the first member in the queue
+	resp, err := client.Post(bookURL+"/return", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	got, err := getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, "ReadyForPickup", got.Status)
+
+	holds = listHolds()
+	require.Len(t, holds, 2)
+	assert.Equal(t, "Ready", holds[0].Status)
+	assert.NotEmpty(t, holds[0].ExpiresAt)
+
+	assert.Equal(t, http.StatusConflict, post(bookURL+"/checkout", second).StatusCode)
+	assert.Equal(t, http.StatusOK, post(bookURL+"/checkout", first).StatusCode)
+
+	holds = listHolds()
+	require.Len(t, holds, 1)
+	assert.Equal(t, *second, holds[0].MemberID)
+	assert.Equal(t, "Waiting", holds[0].Status)
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/holds/%s", bookURL, holds[0].ID), nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	assert.Empty(t, listHolds())
+}
+
+func TestCopies(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+	copiesURL := fmt.Sprintf("%s/%s/copies", baseURL, id)
+	prefix := uuid.New().String()
+
+	createCopy := func(barcode, status string) *http.Response {
+		resp, err := client.Post(copiesURL, "application/json", strings.NewReader(fmt.Sprintf(
+			`{"barcode": "%s", "location": "A1", "condition": "Good", "status": "%s"}`, barcode, status,
+		)))
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := createCopy(prefix+"-1", "Available")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created api.CreateCopyResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	resp = createCopy(prefix+"-2", "InRepair")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = createCopy(prefix+"-1", "Available")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = createCopy(prefix+"-3", "Borrowed")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = client.Post(fmt.Sprintf("%s/%s/copies", baseURL, uuid.New()), "application/json",
+		strings.NewReader(`{"barcode": "x", "condition": "Good", "status": "Available"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	got, err := getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, api.CopyCounts{Total: 2, Available: 1}, got.Copies)
+
+	resp, err = client.Get(copiesURL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var copies api.ListCopiesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&copies))
+	resp.Body.Close()
+	require.Len(t, copies.Items, 2)
+	assert.Equal(t, prefix+"-1", copies.Items[0].Barcode)
+	assert.Equal(t, "InRepair", copies.Items[1].Status)
+
+	copyURL := fmt.Sprintf("%s/%s", copiesURL, created.ID)
+	req, err := http.NewRequest(http.MethodPut, copyURL, strings.NewReader(
+		`{"barcode": "`+prefix+`-1", "location": "B2", "condition": "Damaged", "status": "Lost"}`,
+	))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(copyURL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var item api.Copy
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&item))
+	resp.Body.Close()
+	assert.Equal(t, "B2", item.Location)
+	assert.Equal(t, "Lost", item.Status)
+
+	got, err = getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, api.CopyCounts{Total: 2, Available: 0}, got.Copies)
+
+	req, err = http.NewRequest(http.MethodDelete, copyURL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	got, err = getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, api.CopyCounts{Total: 1, Available: 0}, got.Copies)
+}
+
+func TestFines(t *testing.T) {
+	memberID, err := createMember("fined", 1)
+	require.NoError(t, err)
+
+	resp, err := client.Get(fmt.Sprintf("%s/members/%s/fines", serverURL(), memberID))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var fines api.ListFinesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&fines))
+	resp.Body.Close()
+	assert.Empty(t, fines.Items)
+	assert.Zero(t, fines.Outstanding)
+
+	resp, err = client.Get(fmt.Sprintf("%s/members/%s/fines", serverURL(), uuid.New()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Post(fmt.Sprintf("%s/fines/%s/pay", serverURL(), uuid.New()), "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Post(serverURL()+"/fines/1/pay", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrash(t *testing.T) {
+	id, err := createBook(book)
+	require.NoError(t, err)
+	bookURL := fmt.Sprintf("%s/%s", baseURL, id)
+
+	req, err := http.NewRequest(http.MethodDelete, bookURL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(bookURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Get(baseURL + "/trash")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var trash api.ListTrashResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&trash))
+	resp.Body.Close()
+	var trashed *api.Book
+	for _, item := range trash.Items {
+		if item.ID == *id {
+			trashed = item
+		}
+	}
+	require.NotNil(t, trashed)
+	assert.NotEmpty(t, trashed.DeletedAt)
+
+	resp, err = client.Post(bookURL+"/restore", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var restored api.Book
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&restored))
+	resp.Body.Close()
+	assert.Empty(t, restored.DeletedAt)
+	assert.Equal(t, trashed.Version+1, restored.Version)
+	assert.Equal(t, fmt.Sprintf(`"%d"`, restored.Version), resp.Header.Get("ETag"))
+
+	got, err := getBook(*id)
+	require.NoError(t, err)
+	assert.Equal(t, book.Title, got.Title)
+
+	// only books in the trash can be restored
+	resp, err = client.Post(bookURL+"/restore", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAudit(t *testing.T) {
+	since := time.Now().Add(-time.Second)
+	do := func(method, url, payload string) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(payload))
+		require.NoError(t, err)
+		req.Header.Set("X-Actor", "librarian@example.com")
+		req.Header.Set("X-Request-ID", "audit-"+method)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := do(http.MethodPost, baseURL, `{"title": "before", "author": "a", "rating": 1}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created api.CreateBookResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	bookURL := fmt.Sprintf("%s/%s", baseURL, created.ID)
+
+	resp = do(http.MethodPut, bookURL, `{"title": "after", "author": "a", "rating": 1}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodDelete, bookURL, "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err := client.Get(bookURL + "/history")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var history api.ListAuditResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	resp.Body.Close()
+
+	require.Len(t, history.Items, 3)
+	var actions []string
+	for _, entry := range history.Items {
+		actions = append(actions, entry.Action)
+		assert.Equal(t, "librarian@example.com", entry.Actor)
+	}
+	assert.Equal(t, []string{"Delete", "Update", "Create"}, actions)
+	assert.Equal(t, "audit-POST", history.Items[2].RequestID)
+	assert.Empty(t, history.Items[2].Before)
+
+	var before, after api.Book
+	require.NoError(t, json.Unmarshal(history.Items[1].Before, &before))
+	require.NoError(t, json.Unmarshal(history.Items[1].After, &after))
+	assert.Equal(t, "before", before.Title)
+	assert.Equal(t, "after", after.Title)
+	require.NoError(t, json.Unmarshal(history.Items[0].After, &after))
+	assert.NotEmpty(t, after.DeletedAt)
+
+	resp, err = client.Get(fmt.Sprintf("%s/audit?from=%s", serverURL(), since.UTC().Format(time.RFC3339)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var audit api.ListAuditResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&audit))
+	resp.Body.Close()
+	var found int
+	for _, entry := range audit.Items {
+		if entry.BookID == *created.ID {
+			found++
+		}
+	}
+	assert.Equal(t, 3, found)
+
+	resp, err = client.Get(fmt.Sprintf("%s/audit?to=%s", serverURL(), since.UTC().Format(time.RFC3339)))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&audit))
+	resp.Body.Close()
+	for _, entry := range audit.Items {
+		assert.NotEqual(t, *created.ID, entry.BookID)
+	}
+
+	resp, err = client.Get(serverURL() + "/audit?from=yesterday")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = client.Get(fmt.Sprintf("%s/%s/history", baseURL, uuid.New()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestBookEvents(t *testing.T) {
+	// stream opens the event stream and returns the reader of its events
+	stream := func(lastEventID string) (*http.Response, func() (id, event string, data []byte)) {
+		req, err := http.NewRequest(http.MethodGet, baseURL+"/events", nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		return resp, func() (id, event string, data []byte) {
+			for {
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "" && event != "":
+					return id, event, data
+				case strings.HasPrefix(line, "id: "):
+					id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					event = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					data = []byte(strings.TrimPrefix(line, "data: "))
+				}
+			}
+		}
+	}
+
+	resp, next := stream("")
+	id, err := createBook(book)
+	require.NoError(t, err)
+
+	firstID, eventType, data := next()
+	assert.Equal(t, "book.created", eventType)
+	var event api.Event
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, *id, event.BookID)
+	assert.Equal(t, firstID, strconv.FormatInt(event.ID, 10))
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/%s", baseURL, id), nil)
+	require.NoError(t, err)
+	deleteResp, err := client.Do(req)
+	require.NoError(t, err)
+	deleteResp.Body.Close()
+	require.Equal(t, http.StatusOK, deleteResp.StatusCode)
+	_, eventType, _ = next()
+	assert.Equal(t, "book.deleted", eventType)
+	resp.Body.Close()
+
+	// reconnecting with the last event id replays the events missed
+	resp, next = stream(firstID)
+	_, eventType, data = next()
+	resp.Body.Close()
+	assert.Equal(t, "book.deleted", eventType)
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, *id, event.BookID)
+
+	req, err = http.NewRequest(http.MethodGet, baseURL+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "latest")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestBookEventsWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	store := storage.NewMemory()
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: startEvents(t, store), WriteTimeout: writeTimeout})
+	srv := httptest.NewUnstartedServer(server.NewRouter(server.RouterParams{Handler: handler}))
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := client.Get(srv.URL + "/books/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the event is sent after the write timeout of the server
+	time.Sleep(3 * srv.Config.WriteTimeout)
+	_, err = store.CreateBook(context.Background(), &models.Book{Title: "late", Author: "a", Rating: 1})
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "event: book.created\n" {
+			break
+		}
+	}
+}
+
+func TestBookEventsStalledClient(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	store := storage.NewMemory()
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: startEvents(t, store), WriteTimeout: writeTimeout})
+	router := server.NewRouter(server.RouterParams{Handler: handler})
+	done := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+		if r.URL.Path == "/books/events" {
+			close(done)
+		}
+	}))
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	defer srv.Close()
+
+	// the client keeps the connection open but never reads the stream
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "GET /books/events HTTP/1.1\r\nHost: books\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(writeTimeout)
+
+	// the events fill the socket buffers, the blocked write fails at the write deadline
+	title := strings.Repeat("a", 1<<20)
+	for i := 0; i < 32; i++ {
+		_, err = store.CreateBook(context.Background(), &models.Book{Title: title, Author: "a", Rating: 1})
+		require.NoError(t, err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream of the stalled client is still open")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var received []*http.Request
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r)
+		if len(received) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	resp, err := client.Post(serverURL()+"/webhooks", "application/json",
+		strings.NewReader(`{"url": "ftp://example.com", "eventTypes": ["book.borrowed"]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	payload := fmt.Sprintf(`{"url": "%s", "eventTypes": ["book.created"]}`, receiver.URL)
+	resp, err = client.Post(serverURL()+"/webhooks", "application/json", strings.NewReader(payload))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created api.CreateWebhookResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	assert.NotEmpty(t, created.Secret)
+	webhookURL := fmt.Sprintf("%s/webhooks/%s", serverURL(), created.ID)
+
+	resp, err = client.Get(webhookURL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var hook api.Webhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&hook))
+	resp.Body.Close()
+	assert.Equal(t, receiver.URL, hook.URL)
+	assert.Equal(t, []string{"book.created"}, hook.EventTypes)
+	assert.True(t, hook.Active)
+
+	// the test delivery is signed with the secret returned on create
+	resp, err = client.Post(webhookURL+"/test", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var delivery api.WebhookDelivery
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&delivery))
+	resp.Body.Close()
+	assert.Equal(t, "Delivered", delivery.Status)
+	assert.Equal(t, "webhook.test", delivery.EventType)
+	require.Len(t, received, 1)
+	timestamp, err := strconv.ParseInt(received[0].Header.Get(webhook.HeaderTimestamp), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.Sign(created.Secret, timestamp, delivery.Payload), received[0].Header.Get(webhook.HeaderSignature))
+	assert.Equal(t, delivery.ID.String(), received[0].Header.Get(webhook.HeaderDelivery))
+
+	// failed test deliveries are not retried
+	resp, err = client.Post(webhookURL+"/test", "application/json", nil)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&delivery))
+	resp.Body.Close()
+	assert.Equal(t, "Dead", delivery.Status)
+	assert.Equal(t, http.StatusInternalServerError, delivery.LastStatusCode)
+
+	resp, err = client.Get(webhookURL + "/deliveries?status=Dead")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var deliveries api.ListWebhookDeliveriesResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	resp.Body.Close()
+	require.Len(t, deliveries.Items, 1)
+	assert.Equal(t, delivery.ID, deliveries.Items[0].ID)
+
+	resp, err = client.Get(webhookURL + "/deliveries?status=Lost")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPut, webhookURL, strings.NewReader(fmt.Sprintf(`{"url": "%s", "active": false}`, receiver.URL)))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(webhookURL)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&hook))
+	resp.Body.Close()
+	assert.False(t, hook.Active)
+	assert.Empty(t, hook.EventTypes)
+
+	// updates without an active state don't enable the webhook again
+	req, err = http.NewRequest(http.MethodPut, webhookURL, strings.NewReader(payload))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(webhookURL)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&hook))
+	resp.Body.Close()
+	assert.False(t, hook.Active)
+	assert.Equal(t, []string{"book.created"}, hook.EventTypes)
+
+	req, err = http.NewRequest(http.MethodDelete, webhookURL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, url := range []string{webhookURL, webhookURL + "/deliveries"} {
+		resp, err = client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	resp, err = client.Post(webhookURL+"/test", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestImportBooks(t *testing.T) {
+	importBooks := func(query, contentType, body string) (*http.Response, *api.ImportReport) {
+		resp, err := client.Post(baseURL+":import"+query, contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var report api.ImportReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp, &report
+	}
+
+	csvBody := "\ufeffTitle,author,publisher,publishDate,rating\n" +
+		"Import 1,a,p,2021-07-07,3\n" +
+		",a,p,2021-07-07,3\n" +
+		"Import 2,a,p,2021-07-07,x\n" +
+		"Import 3,a,p,,1\n"
+
+	// a dry run validates the records without creating the books
+	resp, report := importBooks("?dryRun=true", "text/csv", csvBody)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 2, report.Failed)
+	require.Len(t, report.Rows, 4)
+	assert.Nil(t, report.Rows[0].ID)
+
+	resp, report = importBooks("", "text/csv; charset=utf-8", csvBody)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 2, report.Failed)
+	require.Len(t, report.Rows, 4)
+	assert.Equal(t, 2, report.Rows[1].Row)
+	assert.Nil(t, report.Rows[1].ID)
+	require.Len(t, report.Rows[1].Errors, 1)
+	assert.Equal(t, "title", report.Rows[1].Errors[0].Field)
+	require.Len(t, report.Rows[2].Errors, 1)
+	assert.Equal(t, "rating", report.Rows[2].Errors[0].Field)
+
+	require.NotNil(t, report.Rows[0].ID)
+	imported, err := getBook(*report.Rows[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Import 1", imported.Title)
+	assert.Equal(t, "2021-07-07", imported.PublishDate)
+	assert.Equal(t, 3, imported.Rating)
+	require.NotNil(t, report.Rows[3].ID)
+
+	// the format param takes precedence over the content type, blank lines are skipped
+	ndjsonBody := `{"title": "Import 4", "author": "a", "publisher": "p", "rating": 2}` + "\n\n" +
+		`{"title": "Import 5"` + "\n" +
+		`{"title": "Import 6", "author": "a", "publisher": "p", "rating": 9}` + "\n"
+	resp, report = importBooks("?format=ndjson", "text/plain", ndjsonBody)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 1, report.Imported)
+	require.Len(t, report.Rows, 3)
+	require.NotNil(t, report.Rows[0].ID)
+	assert.Contains(t, report.Rows[1].Error, "invalid JSON record")
+	require.Len(t, report.Rows[2].Errors, 1)
+	assert.Equal(t, "rating", report.Rows[2].Errors[0].Field)
+
+	resp, _ = importBooks("", "text/csv", "title,isbn\nImport 7,123\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = importBooks("", "application/json", ndjsonBody)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, err = client.Get(baseURL + ":import")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+}
+
+func TestExportBooks(t *testing.T) {
+	author := "Export " + uuid.New().String()
+	for _, title := range []string{"b", "a", "<c & d>"} {
+		_, err := createBook(&api.Book{Title: title, Author: author, Publisher: "p", Rating: 3})
+		require.NoError(t, err)
+	}
+
+	exportBooks := func(query string) (*http.Response, []byte) {
+		resp, err := client.Get(baseURL + ":export?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+	filter := "author=" + url.QueryEscape(author) + "&sort=title"
+
+	resp, body := exportBooks(filter)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="books.csv"`, resp.Header.Get("Content-Disposition"))
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, "id", records[0][0])
+	assert.Equal(t, "title", records[0][1])
+	assert.Equal(t, []string{"<c & d>", "a", "b"}, []string{records[1][1], records[2][1], records[3][1]})
+	assert.Equal(t, author, records[1][2])
+
+	// CSV exports are imported back without the read-only columns
+	resp, err = client.Post(baseURL+":import?dryRun=true", "text/csv", bytes.NewReader(body))
+	require.NoError(t, err)
+	var report api.ImportReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	resp.Body.Close()
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 0, report.Failed)
+
+	resp, body = exportBooks(filter + "&format=ndjson&status=CheckedOut")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, body)
+
+	resp, body = exportBooks(filter + "&format=ndjson")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	var titles []string
+	for decoder.More() {
+		var exported api.Book
+		require.NoError(t, decoder.Decode(&exported))
+		titles = append(titles, exported.Title)
+	}
+	assert.Equal(t, []string{"<c & d>", "a", "b"}, titles)
+
+	resp, body = exportBooks(filter + "&format=xlsx")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	var sheet []byte
+	for _, file := range archive.File {
+		if file.Name == "xl/worksheets/sheet1.xml" {
+			f, err := file.Open()
+			require.NoError(t, err)
+			sheet, err = ioutil.ReadAll(f)
+			require.NoError(t, err)
+			f.Close()
+		}
+	}
+	require.NotNil(t, sheet)
+	var worksheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	require.NoError(t, xml.Unmarshal(sheet, &worksheet))
+	require.Len(t, worksheet.Rows, 4)
+	assert.Equal(t, "<c & d>", worksheet.Rows[1].Cells[1].Inline)
+	assert.Equal(t, "3", worksheet.Rows[1].Cells[5].Value)
+
+	resp, _ = exportBooks("format=pdf")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = exportBooks("sort=isbn")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExportBooksWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	store := slowExportStore{Storage: storage.NewMemory(), pause: writeTimeout}
+	for _, title := range []string{"a", "b", "c"} {
+		_, err := store.CreateBook(context.Background(), &models.Book{Title: title, Author: "a", Rating: 1})
+		require.NoError(t, err)
+	}
+
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: startEvents(t, store), WriteTimeout: writeTimeout})
+	srv := httptest.NewUnstartedServer(server.NewRouter(server.RouterParams{Handler: handler}))
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	defer srv.Close()
+
+	// the export is written after the write timeout of the server
+	resp, err := client.Get(srv.URL + "/books:export")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 4)
+}
+
+func TestMetrics(t *testing.T) {
+	resp, err := client.Get(fmt.Sprintf("%s/%s", baseURL, uuid.New()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Get(baseURL + "/search?q=metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = client.Get(serverURL() + "/no/such/path")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	req, err := http.NewRequest("MADEUP", baseURL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = client.Get(serverURL() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	scraped := string(body)
+
+	assert.Contains(t, scraped, `http_requests_total{route="/books/:id",method="GET",status="404"}`)
+	assert.Contains(t, scraped, `http_requests_total{route="/books/search",method="GET",status="200"}`)
+	assert.Contains(t, scraped, `http_requests_total{route="unmatched",method="GET",status="404"}`)
+	assert.Contains(t, scraped, `http_requests_total{route="unmatched",method="other",status="405"}`)
+	assert.NotContains(t, scraped, "MADEUP")
+	assert.Contains(t, scraped, `http_request_duration_seconds_bucket{route="/books/:id",method="GET",status="404",le="+Inf"}`)
+	assert.Contains(t, scraped, `storage_call_duration_seconds_count{method="GetBook"}`)
+	assert.Contains(t, scraped, `storage_errors_total{method="GetBook",kind="not_found"}`)
+}
+
+func TestHealth(t *testing.T) {
+	resp, err := client.Get(serverURL() + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(serverURL() + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(serverURL() + "/health")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var health api.HealthResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	resp.Body.Close()
+	assert.Equal(t, api.HealthOK, health.Status)
+	var names []string
+	for _, check := range health.Checks {
+		names = append(names, check.Name)
+		assert.Equal(t, api.HealthOK, check.Status)
+	}
+	assert.Equal(t, []string{"database", "migrations", "shutdown"}, names)
+
+	// readiness fails once the shutdown starts, the process stays alive
+	store := storage.NewMemory()
+	shutdown := server.NewHealth(server.HealthParams{Storage: store})
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: startEvents(t, store), Health: shutdown})
+	srv := httptest.NewServer(server.NewRouter(server.RouterParams{Handler: handler}))
+	defer srv.Close()
+	shutdown.Shutdown()
+
+	resp, err = client.Get(srv.URL + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = client.Get(srv.URL + "/health")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	resp.Body.Close()
+	assert.Equal(t, api.HealthFailing, health.Status)
+	require.Len(t, health.Checks, 3)
+	assert.Equal(t, api.HealthFailing, health.Checks[2].Status)
+	assert.NotEmpty(t, health.Checks[2].Error)
+
+	resp, err = client.Get(srv.URL + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRequestLogging(t *testing.T) {
+	var out syncBuffer
+	logger, err := logging.New(logging.Params{Output: &out, Level: "debug", Format: logging.FormatJSON})
+	require.NoError(t, err)
+	defaultLogger := logging.Default()
+	logging.SetDefault(logger)
+	defer logging.SetDefault(defaultLogger)
+
+	store := storage.Instrument(storage.NewMemory(), logging.StorageObserver())
+	handler := server.NewHandler(server.HandlerParams{Storage: store, Events: startEvents(t, store)})
+	srv := httptest.NewServer(server.NewRouter(server.RouterParams{Handler: handler}))
+	defer srv.Close()
+
+	bookID := uuid.New()
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/books/%s", srv.URL, bookID), nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "logging-test")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	lines := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields), line)
+		if fields["request_id"] == "logging-test" {
+			lines[fields["msg"].(string)] = fields
+		}
+	}
+
+	// the failed storage call is correlated with the request
+	storageLine := lines["storage call failed"]
+	require.NotNil(t, storageLine)
+	assert.Equal(t, "debug", storageLine["level"])
+	assert.Equal(t, "GetBook", storageLine["method"])
+	assert.Equal(t, "not_found", storageLine["kind"])
+
+	requestLine := lines["request"]
+	require.NotNil(t, requestLine)
+	assert.Equal(t, "info", requestLine["level"])
+	assert.Equal(t, "GET", requestLine["method"])
+	assert.Equal(t, "/books/:id", requestLine["route"])
+	assert.Equal(t, float64(http.StatusNotFound), requestLine["status"])
+	assert.Equal(t, bookID.String(), requestLine["book_id"])
+	assert.Contains(t, requestLine, "duration_ms")
+	assert.Contains(t, requestLine, "time")
+}
+
+func TestErrorProblem(t *testing.T) {
+	decodeProblem := func(resp *http.Response) *api.Problem {
+		defer resp.Body.Close()
+		require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+		var problem api.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		assert.Equal(t, resp.StatusCode, problem.Status)
+		assert.NotEmpty(t, problem.Title)
+		assert.Equal(t, resp.Header.Get("X-Request-ID"), problem.RequestID)
+		return &problem
+	}
+
+	req, err := http.NewRequest(http.MethodPost, baseURL, strings.NewReader(`{"publisher": "p", "rating": 5}`))
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "test-request-1")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	problem := decodeProblem(resp)
+	assert.Equal(t, "test-request-1", problem.RequestID)
+	assert.Equal(t, api.CodeValidationFailed, problem.Code)
+	var fields []string
+	for _, fieldErr := range problem.Errors {
+		fields = append(fields, fieldErr.Field)
+		assert.NotEmpty(t, fieldErr.Message)
+	}
+	assert.Equal(t, []string{"author", "rating", "title"}, fields)
+
+	resp, err = client.Get(fmt.Sprintf("%s/%s", baseURL, uuid.New()))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	problem = decodeProblem(resp)
+	assert.Equal(t, "book_not_found", problem.Code)
+	assert.NotEmpty(t, problem.RequestID)
+
+	resp, err = client.Get(baseURL + "/not-an-id")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.CodeInvalidID, decodeProblem(resp).Code)
+
+	resp, err = client.Get(serverURL() + "/nothing-here")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, api.CodeNotFound, decodeProblem(resp).Code)
+}
+
+// serverURL returns the root URL of the service
+func serverURL() string {
+	return strings.TrimSuffix(baseURL, "/books")
+}
+
+// createMember creates an active member with unique email and card number
+func createMember(name string, borrowingLimit int) (*uuid.UUID, error) {
+	unique := uuid.New().String()
+	payload, err := json.Marshal(&api.UpsertMemberRequest{
+		Name:           name,
+		Email:          unique + "@example.com",
+		CardNumber:     unique,
+		Status:         "Active",
+		BorrowingLimit: borrowingLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(serverURL()+"/members", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got non 200 status: %s", resp.Status)
+	}
+
+	var createResp api.CreateMemberResponse
+	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
+		return nil, err
+	}
+
+	return createResp.ID, nil
+}
+
+func getBook(id uuid.UUID) (*api.Book, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/%s", baseURL, id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got non 200 status: %s", resp.Status)
+	}
+
+	var got api.Book
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		return nil, err
+	}
+
+	return &got, nil
+}
+
+func createBook(book *api.Book) (*uuid.UUID, error) {
+	upsertBookRequest := &api.UpsertBookRequest{
+		Title:       book.Title,
+		Author:      book.Author,
+		Publisher:   book.Publisher,
+		PublishDate: book.PublishDate,
+		Rating:      book.Rating,
+	}
+
+	payload, err := json.Marshal(upsertBookRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, baseURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got non 200 status: %s", resp.Status)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var createResp api.CreateBookResponse
+	if err := json.Unmarshal(body, &createResp); err != nil {
+		return nil, err
+	}
+
+	return createResp.ID, nil
+}
+
+// syncBuffer collects the log lines written while the server is running
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// slowExportStore pauses before every exported book, so exports take longer than the write timeout
+type slowExportStore struct {
+	storage.Storage
+	pause time.Duration
+}
+
+func (s slowExportStore) ExportBooks(ctx context.Context, query storage.ListBooksQuery, fn func(book *models.Book) error) error {
+	return s.Storage.ExportBooks(ctx, query, func(book *models.Book) error {
+		time.Sleep(s.pause)
+		return fn(book)
+	})
 }
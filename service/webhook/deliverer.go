@@ -0,0 +1,249 @@
+// Package webhook delivers book events to the webhooks subscribed to them.
+//
+// The outbox hands every event to Sink, which queues a delivery for each subscribed webhook,
+// so a failing webhook doesn't hold up the others. Deliverer posts the pending deliveries
+// signed with the secret of their webhook, retries the failed ones with exponential backoff
+// and marks them dead once they run out of attempts. A webhook failing an attempt is skipped
+// for the rest of the run, the others are still delivered to.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/outbox"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// Headers of every delivery. Receivers verify the signature and reject old timestamps to prevent replays
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns the signature of the body sent at the unix timestamp: "sha256=" followed by
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret of the webhook
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret for a webhook created without one
+func NewSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+type Params struct {
+	Storage storage.WebhookStorage
+	// BatchSize is the number of deliveries fetched at once, defaults to 100
+	BatchSize int
+	// MaxAttempts is the number of attempts of a delivery before it's dead, 0 means no limit
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed attempt, doubled with every next one up to MaxBackoff.
+	// They default to 10 seconds and an hour
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout limits every attempt, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+type Deliverer struct {
+	store       storage.WebhookStorage
+	client      *http.Client
+	batchSize   int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+func NewDeliverer(params Params) *Deliverer {
+	d := &Deliverer{
+		store:       params.Storage,
+		batchSize:   params.BatchSize,
+		maxAttempts: params.MaxAttempts,
+		minBackoff:  params.MinBackoff,
+		maxBackoff:  params.MaxBackoff,
+	}
+	if d.batchSize <= 0 {
+		d.batchSize = 100
+	}
+	if d.minBackoff <= 0 {
+		d.minBackoff = 10 * time.Second
+	}
+	if d.maxBackoff <= 0 {
+		d.maxBackoff = time.Hour
+	}
+
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	d.client = &http.Client{
+		Timeout: timeout,
+		// a redirect fails the attempt, the webhook URL has to be updated instead
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// Deliver attempts the pending deliveries until none is left. Once an attempt of a webhook fails,
+// its other deliveries wait for the next run, so an unreachable webhook costs a single timeout
+// per run. It returns the number of deliveries that succeeded
+func (d *Deliverer) Deliver(ctx context.Context) (int, error) {
+	// webhooks of the run, nil for the ones deleted meanwhile
+	webhooks := make(map[uuid.UUID]*models.Webhook)
+	// webhooks failed in the run, left out of the next batches
+	failing := make(map[uuid.UUID]bool)
+	var skip []uuid.UUID
+
+	var delivered int
+	for {
+		deliveries, err := d.store.PendingWebhookDeliveries(ctx, d.batchSize, skip)
+		if err != nil {
+			return delivered, err
+		}
+
+		batchDelivered, batchSkipped := 0, len(skip)
+		for _, delivery := range deliveries {
+			if failing[delivery.WebhookID] {
+				continue
+			}
+			webhook, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				if webhook, err = d.store.GetWebhook(ctx, delivery.WebhookID); err != nil && !errors.Is(err, storage.ErrWebhookNotFound) {
+					return delivered, err
+				}
+				webhooks[delivery.WebhookID] = webhook
+			}
+			if webhook == nil {
+				continue
+			}
+
+			result, err := d.attempt(ctx, webhook, delivery, true)
+			if err != nil {
+				if errors.Is(err, storage.ErrWebhookNotFound) {
+					continue
+				}
+				return delivered, err
+			}
+			if result.Status == models.WebhookDeliveryDelivered {
+				batchDelivered++
+			} else {
+				failing[delivery.WebhookID] = true
+				skip = append(skip, delivery.WebhookID)
+			}
+		}
+
+		// the next batch holds new deliveries unless none was delivered and no webhook failed
+		delivered += batchDelivered
+		if len(deliveries) < d.batchSize || (batchDelivered == 0 && len(skip) == batchSkipped) {
+			return delivered, nil
+		}
+	}
+}
+
+// Test sends a webhook.test event to the webhook, active or not, and returns the delivery.
+// It's attempted once and logged with the other deliveries of the webhook
+func (d *Deliverer) Test(ctx context.Context, webhookID uuid.UUID) (*models.WebhookDelivery, error) {
+	webhook, err := d.store.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(&api.WebhookTestEvent{
+		Type:       string(models.EventWebhookTest),
+		WebhookID:  webhook.ID,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := d.store.CreateWebhookDelivery(ctx, &models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventType: models.EventWebhookTest,
+		Payload:   payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.attempt(ctx, webhook, delivery, false)
+}
+
+// attempt posts the delivery to the webhook and records the result. Failed deliveries are
+// retried after the backoff when retry is set, otherwise they are dead
+func (d *Deliverer) attempt(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery, retry bool) (*models.WebhookDelivery, error) {
+	statusCode, err := d.post(ctx, webhook, delivery)
+
+	result := models.WebhookAttempt{
+		Status:     models.WebhookDeliveryDelivered,
+		StatusCode: statusCode,
+	}
+	if err != nil {
+		attempts := delivery.Attempts + 1
+		result.Error = err.Error()
+		if retry && (d.maxAttempts <= 0 || attempts < d.maxAttempts) {
+			result.Status = models.WebhookDeliveryPending
+			result.RetryIn = outbox.Backoff(d.minBackoff, d.maxBackoff, attempts)
+		} else {
+			result.Status = models.WebhookDeliveryDead
+		}
+	}
+
+	return d.store.RecordWebhookAttempt(ctx, delivery.ID, result)
+}
+
+// post sends the signed payload of the delivery and returns the response status, 0 without a response.
+// Any status but 2xx fails the attempt
+func (d *Deliverer) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/service/outbox"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the deliveries with a valid signature and responds with the status
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []api.Event
+	invalid  int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if r.Header.Get(HeaderSignature) != Sign(rc.secret, timestamp, body) || r.Header.Get(HeaderDelivery) == "" {
+		rc.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var event api.Event
+	json.Unmarshal(body, &event)
+	rc.received = append(rc.received, event)
+	if rc.status != 0 {
+		w.WriteHeader(rc.status)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+
+	all := &receiver{secret: "all-secret-0123456789"}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	deleted := &receiver{secret: "deleted-secret-0123456789"}
+	deletedServer := httptest.NewServer(deleted)
+	defer deletedServer.Close()
+
+	_, err := store.CreateWebhook(ctx, &models.Webhook{URL: allServer.URL, Secret: all.secret, Active: true})
+	require.NoError(t, err)
+	_, err = store.CreateWebhook(ctx, &models.Webhook{
+		URL: deletedServer.URL, Secret: deleted.secret, Active: true, EventTypes: []models.EventType{models.EventBookDeleted},
+	})
+	require.NoError(t, err)
+
+	bookID, err := store.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteBook(ctx, *bookID, 0))
+
+	// the outbox queues the events for the webhooks, redelivered events are not queued again
+	sink := NewSink(store)
+	_, err = outbox.NewDispatcher(outbox.Params{Storage: store, Sinks: []outbox.Sink{sink}}).Dispatch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, sink.Deliver(ctx, &api.Event{ID: 2, Type: "book.deleted", BookID: *bookID}))
+
+	d := NewDeliverer(Params{Storage: store})
+	delivered, err := d.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+
+	assert.Zero(t, all.invalid+deleted.invalid)
+	require.Len(t, all.received, 2)
+	assert.Equal(t, "book.created", all.received[0].Type)
+	assert.Equal(t, "book.deleted", all.received[1].Type)
+	require.Len(t, deleted.received, 1)
+	assert.Equal(t, *bookID, deleted.received[0].BookID)
+
+	delivered, err = d.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, delivered)
+}
+
+func TestDeliverDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+
+	failing := &receiver{secret: "failing-secret-0123456789", status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(failing)
+	defer server.Close()
+
+	webhookID, err := store.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Secret: failing.secret, Active: true})
+	require.NoError(t, err)
+	eventID := int64(1)
+	_, err = store.EnqueueWebhookDeliveries(ctx, &models.WebhookDelivery{
+		EventID: &eventID, EventType: models.EventBookCreated, Payload: []byte(`{"id": 1}`),
+	})
+	require.NoError(t, err)
+
+	d := NewDeliverer(Params{Storage: store, MaxAttempts: 2, MinBackoff: time.Millisecond})
+	for i := 0; i < 3; i++ {
+		time.Sleep(2 * time.Millisecond)
+		delivered, err := d.Deliver(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, delivered)
+	}
+	assert.Len(t, failing.received, 2)
+
+	deliveries, err := store.ListWebhookDeliveries(ctx, *webhookID, storage.WebhookDeliveryQuery{})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.WebhookDeliveryDead, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].LastStatusCode)
+	assert.Equal(t, "unexpected response status 503", deliveries[0].LastError)
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+
+	// the unreachable webhook never responds, its attempts time out
+	var attempts int32
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer unreachable.Close()
+	healthy := &receiver{secret: "healthy-secret-0123456789"}
+	healthyServer := httptest.NewServer(healthy)
+	defer healthyServer.Close()
+
+	// the backlog of the unreachable webhook fills the first batches
+	enqueue := func(eventID int64) {
+		_, err := store.EnqueueWebhookDeliveries(ctx, &models.WebhookDelivery{
+			EventID: &eventID, EventType: models.EventBookCreated, Payload: []byte(`{}`),
+		})
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	_, err := store.CreateWebhook(ctx, &models.Webhook{URL: unreachable.URL, Secret: "unreachable", Active: true})
+	require.NoError(t, err)
+	for eventID := int64(1); eventID <= 4; eventID++ {
+		enqueue(eventID)
+	}
+	_, err = store.CreateWebhook(ctx, &models.Webhook{URL: healthyServer.URL, Secret: healthy.secret, Active: true})
+	require.NoError(t, err)
+	for eventID := int64(5); eventID <= 7; eventID++ {
+		enqueue(eventID)
+	}
+
+	d := NewDeliverer(Params{Storage: store, BatchSize: 2, Timeout: 50 * time.Millisecond})
+	delivered, err := d.Deliver(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+	assert.Len(t, healthy.received, 3)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28",
+		Sign("secret", 1600000000, []byte("{}")))
+}
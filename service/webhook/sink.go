@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/alexkaplun/books-test/service/api"
+	"github.com/alexkaplun/books-test/storage"
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// Sink is the outbox sink queueing every event for the webhooks subscribed to it.
+// Events the outbox delivers again are not queued twice
+type Sink struct {
+	store storage.WebhookStorage
+}
+
+func NewSink(store storage.WebhookStorage) *Sink {
+	return &Sink{
+		store: store,
+	}
+}
+
+func (s *Sink) Name() string {
+	return "webhooks"
+}
+
+func (s *Sink) Deliver(ctx context.Context, event *api.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	eventID := event.ID
+	_, err = s.store.EnqueueWebhookDeliveries(ctx, &models.WebhookDelivery{
+		EventID:   &eventID,
+		EventType: models.EventType(event.Type),
+		Payload:   payload,
+	})
+	return err
+}
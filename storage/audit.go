@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// AnonymousActor is recorded for changes made without an actor in the context
+const AnonymousActor = "anonymous"
+
+// AuditInfo identifies the origin of the changes recorded in the audit log
+type AuditInfo struct {
+	Actor     string
+	RequestID string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns the context whose book changes are recorded with the info
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+func auditInfoFrom(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	if info.Actor == "" {
+		info.Actor = AnonymousActor
+	}
+	return info
+}
+
+// auditCursorSort marks the cursors of the audit log, it's always listed latest first
+const auditCursorSort = "audit"
+
+// AuditQuery filters and pages the audit log. Zero values mean no filter
+type AuditQuery struct {
+	// From is the earliest change included
+	From *time.Time
+	// To excludes changes made at or after it
+	To *time.Time
+	// Limit is the page size, defaults to DefaultListLimit and is capped by MaxListLimit
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+type ListAuditResult struct {
+	Entries []*models.AuditEntry
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// normalize applies the default limit and returns the position of the cursor, nil for the first page
+func (q AuditQuery) normalize() (AuditQuery, *cursor, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+	if q.Cursor == "" {
+		return q, nil, nil
+	}
+
+	c, err := decodeCursor(q.Cursor)
+	if err != nil || c.Sort != auditCursorSort {
+		return q, nil, ErrInvalidCursor
+	}
+	if _, err = time.Parse(cursorTimeLayout, c.Value); err != nil {
+		return q, nil, ErrInvalidCursor
+	}
+	return q, c, nil
+}
+
+func auditCursorFor(entry *models.AuditEntry) string {
+	return encodeCursor(&cursor{
+		Sort:  auditCursorSort,
+		Value: entry.CreatedAt.UTC().Format(cursorTimeLayout),
+		ID:    entry.ID,
+	})
+}
+
+// bookSnapshot is the JSON form of a book in the audit log
+type bookSnapshot struct {
+	ID          uuid.UUID         `json:"id"`
+	Title       string            `json:"title"`
+	Author      string            `json:"author"`
+	Publisher   string            `json:"publisher"`
+	PublishDate string            `json:"publishDate,omitempty"`
+	Rating      int               `json:"rating"`
+	Status      models.BookStatus `json:"status"`
+	Version     int               `json:"version"`
+	UpdatedAt   *time.Time        `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time        `json:"deletedAt,omitempty"`
+}
+
+// snapshotBook returns the audit snapshot of the book, nil for no book
+func snapshotBook(book *models.Book) json.RawMessage {
+	if book == nil {
+		return nil
+	}
+
+	snapshot := bookSnapshot{
+		ID:        book.ID,
+		Title:     book.Title,
+		Author:    book.Author,
+		Publisher: book.Publisher,
+		Rating:    book.Rating,
+		Status:    book.Status,
+		Version:   book.Version,
+		UpdatedAt: book.UpdatedAt,
+		DeletedAt: book.DeletedAt,
+	}
+	if book.PublishDate != nil {
+		snapshot.PublishDate = book.PublishDate.Format(dateLayout)
+	}
+
+	payload, _ := json.Marshal(snapshot)
+	return payload
+}
+
+func (s *storeImpl) ListBookHistory(ctx context.Context, bookID uuid.UUID, query AuditQuery) (*ListAuditResult, error) {
+	result, err := s.listAudit(ctx, &bookID, query)
+	if err != nil || len(result.Entries) > 0 {
+		return result, err
+	}
+
+	// the history outlives purged books, books never changed have none
+	var exists bool
+	if err = s.db.QueryRowContext(ctx, bookHistoryExists, bookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBookNotFound
+	}
+	return result, nil
+}
+
+func (s *storeImpl) ListAudit(ctx context.Context, query AuditQuery) (*ListAuditResult, error) {
+	return s.listAudit(ctx, nil, query)
+}
+
+// listAudit returns a page of the audit log of the book, of all books when bookID is nil
+func (s *storeImpl) listAudit(ctx context.Context, bookID *uuid.UUID, query AuditQuery) (*ListAuditResult, error) {
+	query, after, err := query.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if bookID != nil {
+		add("book_id = $%d", *bookID)
+	}
+	if query.From != nil {
+		add("created_at >= $%d::timestamp", query.From.UTC().Format(cursorTimeLayout))
+	}
+	if query.To != nil {
+		add("created_at < $%d::timestamp", query.To.UTC().Format(cursorTimeLayout))
+	}
+	if after != nil {
+		args = append(args, after.Value, after.ID)
+		conds = append(conds, fmt.Sprintf("(created_at, id) < ($%d::timestamp, $%d)", len(args)-1, len(args)))
+	}
+
+	// fetch one extra entry to know whether there is a next page
+	stmt := fmt.Sprintf("%s%sORDER BY created_at DESC, id DESC\nLIMIT %d", listAudit, whereClause(conds), query.Limit+1)
+	entries, err := queryAudit(ctx, s.db, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListAuditResult{Entries: entries}
+	if len(entries) > query.Limit {
+		result.Entries = entries[:query.Limit]
+		result.NextCursor = auditCursorFor(result.Entries[query.Limit-1])
+	}
+	return result, nil
+}
+
+func queryAudit(ctx context.Context, db *sql.DB, stmt string, args ...interface{}) ([]*models.AuditEntry, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		var (
+			entry         models.AuditEntry
+			before, after []byte
+		)
+		if err = rows.Scan(
+			&entry.ID,
+			&entry.BookID,
+			&entry.Action,
+			&entry.Actor,
+			&entry.RequestID,
+			&before,
+			&after,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// lockAuditedBook locks the book row, including books in the trash, until the end of the transaction
+// and returns the book to be recorded as the state before the change
+func lockAuditedBook(ctx context.Context, tx *sql.Tx, bookID uuid.UUID) (*models.Book, error) {
+	return selectAuditedBook(ctx, tx, lockAuditBook, bookID)
+}
+
+// checkBookVersion makes sure the locked book is not in the trash and is of the given version,
+// any version when it is 0
+func checkBookVersion(book *models.Book, version int) error {
+	if book.DeletedAt != nil {
+		return ErrBookNotFound
+	}
+	if version != 0 && book.Version != version {
+		return ErrBookVersionMismatch
+	}
+	return nil
+}
+
+func selectAuditedBook(ctx context.Context, tx *sql.Tx, stmt string, bookID uuid.UUID) (*models.Book, error) {
+	var deletedAt *time.Time
+	book, err := scanBook(tx.QueryRowContext(ctx, stmt, bookID), &deletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBookNotFound
+		}
+		return nil, err
+	}
+
+	book.DeletedAt = deletedAt
+	return book, nil
+}
+
+// recordChange records the change of the book in the audit log and queues its event in the outbox,
+// in the transaction of the change. The state after the change is read back from the transaction
+func recordChange(ctx context.Context, tx *sql.Tx, action models.AuditAction, bookID uuid.UUID, before *models.Book) error {
+	after, err := selectAuditedBook(ctx, tx, auditBook, bookID)
+	if err != nil {
+		return err
+	}
+	snapshot := snapshotBook(after)
+
+	info := auditInfoFrom(ctx)
+	if _, err = tx.ExecContext(ctx, createAuditEntry,
+		bookID, action, info.Actor, info.RequestID, jsonParam(snapshotBook(before)), jsonParam(snapshot),
+	); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, createEvent, auditEvents[action], bookID, jsonParam(snapshot))
+	return err
+}
+
+// jsonParam passes JSON to a JSONB column, []byte would be sent as bytea
+func jsonParam(payload json.RawMessage) interface{} {
+	if payload == nil {
+		return nil
+	}
+	return string(payload)
+}
@@ -4,101 +4,190 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/alexkaplun/books-test/storage/models"
 	"github.com/google/uuid"
 )
 
+// CreateBook ignores the book status, new books are always checked in
 func (s *storeImpl) CreateBook(ctx context.Context, book *models.Book) (*uuid.UUID, error) {
 	var id uuid.UUID
-	if err := s.db.QueryRowContext(ctx, createBook,
-		book.Title, book.Author, book.Publisher, book.PublishDate, book.Rating, book.Status,
-	).Scan(&id); err != nil {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, createBook,
+			book.Title, book.Author, book.Publisher, book.PublishDate, book.Rating, models.BookStatusCheckedIn,
+		).Scan(&id); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, models.AuditActionCreate, id, nil)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &id, nil
 }
 
-// TODO: we may want to distinguish between errors if the id not found
-func (s *storeImpl) DeleteBook(ctx context.Context, bookID uuid.UUID) error {
-	if _, err := s.db.ExecContext(ctx, deleteBook, bookID); err != nil {
+func (s *storeImpl) DeleteBook(ctx context.Context, bookID uuid.UUID, version int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockAuditedBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if err = checkBookVersion(before, version); err != nil {
+			return err
+		}
+		if err = checkBookNotInUse(ctx, tx, before); err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, deleteBook, bookID, version); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, models.AuditActionDelete, bookID, before)
+	})
+}
+
+// checkBookNotInUse makes sure the book has no open loan or active holds, as they can't be returned
+// or cancelled once the book is in the trash. Ready holds past their pickup window don't count
+func checkBookNotInUse(ctx context.Context, tx *sql.Tx, book *models.Book) error {
+	if book.Status == models.BookStatusCheckedOut {
+		return fmt.Errorf("%w: return it before deleting the book", ErrBookCheckedOut)
+	}
+
+	var held bool
+	if err := tx.QueryRowContext(ctx, bookHoldExists, book.ID).Scan(&held); err != nil {
 		return err
 	}
+	if held {
+		return fmt.Errorf("%w: cancel the holds before deleting the book", ErrBookOnHold)
+	}
 	return nil
 }
 
+// UpdateBook keeps the book status, it's changed only by checking the book out and in
 func (s *storeImpl) UpdateBook(ctx context.Context, book *models.Book) error {
-	res, err := s.db.ExecContext(ctx, updateBook,
-		book.ID,
-		book.Title,
-		book.Author,
-		book.Publisher,
-		book.PublishDate,
-		book.Rating,
-		book.Status,
-	)
-	if err != nil {
-		return err
-	}
+	var version int
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockAuditedBook(ctx, tx, book.ID)
+		if err != nil {
+			return err
+		}
+		if err = checkBookVersion(before, book.Version); err != nil {
+			return err
+		}
+
+		if err = tx.QueryRowContext(ctx, updateBook,
+			book.ID,
+			book.Title,
+			book.Author,
+			book.Publisher,
+			book.PublishDate,
+			book.Rating,
+			book.Version,
+		).Scan(&version); err != nil {
+			return err
+		}
 
-	rowsAffected, err := res.RowsAffected()
+		return recordChange(ctx, tx, models.AuditActionUpdate, book.ID, before)
+	})
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected != 1 {
-		return ErrBookNotFound
-	}
-
+	book.Version = version
 	return nil
 }
 
 func (s *storeImpl) GetBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error) {
-	var book models.Book
-	if err := s.db.QueryRowContext(ctx, getBook, bookID).Scan(
-		&book.ID,
-		&book.Title,
-		&book.Author,
-		&book.Publisher,
-		&book.PublishDate,
-		&book.Rating,
-		&book.Status,
-		&book.CreatedAt,
-		&book.UpdatedAt,
-	); err != nil {
+	book, err := scanBook(s.db.QueryRowContext(ctx, getBook, bookID))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrBookNotFound
 		}
 		return nil, err
 	}
 
-	return &book, nil
+	return book, nil
+}
+
+func (s *storeImpl) ListBooks(ctx context.Context, query ListBooksQuery) (*ListBooksResult, error) {
+	plan, err := query.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	conds, args := plan.filters()
+	result := &ListBooksResult{}
+
+	if plan.WithTotal {
+		var total int
+		if err = s.db.QueryRowContext(ctx, countBooks+whereClause(conds), args...).Scan(&total); err != nil {
+			return nil, err
+		}
+		result.TotalCount = &total
+	}
+
+	if plan.after != nil {
+		cond, keysetArgs := plan.keyset(len(args))
+		conds = append(conds, cond)
+		args = append(args, keysetArgs...)
+	}
+
+	// fetch one extra book to know whether there is a next page
+	stmt := fmt.Sprintf("%s%s%s\nLIMIT %d", listBooks, whereClause(conds), plan.orderBy(), plan.Limit+1)
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Books = append(result.Books, book)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Books) > plan.Limit {
+		result.Books = result.Books[:plan.Limit]
+		result.NextCursor = plan.cursorFor(result.Books[plan.Limit-1])
+	}
+
+	return result, nil
 }
 
-func (s *storeImpl) ListBooks(ctx context.Context) ([]*models.Book, error) {
-	var books []*models.Book
-	rows, err := s.db.QueryContext(ctx, listBooks)
+func (s *storeImpl) SearchBooks(ctx context.Context, query SearchBooksQuery) ([]*BookSearchHit, error) {
+	query, tokens, err := query.normalize()
 	if err != nil {
 		return nil, err
 	}
+
+	rows, err := s.db.QueryContext(ctx, searchBooks, tsQuery(tokens), query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []*BookSearchHit
 	for rows.Next() {
-		var book models.Book
-		if err := rows.Scan(
-			&book.ID,
-			&book.Title,
-			&book.Author,
-			&book.Publisher,
-			&book.PublishDate,
-			&book.Rating,
-			&book.Status,
-			&book.CreatedAt,
-			&book.UpdatedAt,
-		); err != nil {
+		var hit BookSearchHit
+		if hit.Book, err = scanBook(rows, &hit.Rank); err != nil {
 			return nil, err
 		}
-		books = append(books, &book)
+
+		// highlighted like naiveSearch does, ts_headline doesn't escape the fields as HTML
+		hit.Highlights.Title, _ = highlight(hit.Book.Title, tokens)
+		hit.Highlights.Author, _ = highlight(hit.Book.Author, tokens)
+		hit.Highlights.Publisher, _ = highlight(hit.Book.Publisher, tokens)
+		hits = append(hits, &hit)
 	}
 
-	return books, nil
+	return hits, rows.Err()
 }
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// classifiedStore classifies the errors of every call of the wrapped Postgres storage, see classify
+type classifiedStore struct {
+	store *storeImpl
+}
+
+func (s *classifiedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *classifiedStore) DBStats() sql.DBStats {
+	return s.store.DBStats()
+}
+
+func (s *classifiedStore) CreateBook(ctx context.Context, book *models.Book) (*uuid.UUID, error) {
+	result, err := s.store.CreateBook(ctx, book)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ImportBooks(ctx context.Context, books []*models.Book) error {
+	return classify(s.store.ImportBooks(ctx, books))
+}
+
+func (s *classifiedStore) DeleteBook(ctx context.Context, bookID uuid.UUID, version int) error {
+	return classify(s.store.DeleteBook(ctx, bookID, version))
+}
+
+func (s *classifiedStore) UpdateBook(ctx context.Context, book *models.Book) error {
+	return classify(s.store.UpdateBook(ctx, book))
+}
+
+func (s *classifiedStore) PatchBook(ctx context.Context, book *models.Book, fields []BookField) (*models.Book, error) {
+	result, err := s.store.PatchBook(ctx, book, fields)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) GetBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error) {
+	result, err := s.store.GetBook(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListBooks(ctx context.Context, query ListBooksQuery) (*ListBooksResult, error) {
+	result, err := s.store.ListBooks(ctx, query)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ExportBooks(ctx context.Context, query ListBooksQuery, fn func(book *models.Book) error) error {
+	return classify(s.store.ExportBooks(ctx, query, fn))
+}
+
+func (s *classifiedStore) SearchBooks(ctx context.Context, query SearchBooksQuery) ([]*BookSearchHit, error) {
+	result, err := s.store.SearchBooks(ctx, query)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListTrash(ctx context.Context) ([]*models.Book, error) {
+	result, err := s.store.ListTrash(ctx)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) RestoreBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error) {
+	result, err := s.store.RestoreBook(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) PurgeBooks(ctx context.Context, retention time.Duration) (int, error) {
+	result, err := s.store.PurgeBooks(ctx, retention)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListBookHistory(ctx context.Context, bookID uuid.UUID, query AuditQuery) (*ListAuditResult, error) {
+	result, err := s.store.ListBookHistory(ctx, bookID, query)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListAudit(ctx context.Context, query AuditQuery) (*ListAuditResult, error) {
+	result, err := s.store.ListAudit(ctx, query)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) CheckoutBook(ctx context.Context, loan *models.Loan) (*models.Loan, error) {
+	result, err := s.store.CheckoutBook(ctx, loan)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ReturnBook(ctx context.Context, bookID uuid.UUID) (*models.Loan, error) {
+	result, err := s.store.ReturnBook(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListLoans(ctx context.Context, bookID uuid.UUID) ([]*models.Loan, error) {
+	result, err := s.store.ListLoans(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) PlaceHold(ctx context.Context, hold *models.Hold) (*models.Hold, error) {
+	result, err := s.store.PlaceHold(ctx, hold)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) CancelHold(ctx context.Context, bookID, holdID uuid.UUID) (*models.Hold, error) {
+	result, err := s.store.CancelHold(ctx, bookID, holdID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListHolds(ctx context.Context, bookID uuid.UUID) ([]*models.Hold, error) {
+	result, err := s.store.ListHolds(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ExpireHolds(ctx context.Context) (int, error) {
+	result, err := s.store.ExpireHolds(ctx)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) AccrueFines(ctx context.Context, policy models.FinePolicy) (int, error) {
+	result, err := s.store.AccrueFines(ctx, policy)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListMemberFines(ctx context.Context, memberID uuid.UUID) ([]*models.Fine, error) {
+	result, err := s.store.ListMemberFines(ctx, memberID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) PayFine(ctx context.Context, fineID uuid.UUID) (*models.Fine, error) {
+	result, err := s.store.PayFine(ctx, fineID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) TryLock(ctx context.Context, key int64) (func(), bool, error) {
+	unlock, acquired, err := s.store.TryLock(ctx, key)
+	return unlock, acquired, classify(err)
+}
+
+func (s *classifiedStore) CreateMember(ctx context.Context, member *models.Member) (*uuid.UUID, error) {
+	result, err := s.store.CreateMember(ctx, member)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) UpdateMember(ctx context.Context, member *models.Member) error {
+	return classify(s.store.UpdateMember(ctx, member))
+}
+
+func (s *classifiedStore) DeleteMember(ctx context.Context, memberID uuid.UUID) error {
+	return classify(s.store.DeleteMember(ctx, memberID))
+}
+
+func (s *classifiedStore) GetMember(ctx context.Context, memberID uuid.UUID) (*models.Member, error) {
+	result, err := s.store.GetMember(ctx, memberID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListMembers(ctx context.Context) ([]*models.Member, error) {
+	result, err := s.store.ListMembers(ctx)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) CreateCopy(ctx context.Context, item *models.Copy) (*uuid.UUID, error) {
+	result, err := s.store.CreateCopy(ctx, item)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) UpdateCopy(ctx context.Context, item *models.Copy) error {
+	return classify(s.store.UpdateCopy(ctx, item))
+}
+
+func (s *classifiedStore) DeleteCopy(ctx context.Context, bookID, copyID uuid.UUID) error {
+	return classify(s.store.DeleteCopy(ctx, bookID, copyID))
+}
+
+func (s *classifiedStore) GetCopy(ctx context.Context, bookID, copyID uuid.UUID) (*models.Copy, error) {
+	result, err := s.store.GetCopy(ctx, bookID, copyID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListCopies(ctx context.Context, bookID uuid.UUID) ([]*models.Copy, error) {
+	result, err := s.store.ListCopies(ctx, bookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) PendingEvents(ctx context.Context, limit int) ([]*models.Event, error) {
+	result, err := s.store.PendingEvents(ctx, limit)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) AckEvent(ctx context.Context, eventID int64) error {
+	return classify(s.store.AckEvent(ctx, eventID))
+}
+
+func (s *classifiedStore) RetryEvent(ctx context.Context, eventID int64, delay time.Duration, reason string) error {
+	return classify(s.store.RetryEvent(ctx, eventID, delay, reason))
+}
+
+func (s *classifiedStore) FailEvent(ctx context.Context, eventID int64, reason string) error {
+	return classify(s.store.FailEvent(ctx, eventID, reason))
+}
+
+func (s *classifiedStore) ListenEvents(ctx context.Context, fn func(event *models.Event), lost func()) error {
+	return classify(s.store.ListenEvents(ctx, fn, lost))
+}
+
+func (s *classifiedStore) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*uuid.UUID, error) {
+	result, err := s.store.CreateWebhook(ctx, webhook)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) UpdateWebhook(ctx context.Context, webhook *models.Webhook, active *bool) error {
+	return classify(s.store.UpdateWebhook(ctx, webhook, active))
+}
+
+func (s *classifiedStore) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error {
+	return classify(s.store.DeleteWebhook(ctx, webhookID))
+}
+
+func (s *classifiedStore) GetWebhook(ctx context.Context, webhookID uuid.UUID) (*models.Webhook, error) {
+	result, err := s.store.GetWebhook(ctx, webhookID)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	result, err := s.store.ListWebhooks(ctx)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) EnqueueWebhookDeliveries(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	result, err := s.store.EnqueueWebhookDeliveries(ctx, delivery)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	result, err := s.store.CreateWebhookDelivery(ctx, delivery)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) PendingWebhookDeliveries(ctx context.Context, limit int, skip []uuid.UUID) ([]*models.WebhookDelivery, error) {
+	result, err := s.store.PendingWebhookDeliveries(ctx, limit, skip)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) RecordWebhookAttempt(ctx context.Context, deliveryID uuid.UUID, attempt models.WebhookAttempt) (*models.WebhookDelivery, error) {
+	result, err := s.store.RecordWebhookAttempt(ctx, deliveryID, attempt)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, query WebhookDeliveryQuery) ([]*models.WebhookDelivery, error) {
+	result, err := s.store.ListWebhookDeliveries(ctx, webhookID, query)
+	return result, classify(err)
+}
+
+func (s *classifiedStore) Ping(ctx context.Context) error {
+	return classify(s.store.Ping(ctx))
+}
+
+func (s *classifiedStore) CheckMigrations(ctx context.Context) error {
+	return classify(s.store.CheckMigrations(ctx))
+}
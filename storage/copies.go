@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) CreateCopy(ctx context.Context, item *models.Copy) (*uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// the book row lock keeps the book from being deleted concurrently
+		if _, err := lockBook(ctx, tx, item.BookID); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRowContext(ctx, createCopy,
+			item.BookID, item.Barcode, item.Location, item.Condition, item.Status,
+		).Scan(&id); err != nil {
+			if isUniqueViolation(err) {
+				return ErrCopyExists
+			}
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, bumpBookVersion, item.BookID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func (s *storeImpl) UpdateCopy(ctx context.Context, item *models.Copy) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, updateCopy,
+			item.ID,
+			item.BookID,
+			item.Barcode,
+			item.Location,
+			item.Condition,
+			item.Status,
+		)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrCopyExists
+			}
+			return err
+		}
+
+		return bumpVersionOfCopyBook(ctx, tx, res, item.BookID)
+	})
+}
+
+func (s *storeImpl) DeleteCopy(ctx context.Context, bookID, copyID uuid.UUID) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, deleteCopy, copyID, bookID)
+		if err != nil {
+			return err
+		}
+
+		return bumpVersionOfCopyBook(ctx, tx, res, bookID)
+	})
+}
+
+func (s *storeImpl) GetCopy(ctx context.Context, bookID, copyID uuid.UUID) (*models.Copy, error) {
+	item, err := scanCopy(s.db.QueryRowContext(ctx, getCopy, copyID, bookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCopyNotFound
+		}
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (s *storeImpl) ListCopies(ctx context.Context, bookID uuid.UUID) ([]*models.Copy, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, bookExists, bookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBookNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, listCopies, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var copies []*models.Copy
+	for rows.Next() {
+		item, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, item)
+	}
+
+	return copies, rows.Err()
+}
+
+// bumpVersionOfCopyBook increments the version of the book once the write of its copy affected a row
+func bumpVersionOfCopyBook(ctx context.Context, tx *sql.Tx, res sql.Result, bookID uuid.UUID) error {
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrCopyNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, bumpBookVersion, bookID)
+	return err
+}
@@ -1,7 +1,190 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// Kind classifies storage errors, so callers can handle them without knowing every error
+type Kind int
+
+const (
+	// KindInternal is any error not classified otherwise
+	KindInternal Kind = iota
+	// KindNotFound is returned when the requested entity doesn't exist
+	KindNotFound
+	// KindConflict is returned when the change conflicts with the current state, e.g. a duplicate
+	KindConflict
+	// KindInvalid is returned when the data is rejected by the storage, e.g. by a check constraint
+	KindInvalid
+	// KindPrecondition is returned when a conditional change finds the entity changed
+	KindPrecondition
+	// KindUnavailable is returned when the database can't be reached, the call may be retried
+	KindUnavailable
+	// KindTimeout is returned when the call didn't complete in time
+	KindTimeout
+	// KindCanceled is returned when the context of the call was canceled, e.g. the client went away
+	KindCanceled
+)
+
+var kindNames = map[Kind]string{
+	KindInternal:     "internal",
+	KindNotFound:     "not_found",
+	KindConflict:     "conflict",
+	KindInvalid:      "invalid",
+	KindPrecondition: "precondition",
+	KindUnavailable:  "unavailable",
+	KindTimeout:      "timeout",
+	KindCanceled:     "canceled",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Error is a classified storage error. Errors of database calls keep the driver error as the cause
+type Error struct {
+	Kind  Kind
+	msg   string
+	cause error
+}
+
+func newError(kind Kind, msg string) *Error {
+	return &Error{Kind: kind, msg: msg}
+}
+
+func (e *Error) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.cause.Error()
+}
+
+// Message describes the error without the cause, so it's safe to show to clients
+func (e *Error) Message() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// KindOf returns the kind of the storage error, KindInternal for unclassified errors
+func KindOf(err error) Kind {
+	var storageErr *Error
+	if errors.As(err, &storageErr) {
+		return storageErr.Kind
+	}
+	return KindInternal
+}
 
 var (
-	ErrBookNotFound = errors.New("book not found")
+	ErrBookVersionMismatch = newError(KindPrecondition, "book has been changed")
+	ErrBookNotFound        = newError(KindNotFound, "book not found")
+	ErrBookCheckedOut      = newError(KindConflict, "book is already checked out")
+	ErrBookNotCheckedOut   = newError(KindConflict, "book is not checked out")
+
+	ErrBookAvailable = newError(KindConflict, "book is available, check it out instead of placing a hold")
+	ErrBookOnHold    = newError(KindConflict, "book is on hold for another member")
+
+	ErrHoldNotFound  = newError(KindNotFound, "hold not found")
+	ErrHoldExists    = newError(KindConflict, "member already holds the book")
+	ErrHoldOwnLoan   = newError(KindConflict, "member already has the book checked out")
+	ErrHoldNotActive = newError(KindConflict, "hold is no longer active")
+
+	ErrCopyNotFound = newError(KindNotFound, "copy not found")
+	ErrCopyExists   = newError(KindConflict, "copy with the barcode already exists")
+
+	ErrFineNotFound = newError(KindNotFound, "fine not found")
+	ErrFinePaid     = newError(KindConflict, "fine is already paid")
+	ErrFineAccruing = newError(KindConflict, "fine is still accruing, the book must be returned first")
+
+	ErrWebhookNotFound = newError(KindNotFound, "webhook not found")
+
+	ErrMemberNotFound        = newError(KindNotFound, "member not found")
+	ErrMemberExists          = newError(KindConflict, "member with the same email or card number already exists")
+	ErrMemberHasLoans        = newError(KindConflict, "member has books checked out")
+	ErrMemberHasHolds        = newError(KindConflict, "member has active holds")
+	ErrMemberSuspended       = newError(KindConflict, "member is suspended")
+	ErrBorrowingLimitReached = newError(KindConflict, "member reached the borrowing limit")
 )
+
+// classify wraps database errors into a storage Error of the matching kind.
+// Storage errors and errors it doesn't recognize are returned as is
+func classify(err error) error {
+	if err == nil || KindOf(err) != KindInternal {
+		return err
+	}
+
+	kind, msg := classifyCause(err)
+	if kind == KindInternal {
+		return err
+	}
+	return &Error{Kind: kind, msg: msg, cause: err}
+}
+
+func classifyCause(err error) (Kind, string) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPq(pqErr)
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout, "storage call timed out"
+	case errors.Is(err, context.Canceled):
+		return KindCanceled, "storage call was canceled"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return KindTimeout, "storage call timed out"
+		}
+		return KindUnavailable, "storage is unavailable"
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return KindUnavailable, "storage is unavailable"
+	}
+
+	return KindInternal, ""
+}
+
+// classifyPq classifies Postgres errors by their SQLSTATE code
+func classifyPq(err *pq.Error) (Kind, string) {
+	switch err.Code {
+	case "23502", "23514":
+		// not_null_violation, check_violation
+		return KindInvalid, "data violates a constraint"
+	case "40001", "40P01":
+		// serialization_failure, deadlock_detected
+		return KindConflict, "concurrent change, try again"
+	case "57014":
+		// query_canceled, also raised by statement_timeout
+		return KindTimeout, "storage call timed out"
+	case "57P01", "57P02", "57P03":
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		return KindUnavailable, "storage is unavailable"
+	}
+
+	switch err.Code.Class() {
+	case "22":
+		// data_exception, e.g. a value too long for the column
+		return KindInvalid, "invalid data"
+	case "23":
+		// integrity_constraint_violation, e.g. unique and foreign key violations
+		return KindConflict, "data conflicts with existing data"
+	case "08", "53":
+		// connection_exception, insufficient_resources
+		return KindUnavailable, "storage is unavailable"
+	}
+
+	return KindInternal, ""
+}
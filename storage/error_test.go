@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		kind Kind
+	}{
+		"unique violation":   {err: &pq.Error{Code: "23505"}, kind: KindConflict},
+		"foreign key":        {err: &pq.Error{Code: "23503"}, kind: KindConflict},
+		"check violation":    {err: &pq.Error{Code: "23514"}, kind: KindInvalid},
+		"value too long":     {err: &pq.Error{Code: "22001"}, kind: KindInvalid},
+		"serialization":      {err: &pq.Error{Code: "40001"}, kind: KindConflict},
+		"statement timeout":  {err: &pq.Error{Code: "57014"}, kind: KindTimeout},
+		"admin shutdown":     {err: &pq.Error{Code: "57P01"}, kind: KindUnavailable},
+		"connection failure": {err: &pq.Error{Code: "08006"}, kind: KindUnavailable},
+		"too many clients":   {err: &pq.Error{Code: "53300"}, kind: KindUnavailable},
+		"syntax error":       {err: &pq.Error{Code: "42601"}, kind: KindInternal},
+		"wrapped":            {err: fmt.Errorf("failed to insert: %w", &pq.Error{Code: "23505"}), kind: KindConflict},
+		"deadline":           {err: context.DeadlineExceeded, kind: KindTimeout},
+		"canceled":           {err: context.Canceled, kind: KindCanceled},
+		"bad connection":     {err: driver.ErrBadConn, kind: KindUnavailable},
+		"storage error":      {err: ErrBookNotFound, kind: KindNotFound},
+		"unclassified":       {err: errors.New("boom"), kind: KindInternal},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := classify(tt.err)
+			assert.Equal(t, tt.kind, KindOf(err))
+			// the cause stays reachable for callers checking the driver error
+			assert.True(t, errors.Is(err, tt.err))
+		})
+	}
+
+	assert.Nil(t, classify(nil))
+	assert.Equal(t, ErrBookNotFound, classify(ErrBookNotFound))
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+const (
+	// exportCursor is the server-side cursor of ExportBooks, it's closed with the transaction
+	exportCursor = "export_books"
+	// exportFetchSize is the number of books fetched from the cursor at once
+	exportFetchSize = 500
+)
+
+func (s *storeImpl) ExportBooks(ctx context.Context, query ListBooksQuery, fn func(book *models.Book) error) error {
+	plan, err := query.plan()
+	if err != nil {
+		return err
+	}
+
+	conds, args := plan.filters()
+	if plan.after != nil {
+		cond, keysetArgs := plan.keyset(len(args))
+		conds = append(conds, cond)
+		args = append(args, keysetArgs...)
+	}
+	stmt := fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s%s%s", exportCursor, listBooks, whereClause(conds), plan.orderBy())
+
+	// the export reads a single snapshot, however long it takes
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// nothing is written, the cursor is dropped either way
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM %s", exportFetchSize, exportCursor)
+	for {
+		n, err := fetchBooks(ctx, tx, fetch, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+// fetchBooks calls fn with the books fetched from the cursor and returns their number
+func fetchBooks(ctx context.Context, tx *sql.Tx, fetch string, fn func(book *models.Book) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return n, err
+		}
+		if err = fn(book); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
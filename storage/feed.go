@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// eventsChannel is notified with every event queued in the outbox
+const eventsChannel = "book_events"
+
+// notifiedEvent is the outbox row sent with the notifications of eventsChannel
+type notifiedEvent struct {
+	ID        int64            `json:"id"`
+	EventType models.EventType `json:"event_type"`
+	BookID    uuid.UUID        `json:"book_id"`
+	Payload   json.RawMessage  `json:"payload"`
+	CreatedAt string           `json:"created_at"`
+}
+
+func (s *storeImpl) ListenEvents(ctx context.Context, fn func(event *models.Event), lost func()) error {
+	listener := pq.NewListener(s.connString, time.Second, time.Minute, nil)
+	if err := listener.Listen(eventsChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		relayNotifications(ctx, listener.Notify, func() { go listener.Ping() }, fn, lost)
+	}()
+
+	return nil
+}
+
+// relayNotifications calls fn with the events of the notifications until the context is done.
+// The notifications sent while reconnecting are lost, lost is called once the listener is back
+func relayNotifications(ctx context.Context, notifications <-chan *pq.Notification, ping func(),
+	fn func(event *models.Event), lost func()) {
+	// pings detect broken connections, so the listener reconnects
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ping()
+		case notification := <-notifications:
+			// nil is sent after reconnecting
+			if notification == nil {
+				lost()
+				continue
+			}
+			if event, err := parseNotifiedEvent(notification.Extra); err == nil {
+				fn(event)
+			}
+		}
+	}
+}
+
+func parseNotifiedEvent(payload string) (*models.Event, error) {
+	var notified notifiedEvent
+	if err := json.Unmarshal([]byte(payload), &notified); err != nil {
+		return nil, err
+	}
+
+	// row_to_json formats timestamps without the zone
+	createdAt, err := time.Parse(cursorTimeLayout, notified.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Event{
+		ID:        notified.ID,
+		Type:      notified.EventType,
+		BookID:    notified.BookID,
+		Payload:   notified.Payload,
+		CreatedAt: &createdAt,
+	}, nil
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNotifiedEvent(t *testing.T) {
+	bookID := uuid.New()
+	event, err := parseNotifiedEvent(`{"id":42,"event_type":"book.updated","book_id":"` + bookID.String() +
+		`","payload":{"title": "1"},"created_at":"2021-07-07T10:11:12.345678"}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(42), event.ID)
+	assert.Equal(t, models.EventBookUpdated, event.Type)
+	assert.Equal(t, bookID, event.BookID)
+	assert.JSONEq(t, `{"title": "1"}`, string(event.Payload))
+	assert.Equal(t, time.Date(2021, 7, 7, 10, 11, 12, 345678000, time.UTC), *event.CreatedAt)
+
+	_, err = parseNotifiedEvent(`{"id":42,"created_at":"yesterday"}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) AccrueFines(ctx context.Context, policy models.FinePolicy) (int, error) {
+	res, err := s.db.ExecContext(ctx, accrueFines, policy.DailyRate, policy.GraceDays, policy.MaxAmount)
+	if err != nil {
+		return 0, err
+	}
+
+	accrued, err := res.RowsAffected()
+	return int(accrued), err
+}
+
+func (s *storeImpl) ListMemberFines(ctx context.Context, memberID uuid.UUID) ([]*models.Fine, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, memberExists, memberID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrMemberNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, listMemberFines, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fines []*models.Fine
+	for rows.Next() {
+		fine, err := scanFine(rows)
+		if err != nil {
+			return nil, err
+		}
+		fines = append(fines, fine)
+	}
+
+	return fines, rows.Err()
+}
+
+func (s *storeImpl) PayFine(ctx context.Context, fineID uuid.UUID) (*models.Fine, error) {
+	var fine *models.Fine
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var returned bool
+		current, err := scanFine(tx.QueryRowContext(ctx, lockFine, fineID), &returned)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrFineNotFound
+			}
+			return err
+		}
+
+		switch {
+		case current.Status == models.FineStatusPaid:
+			return ErrFinePaid
+		case !returned:
+			return ErrFineAccruing
+		}
+
+		fine, err = scanFine(tx.QueryRowContext(ctx, payFine, fineID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fine, nil
+}
+
+func (s *storeImpl) TryLock(ctx context.Context, key int64) (func(), bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	if err = conn.QueryRowContext(ctx, tryAdvisoryLock, key).Scan(&acquired); err != nil || !acquired {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock := func() {
+		conn.ExecContext(context.Background(), advisoryUnlock, key)
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
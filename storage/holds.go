@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) PlaceHold(ctx context.Context, hold *models.Hold) (*models.Hold, error) {
+	created := *hold
+	created.Status = models.HoldStatusWaiting
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		status, err := lockBook(ctx, tx, hold.BookID)
+		if err != nil {
+			return err
+		}
+		if status, err = settleHolds(ctx, tx, hold.BookID, status); err != nil {
+			return err
+		}
+		if status == models.BookStatusCheckedIn {
+			return ErrBookAvailable
+		}
+
+		member, err := lockMemberRow(ctx, tx, hold.MemberID)
+		if err != nil {
+			return err
+		}
+		if member.Status != models.MemberStatusActive {
+			return ErrMemberSuspended
+		}
+
+		var borrower *uuid.UUID
+		if err = tx.QueryRowContext(ctx, activeLoanMember, hold.BookID).Scan(&borrower); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if borrower != nil && *borrower == member.ID {
+			return ErrHoldOwnLoan
+		}
+
+		var exists bool
+		if err = tx.QueryRowContext(ctx, memberHoldExists, hold.BookID, member.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrHoldExists
+		}
+
+		return tx.QueryRowContext(ctx, createHold,
+			hold.BookID, hold.MemberID, created.Status, int64(hold.PickupWindow.Seconds()),
+		).Scan(&created.ID, &created.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (s *storeImpl) CancelHold(ctx context.Context, bookID, holdID uuid.UUID) (*models.Hold, error) {
+	var hold *models.Hold
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		status, err := lockBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+
+		if hold, err = scanHold(tx.QueryRowContext(ctx, getHold, holdID, bookID)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrHoldNotFound
+			}
+			return err
+		}
+		if !hold.Status.IsActive() {
+			return ErrHoldNotActive
+		}
+
+		if _, err = tx.ExecContext(ctx, closeHold, holdID, models.HoldStatusCancelled); err != nil {
+			return err
+		}
+
+		// cancelling a ready hold passes the book to the next member in the queue
+		_, err = settleHolds(ctx, tx, bookID, status)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Status = models.HoldStatusCancelled
+	return hold, nil
+}
+
+func (s *storeImpl) ListHolds(ctx context.Context, bookID uuid.UUID) ([]*models.Hold, error) {
+	var holds []*models.Hold
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		status, err := lockBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if _, err = settleHolds(ctx, tx, bookID, status); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, listActiveHolds, bookID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			hold, err := scanHold(rows)
+			if err != nil {
+				return err
+			}
+			holds = append(holds, hold)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// settleHolds expires ready holds past their pickup window and, unless the book is checked out,
+// makes the first waiting hold ready for pickup. It updates and returns the resulting book status.
+// The book must be locked by the transaction
+func settleHolds(ctx context.Context, tx *sql.Tx, bookID uuid.UUID, status models.BookStatus) (models.BookStatus, error) {
+	if _, err := tx.ExecContext(ctx, expireReadyHolds, bookID); err != nil {
+		return "", err
+	}
+	if status == models.BookStatusCheckedOut {
+		return status, nil
+	}
+
+	ready, err := readyHoldOf(ctx, tx, bookID)
+	if err != nil {
+		return "", err
+	}
+
+	newStatus := models.BookStatusReadyForPickup
+	if ready == nil {
+		res, err := tx.ExecContext(ctx, promoteNextHold, bookID)
+		if err != nil {
+			return "", err
+		}
+
+		promoted, err := res.RowsAffected()
+		if err != nil {
+			return "", err
+		}
+		if promoted == 0 {
+			newStatus = models.BookStatusCheckedIn
+		}
+	}
+
+	if newStatus != status {
+		if _, err = tx.ExecContext(ctx, setBookStatus, bookID, newStatus); err != nil {
+			return "", err
+		}
+	}
+
+	return newStatus, nil
+}
+
+func (s *storeImpl) ExpireHolds(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, listBooksWithExpiredHolds)
+	if err != nil {
+		return 0, err
+	}
+
+	var bookIDs []uuid.UUID
+	for rows.Next() {
+		var bookID uuid.UUID
+		if err = rows.Scan(&bookID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// every book is settled in its own transaction, so a failure doesn't hold up the others
+	for _, bookID := range bookIDs {
+		err = s.inTx(ctx, func(tx *sql.Tx) error {
+			status, err := lockBook(ctx, tx, bookID)
+			if err != nil {
+				return err
+			}
+			_, err = settleHolds(ctx, tx, bookID, status)
+			return err
+		})
+		if err != nil && !errors.Is(err, ErrBookNotFound) {
+			return 0, err
+		}
+	}
+
+	return len(bookIDs), nil
+}
+
+// readyHoldOf returns the hold ready for pickup of the book, if any
+func readyHoldOf(ctx context.Context, tx *sql.Tx, bookID uuid.UUID) (*models.Hold, error) {
+	hold, err := scanHold(tx.QueryRowContext(ctx, readyHold, bookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hold, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func (s *storeImpl) ImportBooks(ctx context.Context, books []*models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	// ids are assigned upfront, as COPY doesn't return them
+	ids := make([]string, len(books))
+	for i := range books {
+		ids[i] = uuid.New().String()
+	}
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := copyRows(ctx, tx, pq.CopyIn("books", "id", "title", "author", "publisher", "publish_date", "rating", "status"),
+			len(books), func(i int) []interface{} {
+				book := books[i]
+				return []interface{}{ids[i], book.Title, book.Author, book.Publisher, book.PublishDate, book.Rating, models.BookStatusCheckedIn}
+			})
+		if err != nil {
+			return err
+		}
+
+		return recordImport(ctx, tx, ids)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, book := range books {
+		book.ID = uuid.MustParse(ids[i])
+	}
+	return nil
+}
+
+// recordImport records the creation of the imported books in the audit log and queues their events,
+// in the order of the ids
+func recordImport(ctx context.Context, tx *sql.Tx, ids []string) error {
+	rows, err := tx.QueryContext(ctx, importedBooks, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	imported := make(map[uuid.UUID]*models.Book, len(ids))
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		imported[book.ID] = book
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	info := auditInfoFrom(ctx)
+	auditIDs := make([]string, len(ids))
+	err = copyRows(ctx, tx, pq.CopyIn("book_audit", "id", "book_id", "action", "actor", "request_id", "after"),
+		len(ids), func(i int) []interface{} {
+			auditIDs[i] = uuid.New().String()
+			bookID := uuid.MustParse(ids[i])
+			return []interface{}{
+				auditIDs[i], ids[i], models.AuditActionCreate, info.Actor, info.RequestID, jsonParam(snapshotBook(imported[bookID])),
+			}
+		})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, importEvents, auditEvents[models.AuditActionCreate], pq.Array(auditIDs))
+	return err
+}
+
+// copyRows copies the n rows returned by row with the COPY statement
+func copyRows(ctx context.Context, tx *sql.Tx, copyStmt string, n int, row func(i int) []interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, copyStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := 0; i < n; i++ {
+		if _, err = stmt.ExecContext(ctx, row(i)...); err != nil {
+			return err
+		}
+	}
+
+	// the buffered rows are sent by the final exec without arguments
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
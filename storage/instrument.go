@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// Observer is called with the context, the duration and the error of every storage call,
+// named by its method
+type Observer func(ctx context.Context, method string, duration time.Duration, err error)
+
+// Instrument returns the storage reporting every call of the store to the observers
+func Instrument(store Storage, observers ...Observer) Storage {
+	return &instrumentedStore{store: store, observers: observers}
+}
+
+type instrumentedStore struct {
+	store     Storage
+	observers []Observer
+}
+
+func (s *instrumentedStore) observe(ctx context.Context, method string, start time.Time, err *error) {
+	duration := time.Since(start)
+	for _, observer := range s.observers {
+		observer(ctx, method, duration, *err)
+	}
+}
+
+func (s *instrumentedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *instrumentedStore) DBStats() sql.DBStats {
+	return s.store.DBStats()
+}
+
+func (s *instrumentedStore) CreateBook(ctx context.Context, book *models.Book) (_ *uuid.UUID, err error) {
+	defer s.observe(ctx, "CreateBook", time.Now(), &err)
+	return s.store.CreateBook(ctx, book)
+}
+
+func (s *instrumentedStore) ImportBooks(ctx context.Context, books []*models.Book) (err error) {
+	defer s.observe(ctx, "ImportBooks", time.Now(), &err)
+	return s.store.ImportBooks(ctx, books)
+}
+
+func (s *instrumentedStore) DeleteBook(ctx context.Context, bookID uuid.UUID, version int) (err error) {
+	defer s.observe(ctx, "DeleteBook", time.Now(), &err)
+	return s.store.DeleteBook(ctx, bookID, version)
+}
+
+func (s *instrumentedStore) UpdateBook(ctx context.Context, book *models.Book) (err error) {
+	defer s.observe(ctx, "UpdateBook", time.Now(), &err)
+	return s.store.UpdateBook(ctx, book)
+}
+
+func (s *instrumentedStore) PatchBook(ctx context.Context, book *models.Book, fields []BookField) (_ *models.Book, err error) {
+	defer s.observe(ctx, "PatchBook", time.Now(), &err)
+	return s.store.PatchBook(ctx, book, fields)
+}
+
+func (s *instrumentedStore) GetBook(ctx context.Context, bookID uuid.UUID) (_ *models.Book, err error) {
+	defer s.observe(ctx, "GetBook", time.Now(), &err)
+	return s.store.GetBook(ctx, bookID)
+}
+
+func (s *instrumentedStore) ListBooks(ctx context.Context, query ListBooksQuery) (_ *ListBooksResult, err error) {
+	defer s.observe(ctx, "ListBooks", time.Now(), &err)
+	return s.store.ListBooks(ctx, query)
+}
+
+func (s *instrumentedStore) ExportBooks(ctx context.Context, query ListBooksQuery, fn func(book *models.Book) error) (err error) {
+	defer s.observe(ctx, "ExportBooks", time.Now(), &err)
+	return s.store.ExportBooks(ctx, query, fn)
+}
+
+func (s *instrumentedStore) SearchBooks(ctx context.Context, query SearchBooksQuery) (_ []*BookSearchHit, err error) {
+	defer s.observe(ctx, "SearchBooks", time.Now(), &err)
+	return s.store.SearchBooks(ctx, query)
+}
+
+func (s *instrumentedStore) ListTrash(ctx context.Context) (_ []*models.Book, err error) {
+	defer s.observe(ctx, "ListTrash", time.Now(), &err)
+	return s.store.ListTrash(ctx)
+}
+
+func (s *instrumentedStore) RestoreBook(ctx context.Context, bookID uuid.UUID) (_ *models.Book, err error) {
+	defer s.observe(ctx, "RestoreBook", time.Now(), &err)
+	return s.store.RestoreBook(ctx, bookID)
+}
+
+func (s *instrumentedStore) PurgeBooks(ctx context.Context, retention time.Duration) (_ int, err error) {
+	defer s.observe(ctx, "PurgeBooks", time.Now(), &err)
+	return s.store.PurgeBooks(ctx, retention)
+}
+
+func (s *instrumentedStore) ListBookHistory(ctx context.Context, bookID uuid.UUID, query AuditQuery) (_ *ListAuditResult, err error) {
+	defer s.observe(ctx, "ListBookHistory", time.Now(), &err)
+	return s.store.ListBookHistory(ctx, bookID, query)
+}
+
+func (s *instrumentedStore) ListAudit(ctx context.Context, query AuditQuery) (_ *ListAuditResult, err error) {
+	defer s.observe(ctx, "ListAudit", time.Now(), &err)
+	return s.store.ListAudit(ctx, query)
+}
+
+func (s *instrumentedStore) CheckoutBook(ctx context.Context, loan *models.Loan) (_ *models.Loan, err error) {
+	defer s.observe(ctx, "CheckoutBook", time.Now(), &err)
+	return s.store.CheckoutBook(ctx, loan)
+}
+
+func (s *instrumentedStore) ReturnBook(ctx context.Context, bookID uuid.UUID) (_ *models.Loan, err error) {
+	defer s.observe(ctx, "ReturnBook", time.Now(), &err)
+	return s.store.ReturnBook(ctx, bookID)
+}
+
+func (s *instrumentedStore) ListLoans(ctx context.Context, bookID uuid.UUID) (_ []*models.Loan, err error) {
+	defer s.observe(ctx, "ListLoans", time.Now(), &err)
+	return s.store.ListLoans(ctx, bookID)
+}
+
+func (s *instrumentedStore) PlaceHold(ctx context.Context, hold *models.Hold) (_ *models.Hold, err error) {
+	defer s.observe(ctx, "PlaceHold", time.Now(), &err)
+	return s.store.PlaceHold(ctx, hold)
+}
+
+func (s *instrumentedStore) CancelHold(ctx context.Context, bookID, holdID uuid.UUID) (_ *models.Hold, err error) {
+	defer s.observe(ctx, "CancelHold", time.Now(), &err)
+	return s.store.CancelHold(ctx, bookID, holdID)
+}
+
+func (s *instrumentedStore) ListHolds(ctx context.Context, bookID uuid.UUID) (_ []*models.Hold, err error) {
+	defer s.observe(ctx, "ListHolds", time.Now(), &err)
+	return s.store.ListHolds(ctx, bookID)
+}
+
+func (s *instrumentedStore) ExpireHolds(ctx context.Context) (_ int, err error) {
+	defer s.observe(ctx, "ExpireHolds", time.Now(), &err)
+	return s.store.ExpireHolds(ctx)
+}
+
+func (s *instrumentedStore) AccrueFines(ctx context.Context, policy models.FinePolicy) (_ int, err error) {
+	defer s.observe(ctx, "AccrueFines", time.Now(), &err)
+	return s.store.AccrueFines(ctx, policy)
+}
+
+func (s *instrumentedStore) ListMemberFines(ctx context.Context, memberID uuid.UUID) (_ []*models.Fine, err error) {
+	defer s.observe(ctx, "ListMemberFines", time.Now(), &err)
+	return s.store.ListMemberFines(ctx, memberID)
+}
+
+func (s *instrumentedStore) PayFine(ctx context.Context, fineID uuid.UUID) (_ *models.Fine, err error) {
+	defer s.observe(ctx, "PayFine", time.Now(), &err)
+	return s.store.PayFine(ctx, fineID)
+}
+
+func (s *instrumentedStore) TryLock(ctx context.Context, key int64) (_ func(), _ bool, err error) {
+	defer s.observe(ctx, "TryLock", time.Now(), &err)
+	return s.store.TryLock(ctx, key)
+}
+
+func (s *instrumentedStore) CreateMember(ctx context.Context, member *models.Member) (_ *uuid.UUID, err error) {
+	defer s.observe(ctx, "CreateMember", time.Now(), &err)
+	return s.store.CreateMember(ctx, member)
+}
+
+func (s *instrumentedStore) UpdateMember(ctx context.Context, member *models.Member) (err error) {
+	defer s.observe(ctx, "UpdateMember", time.Now(), &err)
+	return s.store.UpdateMember(ctx, member)
+}
+
+func (s *instrumentedStore) DeleteMember(ctx context.Context, memberID uuid.UUID) (err error) {
+	defer s.observe(ctx, "DeleteMember", time.Now(), &err)
+	return s.store.DeleteMember(ctx, memberID)
+}
+
+func (s *instrumentedStore) GetMember(ctx context.Context, memberID uuid.UUID) (_ *models.Member, err error) {
+	defer s.observe(ctx, "GetMember", time.Now(), &err)
+	return s.store.GetMember(ctx, memberID)
+}
+
+func (s *instrumentedStore) ListMembers(ctx context.Context) (_ []*models.Member, err error) {
+	defer s.observe(ctx, "ListMembers", time.Now(), &err)
+	return s.store.ListMembers(ctx)
+}
+
+func (s *instrumentedStore) CreateCopy(ctx context.Context, item *models.Copy) (_ *uuid.UUID, err error) {
+	defer s.observe(ctx, "CreateCopy", time.Now(), &err)
+	return s.store.CreateCopy(ctx, item)
+}
+
+func (s *instrumentedStore) UpdateCopy(ctx context.Context, item *models.Copy) (err error) {
+	defer s.observe(ctx, "UpdateCopy", time.Now(), &err)
+	return s.store.UpdateCopy(ctx, item)
+}
+
+func (s *instrumentedStore) DeleteCopy(ctx context.Context, bookID, copyID uuid.UUID) (err error) {
+	defer s.observe(ctx, "DeleteCopy", time.Now(), &err)
+	return s.store.DeleteCopy(ctx, bookID, copyID)
+}
+
+func (s *instrumentedStore) GetCopy(ctx context.Context, bookID, copyID uuid.UUID) (_ *models.Copy, err error) {
+	defer s.observe(ctx, "GetCopy", time.Now(), &err)
+	return s.store.GetCopy(ctx, bookID, copyID)
+}
+
+func (s *instrumentedStore) ListCopies(ctx context.Context, bookID uuid.UUID) (_ []*models.Copy, err error) {
+	defer s.observe(ctx, "ListCopies", time.Now(), &err)
+	return s.store.ListCopies(ctx, bookID)
+}
+
+func (s *instrumentedStore) PendingEvents(ctx context.Context, limit int) (_ []*models.Event, err error) {
+	defer s.observe(ctx, "PendingEvents", time.Now(), &err)
+	return s.store.PendingEvents(ctx, limit)
+}
+
+func (s *instrumentedStore) AckEvent(ctx context.Context, eventID int64) (err error) {
+	defer s.observe(ctx, "AckEvent", time.Now(), &err)
+	return s.store.AckEvent(ctx, eventID)
+}
+
+func (s *instrumentedStore) RetryEvent(ctx context.Context, eventID int64, delay time.Duration, reason string) (err error) {
+	defer s.observe(ctx, "RetryEvent", time.Now(), &err)
+	return s.store.RetryEvent(ctx, eventID, delay, reason)
+}
+
+func (s *instrumentedStore) FailEvent(ctx context.Context, eventID int64, reason string) (err error) {
+	defer s.observe(ctx, "FailEvent", time.Now(), &err)
+	return s.store.FailEvent(ctx, eventID, reason)
+}
+
+func (s *instrumentedStore) ListenEvents(ctx context.Context, fn func(event *models.Event), lost func()) (err error) {
+	defer s.observe(ctx, "ListenEvents", time.Now(), &err)
+	return s.store.ListenEvents(ctx, fn, lost)
+}
+
+func (s *instrumentedStore) CreateWebhook(ctx context.Context, webhook *models.Webhook) (_ *uuid.UUID, err error) {
+	defer s.observe(ctx, "CreateWebhook", time.Now(), &err)
+	return s.store.CreateWebhook(ctx, webhook)
+}
+
+func (s *instrumentedStore) UpdateWebhook(ctx context.Context, webhook *models.Webhook, active *bool) (err error) {
+	defer s.observe(ctx, "UpdateWebhook", time.Now(), &err)
+	return s.store.UpdateWebhook(ctx, webhook, active)
+}
+
+func (s *instrumentedStore) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (err error) {
+	defer s.observe(ctx, "DeleteWebhook", time.Now(), &err)
+	return s.store.DeleteWebhook(ctx, webhookID)
+}
+
+func (s *instrumentedStore) GetWebhook(ctx context.Context, webhookID uuid.UUID) (_ *models.Webhook, err error) {
+	defer s.observe(ctx, "GetWebhook", time.Now(), &err)
+	return s.store.GetWebhook(ctx, webhookID)
+}
+
+func (s *instrumentedStore) ListWebhooks(ctx context.Context) (_ []*models.Webhook, err error) {
+	defer s.observe(ctx, "ListWebhooks", time.Now(), &err)
+	return s.store.ListWebhooks(ctx)
+}
+
+func (s *instrumentedStore) EnqueueWebhookDeliveries(ctx context.Context, delivery *models.WebhookDelivery) (_ int, err error) {
+	defer s.observe(ctx, "EnqueueWebhookDeliveries", time.Now(), &err)
+	return s.store.EnqueueWebhookDeliveries(ctx, delivery)
+}
+
+func (s *instrumentedStore) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) (_ *models.WebhookDelivery, err error) {
+	defer s.observe(ctx, "CreateWebhookDelivery", time.Now(), &err)
+	return s.store.CreateWebhookDelivery(ctx, delivery)
+}
+
+func (s *instrumentedStore) PendingWebhookDeliveries(ctx context.Context, limit int, skip []uuid.UUID) (_ []*models.WebhookDelivery, err error) {
+	defer s.observe(ctx, "PendingWebhookDeliveries", time.Now(), &err)
+	return s.store.PendingWebhookDeliveries(ctx, limit, skip)
+}
+
+func (s *instrumentedStore) RecordWebhookAttempt(ctx context.Context, deliveryID uuid.UUID, attempt models.WebhookAttempt) (_ *models.WebhookDelivery, err error) {
+	defer s.observe(ctx, "RecordWebhookAttempt", time.Now(), &err)
+	return s.store.RecordWebhookAttempt(ctx, deliveryID, attempt)
+}
+
+func (s *instrumentedStore) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, query WebhookDeliveryQuery) (_ []*models.WebhookDelivery, err error) {
+	defer s.observe(ctx, "ListWebhookDeliveries", time.Now(), &err)
+	return s.store.ListWebhookDeliveries(ctx, webhookID, query)
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) (err error) {
+	defer s.observe(ctx, "Ping", time.Now(), &err)
+	return s.store.Ping(ctx)
+}
+
+func (s *instrumentedStore) CheckMigrations(ctx context.Context) (err error) {
+	defer s.observe(ctx, "CheckMigrations", time.Now(), &err)
+	return s.store.CheckMigrations(ctx)
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+
+	defaultSort = "-createdAt"
+
+	cursorTimeLayout = "2006-01-02T15:04:05.999999"
+	dateLayout       = "2006-01-02"
+)
+
+var (
+	ErrInvalidCursor = newError(KindInvalid, "invalid cursor")
+	ErrInvalidSort   = newError(KindInvalid, "invalid sort field")
+)
+
+// ListBooksQuery holds paging, filtering and sorting parameters of ListBooks.
+// Zero values mean no filter.
+type ListBooksQuery struct {
+	// Limit is the page size, defaults to DefaultListLimit and is capped by MaxListLimit
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	// Sort is a field name, prefixed with "-" for descending order. Defaults to "-createdAt"
+	Sort string
+
+	Author        string
+	Publisher     string
+	Status        models.BookStatus
+	MinRating     int
+	MaxRating     int
+	PublishedFrom *time.Time
+	PublishedTo   *time.Time
+
+	// WithTotal requests the number of books matching the filters
+	WithTotal bool
+}
+
+type ListBooksResult struct {
+	Books []*models.Book
+	// NextCursor is empty on the last page
+	NextCursor string
+	// TotalCount is set only if requested by ListBooksQuery.WithTotal
+	TotalCount *int
+}
+
+// sortField describes a sortable column. Text columns are compared with the "C" collation,
+// so that Postgres and the in-memory storage order them the same way
+type sortField struct {
+	column  string
+	cast    string
+	value   func(b *models.Book) string
+	compare func(a, b *models.Book) int
+}
+
+var sortFields = map[string]sortField{
+	"title": {
+		column:  `title COLLATE "C"`,
+		cast:    "text",
+		value:   func(b *models.Book) string { return b.Title },
+		compare: func(a, b *models.Book) int { return strings.Compare(a.Title, b.Title) },
+	},
+	"author": {
+		column:  `author COLLATE "C"`,
+		cast:    "text",
+		value:   func(b *models.Book) string { return b.Author },
+		compare: func(a, b *models.Book) int { return strings.Compare(a.Author, b.Author) },
+	},
+	"publisher": {
+		column:  `COALESCE(publisher, '') COLLATE "C"`,
+		cast:    "text",
+		value:   func(b *models.Book) string { return b.Publisher },
+		compare: func(a, b *models.Book) int { return strings.Compare(a.Publisher, b.Publisher) },
+	},
+	"publishDate": {
+		column:  `COALESCE(publish_date, '0001-01-01')`,
+		cast:    "date",
+		value:   func(b *models.Book) string { return publishDateOrZero(b).Format(dateLayout) },
+		compare: func(a, b *models.Book) int { return compareTime(publishDateOrZero(a), publishDateOrZero(b)) },
+	},
+	"rating": {
+		column:  `COALESCE(rating, 0)`,
+		cast:    "integer",
+		value:   func(b *models.Book) string { return strconv.Itoa(b.Rating) },
+		compare: func(a, b *models.Book) int { return a.Rating - b.Rating },
+	},
+	"status": {
+		column:  `status COLLATE "C"`,
+		cast:    "text",
+		value:   func(b *models.Book) string { return string(b.Status) },
+		compare: func(a, b *models.Book) int { return strings.Compare(string(a.Status), string(b.Status)) },
+	},
+	"createdAt": {
+		column:  `created_at`,
+		cast:    "timestamp",
+		value:   func(b *models.Book) string { return b.CreatedAt.Format(cursorTimeLayout) },
+		compare: func(a, b *models.Book) int { return compareTime(*a.CreatedAt, *b.CreatedAt) },
+	},
+	"updatedAt": {
+		column:  `updated_at`,
+		cast:    "timestamp",
+		value:   func(b *models.Book) string { return b.UpdatedAt.Format(cursorTimeLayout) },
+		compare: func(a, b *models.Book) int { return compareTime(*a.UpdatedAt, *b.UpdatedAt) },
+	},
+}
+
+// cursor is the position of the last book of a page in the requested ordering
+type cursor struct {
+	Sort  string    `json:"s"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// listPlan is a validated ListBooksQuery
+type listPlan struct {
+	ListBooksQuery
+	sortName string
+	field    sortField
+	desc     bool
+	after    *cursor
+	// afterBook holds the cursor position as a book, for comparing in memory
+	afterBook *models.Book
+}
+
+func (q ListBooksQuery) plan() (*listPlan, error) {
+	p := &listPlan{ListBooksQuery: q}
+
+	if p.Limit <= 0 {
+		p.Limit = DefaultListLimit
+	}
+	if p.Limit > MaxListLimit {
+		p.Limit = MaxListLimit
+	}
+
+	if p.Sort == "" {
+		p.Sort = defaultSort
+	}
+	p.sortName = strings.TrimPrefix(p.Sort, "-")
+	p.desc = p.sortName != p.Sort
+
+	var ok bool
+	if p.field, ok = sortFields[p.sortName]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, p.sortName)
+	}
+
+	if p.Cursor != "" {
+		c, err := decodeCursor(p.Cursor)
+		if err != nil || c.Sort != p.Sort {
+			return nil, ErrInvalidCursor
+		}
+
+		pos := &models.Book{ID: c.ID}
+		if err = setSortValue(pos, p.sortName, c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		p.after, p.afterBook = c, pos
+	}
+
+	return p, nil
+}
+
+func (p *listPlan) cursorFor(book *models.Book) string {
+	return encodeCursor(&cursor{
+		Sort:  p.Sort,
+		Value: p.field.value(book),
+		ID:    book.ID,
+	})
+}
+
+// matches tells whether the book passes the query filters
+func (p *listPlan) matches(book *models.Book) bool {
+	switch {
+	case p.Author != "" && book.Author != p.Author,
+		p.Publisher != "" && book.Publisher != p.Publisher,
+		p.Status != "" && book.Status != p.Status,
+		p.MinRating != 0 && book.Rating < p.MinRating,
+		p.MaxRating != 0 && book.Rating > p.MaxRating,
+		p.PublishedFrom != nil && (book.PublishDate == nil || book.PublishDate.Before(*p.PublishedFrom)),
+		p.PublishedTo != nil && (book.PublishDate == nil || book.PublishDate.After(*p.PublishedTo)):
+		return false
+	}
+	return true
+}
+
+// filters returns the SQL conditions and arguments of the query filters
+func (p *listPlan) filters() ([]string, []interface{}) {
+	var (
+		// deleted books are listed only in the trash
+		conds = []string{"deleted_at IS NULL"}
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if p.Author != "" {
+		add("author = $%d", p.Author)
+	}
+	if p.Publisher != "" {
+		add("publisher = $%d", p.Publisher)
+	}
+	if p.Status != "" {
+		add("status = $%d", p.Status)
+	}
+	if p.MinRating != 0 {
+		add("rating >= $%d", p.MinRating)
+	}
+	if p.MaxRating != 0 {
+		add("rating <= $%d", p.MaxRating)
+	}
+	if p.PublishedFrom != nil {
+		add("publish_date >= $%d", p.PublishedFrom.Format(dateLayout))
+	}
+	if p.PublishedTo != nil {
+		add("publish_date <= $%d", p.PublishedTo.Format(dateLayout))
+	}
+
+	return conds, args
+}
+
+// keyset returns the SQL condition selecting books after the cursor position
+func (p *listPlan) keyset(argOffset int) (string, []interface{}) {
+	op := ">"
+	if p.desc {
+		op = "<"
+	}
+
+	cond := fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)",
+		p.field.column, op, argOffset+1, p.field.cast, argOffset+2)
+
+	return cond, []interface{}{p.after.Value, p.after.ID}
+}
+
+// orderBy returns the SQL ORDER BY clause matching compare
+func (p *listPlan) orderBy() string {
+	dir := "ASC"
+	if p.desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, id %s", p.field.column, dir, dir)
+}
+
+// compare orders books by the sort field and then by id, in the requested direction
+func (p *listPlan) compare(a, b *models.Book) int {
+	res := p.field.compare(a, b)
+	if res == 0 {
+		res = bytes.Compare(a.ID[:], b.ID[:])
+	}
+	if p.desc {
+		return -res
+	}
+	return res
+}
+
+// isAfterCursor tells whether the book comes after the cursor position
+func (p *listPlan) isAfterCursor(book *models.Book) bool {
+	return p.afterBook == nil || p.compare(book, p.afterBook) > 0
+}
+
+// setSortValue is the reverse of sortField.value
+func setSortValue(book *models.Book, field, value string) error {
+	switch field {
+	case "title":
+		book.Title = value
+	case "author":
+		book.Author = value
+	case "publisher":
+		book.Publisher = value
+	case "status":
+		book.Status = models.BookStatus(value)
+	case "rating":
+		rating, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		book.Rating = rating
+	case "publishDate":
+		date, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return err
+		}
+		book.PublishDate = &date
+	case "createdAt", "updatedAt":
+		ts, err := time.Parse(cursorTimeLayout, value)
+		if err != nil {
+			return err
+		}
+		book.CreatedAt, book.UpdatedAt = &ts, &ts
+	}
+	return nil
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conds, " AND ") + "\n"
+}
+
+func encodeCursor(c *cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	if err = json.Unmarshal(payload, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func publishDateOrZero(b *models.Book) time.Time {
+	if b.PublishDate == nil {
+		return time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return *b.PublishDate
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) CheckoutBook(ctx context.Context, loan *models.Loan) (*models.Loan, error) {
+	created := *loan
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		status, err := lockBook(ctx, tx, loan.BookID)
+		if err != nil {
+			return err
+		}
+		if status, err = settleHolds(ctx, tx, loan.BookID, status); err != nil {
+			return err
+		}
+
+		var hold *models.Hold
+		switch status {
+		case models.BookStatusCheckedOut:
+			return ErrBookCheckedOut
+		case models.BookStatusReadyForPickup:
+			// only the member of the ready hold can pick the book up
+			if hold, err = readyHoldOf(ctx, tx, loan.BookID); err != nil {
+				return err
+			}
+			if hold == nil || loan.MemberID == nil || hold.MemberID != *loan.MemberID {
+				return ErrBookOnHold
+			}
+		}
+
+		member, err := checkBorrower(ctx, tx, loan.MemberID)
+		if err != nil {
+			return err
+		}
+		created.Borrower = member.Name
+
+		if hold != nil {
+			if _, err = tx.ExecContext(ctx, closeHold, hold.ID, models.HoldStatusFulfilled); err != nil {
+				return err
+			}
+		}
+
+		var dueAt *time.Time
+		if !loan.DueAt.IsZero() {
+			dueAt = &loan.DueAt
+		}
+		if err = tx.QueryRowContext(ctx, createLoan,
+			loan.BookID, loan.MemberID, created.Borrower, dueAt, loan.Period.Seconds(),
+		).Scan(&created.ID, &created.CheckedOutAt, &created.DueAt); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, setBookStatus, loan.BookID, models.BookStatusCheckedOut)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (s *storeImpl) ReturnBook(ctx context.Context, bookID uuid.UUID) (*models.Loan, error) {
+	var loan *models.Loan
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockBook(ctx, tx, bookID); err != nil {
+			return err
+		}
+
+		var err error
+		if loan, err = scanLoan(tx.QueryRowContext(ctx, returnLoan, bookID)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrBookNotCheckedOut
+			}
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, setBookStatus, bookID, models.BookStatusCheckedIn); err != nil {
+			return err
+		}
+
+		// the returned book goes to the first member waiting for it
+		_, err = settleHolds(ctx, tx, bookID, models.BookStatusCheckedIn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+func (s *storeImpl) ListLoans(ctx context.Context, bookID uuid.UUID) ([]*models.Loan, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, bookExists, bookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrBookNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, listLoans, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []*models.Loan
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+
+	return loans, rows.Err()
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) CreateMember(ctx context.Context, member *models.Member) (*uuid.UUID, error) {
+	var id uuid.UUID
+	if err := s.db.QueryRowContext(ctx, createMember,
+		member.Name, member.Email, member.CardNumber, member.Status, member.BorrowingLimit,
+	).Scan(&id); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrMemberExists
+		}
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func (s *storeImpl) UpdateMember(ctx context.Context, member *models.Member) error {
+	res, err := s.db.ExecContext(ctx, updateMember,
+		member.ID,
+		member.Name,
+		member.Email,
+		member.CardNumber,
+		member.Status,
+		member.BorrowingLimit,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrMemberExists
+		}
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func (s *storeImpl) DeleteMember(ctx context.Context, memberID uuid.UUID) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockMemberRow(ctx, tx, memberID); err != nil {
+			return err
+		}
+
+		var activeLoans int
+		if err := tx.QueryRowContext(ctx, countActiveLoans, memberID).Scan(&activeLoans); err != nil {
+			return err
+		}
+		if activeLoans > 0 {
+			return ErrMemberHasLoans
+		}
+
+		var activeHolds int
+		if err := tx.QueryRowContext(ctx, countMemberActiveHolds, memberID).Scan(&activeHolds); err != nil {
+			return err
+		}
+		if activeHolds > 0 {
+			return ErrMemberHasHolds
+		}
+
+		_, err := tx.ExecContext(ctx, deleteMember, memberID)
+		return err
+	})
+}
+
+func (s *storeImpl) GetMember(ctx context.Context, memberID uuid.UUID) (*models.Member, error) {
+	member, err := scanMember(s.db.QueryRowContext(ctx, getMember, memberID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (s *storeImpl) ListMembers(ctx context.Context) ([]*models.Member, error) {
+	rows, err := s.db.QueryContext(ctx, listMembers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*models.Member
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// lockMemberRow locks the member row until the end of the transaction, serializing checkouts
+// of the member so the borrowing limit can't be exceeded by concurrent requests
+func lockMemberRow(ctx context.Context, tx *sql.Tx, memberID uuid.UUID) (*models.Member, error) {
+	member, err := scanMember(tx.QueryRowContext(ctx, lockMember, memberID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// checkBorrower makes sure the member may borrow one more book
+func checkBorrower(ctx context.Context, tx *sql.Tx, memberID *uuid.UUID) (*models.Member, error) {
+	if memberID == nil {
+		return nil, ErrMemberNotFound
+	}
+
+	member, err := lockMemberRow(ctx, tx, *memberID)
+	if err != nil {
+		return nil, err
+	}
+	if member.Status != models.MemberStatusActive {
+		return nil, ErrMemberSuspended
+	}
+
+	var activeLoans int
+	if err = tx.QueryRowContext(ctx, countActiveLoans, member.ID).Scan(&activeLoans); err != nil {
+		return nil, err
+	}
+	if activeLoans >= member.BorrowingLimit {
+		return nil, ErrBorrowingLimitReached
+	}
+
+	return member, nil
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+// memStore is an in-memory Storage. It mirrors the behaviour of the Postgres
+// implementation and is meant for tests and local runs without a database.
+type memStore struct {
+	mu    sync.RWMutex
+	books map[uuid.UUID]*models.Book
+	// trash holds the deleted books, their loans, holds and copies are kept until purged
+	trash map[uuid.UUID]*models.Book
+	// audit holds the audit log in the order of changes
+	audit []*models.AuditEntry
+	// outbox holds the undelivered events in the order of changes
+	outbox      []*memEvent
+	lastEventID int64
+	// listeners are called with every event queued in the outbox
+	listeners      map[int]func(event *models.Event)
+	lastListenerID int
+	webhooks       map[uuid.UUID]*models.Webhook
+	// webhookDeliveries holds the deliveries of all webhooks in the order they were queued
+	webhookDeliveries []*models.WebhookDelivery
+	// loans holds loans of every book, in checkout order
+	loans   map[uuid.UUID][]*models.Loan
+	members map[uuid.UUID]*models.Member
+	// holds holds all holds of every book, in placement order
+	holds map[uuid.UUID][]*models.Hold
+	fines map[uuid.UUID]*models.Fine
+	// copies holds the copies of every book
+	copies map[uuid.UUID][]*models.Copy
+	// locks holds the keys taken with TryLock
+	locks map[int64]bool
+}
+
+func NewMemory() Storage {
+	return &memStore{
+		books:   make(map[uuid.UUID]*models.Book),
+		trash:   make(map[uuid.UUID]*models.Book),
+		loans:   make(map[uuid.UUID][]*models.Loan),
+		members: make(map[uuid.UUID]*models.Member),
+		holds:   make(map[uuid.UUID][]*models.Hold),
+		fines:   make(map[uuid.UUID]*models.Fine),
+		locks:   make(map[int64]bool),
+		copies:  make(map[uuid.UUID][]*models.Copy),
+
+		webhooks:  make(map[uuid.UUID]*models.Webhook),
+		listeners: make(map[int]func(event *models.Event)),
+	}
+}
+
+func (s *memStore) CreateBook(ctx context.Context, book *models.Book) (*uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.createBook(ctx, book)
+	return &id, nil
+}
+
+func (s *memStore) ImportBooks(ctx context.Context, books []*models.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, book := range books {
+		book.ID = s.createBook(ctx, book)
+	}
+	return nil
+}
+
+// createBook stores the new book and returns its id. Callers must hold the lock
+func (s *memStore) createBook(ctx context.Context, book *models.Book) uuid.UUID {
+	now := memNow()
+	stored := copyBook(book)
+	stored.ID = uuid.New()
+	stored.Status = models.BookStatusCheckedIn
+	stored.Version = 1
+	stored.CreatedAt = &now
+	stored.UpdatedAt = &now
+	s.books[stored.ID] = stored
+	s.recordChange(ctx, models.AuditActionCreate, nil, stored)
+
+	return stored.ID
+}
+
+func (s *memStore) DeleteBook(ctx context.Context, bookID uuid.UUID, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVersion(bookID, version); err != nil {
+		return err
+	}
+
+	now := memNow()
+	book := s.books[bookID]
+	if err := s.checkBookNotInUse(book, now); err != nil {
+		return err
+	}
+
+	before := copyBook(book)
+	book.DeletedAt = &now
+	book.UpdatedAt = &now
+	book.Version++
+	s.trash[bookID] = book
+	delete(s.books, bookID)
+	s.recordChange(ctx, models.AuditActionDelete, before, book)
+	return nil
+}
+
+func (s *memStore) UpdateBook(ctx context.Context, book *models.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVersion(book.ID, book.Version); err != nil {
+		return err
+	}
+	current := s.books[book.ID]
+
+	now := memNow()
+	stored := copyBook(book)
+	stored.Status = current.Status
+	stored.CreatedAt = current.CreatedAt
+	stored.UpdatedAt = &now
+	stored.Version = current.Version + 1
+	s.books[stored.ID] = stored
+	s.recordChange(ctx, models.AuditActionUpdate, current, stored)
+	book.Version = stored.Version
+
+	return nil
+}
+
+func (s *memStore) PatchBook(ctx context.Context, book *models.Book, fields []BookField) (*models.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkVersion(book.ID, book.Version); err != nil {
+		return nil, err
+	}
+	stored := s.books[book.ID]
+	if len(fields) == 0 {
+		return s.readBook(stored), nil
+	}
+
+	patched := copyBook(stored)
+	for _, field := range fields {
+		switch field {
+		case BookFieldTitle:
+			patched.Title = book.Title
+		case BookFieldAuthor:
+			patched.Author = book.Author
+		case BookFieldPublisher:
+			patched.Publisher = book.Publisher
+		case BookFieldPublishDate:
+			patched.PublishDate = copyBook(book).PublishDate
+		case BookFieldRating:
+			patched.Rating = book.Rating
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownBookField, field)
+		}
+	}
+
+	now := memNow()
+	patched.UpdatedAt = &now
+	patched.Version++
+	s.books[patched.ID] = patched
+	s.recordChange(ctx, models.AuditActionUpdate, stored, patched)
+
+	return s.readBook(patched), nil
+}
+
+func (s *memStore) GetBook(_ context.Context, bookID uuid.UUID) (*models.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	book, ok := s.books[bookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	return s.readBook(book), nil
+}
+
+func (s *memStore) ListBooks(_ context.Context, query ListBooksQuery) (*ListBooksResult, error) {
+	plan, err := query.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*models.Book
+	for _, book := range s.books {
+		if plan.matches(book) {
+			matched = append(matched, book)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return plan.compare(matched[i], matched[j]) < 0
+	})
+
+	result := &ListBooksResult{}
+	if plan.WithTotal {
+		total := len(matched)
+		result.TotalCount = &total
+	}
+
+	for _, book := range matched {
+		if !plan.isAfterCursor(book) {
+			continue
+		}
+		if len(result.Books) == plan.Limit {
+			result.NextCursor = plan.cursorFor(result.Books[plan.Limit-1])
+			break
+		}
+		result.Books = append(result.Books, s.readBook(book))
+	}
+
+	return result, nil
+}
+
+func (s *memStore) ExportBooks(_ context.Context, query ListBooksQuery, fn func(book *models.Book) error) error {
+	plan, err := query.plan()
+	if err != nil {
+		return err
+	}
+
+	// the matching books are copied, so fn is called without holding the lock
+	s.mu.RLock()
+	var matched []*models.Book
+	for _, book := range s.books {
+		if plan.matches(book) && plan.isAfterCursor(book) {
+			matched = append(matched, s.readBook(book))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return plan.compare(matched[i], matched[j]) < 0
+	})
+
+	for _, book := range matched {
+		if err = fn(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) SearchBooks(_ context.Context, query SearchBooksQuery) ([]*BookSearchHit, error) {
+	query, tokens, err := query.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	books := make([]*models.Book, 0, len(s.books))
+	for _, book := range s.books {
+		books = append(books, s.readBook(book))
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].CreatedAt.After(*books[j].CreatedAt)
+	})
+
+	return naiveSearch(books, query, tokens), nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func (s *memStore) DBStats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+func (s *memStore) Ping(context.Context) error {
+	return nil
+}
+
+func (s *memStore) CheckMigrations(context.Context) error {
+	return nil
+}
+
+// memNow returns the current time with the precision of a Postgres TIMESTAMP.
+func memNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// checkVersion makes sure the book exists in the given version, any version when it is 0.
+// Callers must hold the lock
+func (s *memStore) checkVersion(bookID uuid.UUID, version int) error {
+	book, ok := s.books[bookID]
+	if !ok {
+		return ErrBookNotFound
+	}
+	if version != 0 && book.Version != version {
+		return ErrBookVersionMismatch
+	}
+	return nil
+}
+
+// readBook returns a copy of the stored book with its copies rolled up. Callers must hold the lock
+func (s *memStore) readBook(book *models.Book) *models.Book {
+	out := copyBook(book)
+	out.Copies = s.copyCounts(book.ID)
+	return out
+}
+
+// copyBook returns a deep copy of the book, so callers can't mutate stored data.
+// Publish date is truncated to a day, as it is stored as DATE in Postgres.
+func copyBook(in *models.Book) *models.Book {
+	out := *in
+	if in.PublishDate != nil {
+		publishDate := in.PublishDate.UTC().Truncate(24 * time.Hour)
+		out.PublishDate = &publishDate
+	}
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	if in.UpdatedAt != nil {
+		updatedAt := *in.UpdatedAt
+		out.UpdatedAt = &updatedAt
+	}
+	if in.DeletedAt != nil {
+		deletedAt := *in.DeletedAt
+		out.DeletedAt = &deletedAt
+	}
+	return &out
+}
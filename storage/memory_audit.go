@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) ListBookHistory(_ context.Context, bookID uuid.UUID, query AuditQuery) (*ListAuditResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result, err := s.listAudit(&bookID, query)
+	if err != nil || len(result.Entries) > 0 {
+		return result, err
+	}
+
+	// the history outlives purged books
+	_, live := s.books[bookID]
+	_, trashed := s.trash[bookID]
+	if !live && !trashed && !s.hasHistory(bookID) {
+		return nil, ErrBookNotFound
+	}
+	return result, nil
+}
+
+func (s *memStore) ListAudit(_ context.Context, query AuditQuery) (*ListAuditResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.listAudit(nil, query)
+}
+
+// listAudit returns a page of the audit log of the book, of all books when bookID is nil.
+// The log is appended to, so the entries after the cursor are the ones before its entry.
+// Callers must hold the lock
+func (s *memStore) listAudit(bookID *uuid.UUID, query AuditQuery) (*ListAuditResult, error) {
+	query, after, err := query.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	end := len(s.audit)
+	if after != nil {
+		if end = s.auditIndex(after.ID); end < 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	result := &ListAuditResult{}
+	for i := end - 1; i >= 0; i-- {
+		entry := s.audit[i]
+		switch {
+		case bookID != nil && entry.BookID != *bookID,
+			query.From != nil && entry.CreatedAt.Before(*query.From),
+			query.To != nil && !entry.CreatedAt.Before(*query.To):
+			continue
+		}
+		if len(result.Entries) == query.Limit {
+			result.NextCursor = auditCursorFor(result.Entries[query.Limit-1])
+			break
+		}
+		result.Entries = append(result.Entries, copyAuditEntry(entry))
+	}
+
+	return result, nil
+}
+
+// auditIndex returns the index of the entry in the audit log, -1 if there is none. Callers must hold the lock
+func (s *memStore) auditIndex(id uuid.UUID) int {
+	for i, entry := range s.audit {
+		if entry.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasHistory tells whether the audit log has changes of the book. Callers must hold the lock
+func (s *memStore) hasHistory(bookID uuid.UUID) bool {
+	for _, entry := range s.audit {
+		if entry.BookID == bookID {
+			return true
+		}
+	}
+	return false
+}
+
+// recordChange appends the change of the book to the audit log and queues its event.
+// Callers must hold the lock
+func (s *memStore) recordChange(ctx context.Context, action models.AuditAction, before, after *models.Book) {
+	now := memNow()
+	info := auditInfoFrom(ctx)
+	s.audit = append(s.audit, &models.AuditEntry{
+		ID:        uuid.New(),
+		BookID:    after.ID,
+		Action:    action,
+		Actor:     info.Actor,
+		RequestID: info.RequestID,
+		Before:    snapshotBook(before),
+		After:     snapshotBook(after),
+		CreatedAt: &now,
+	})
+
+	s.lastEventID++
+	event := &memEvent{
+		Event: models.Event{
+			ID:        s.lastEventID,
+			Type:      auditEvents[action],
+			BookID:    after.ID,
+			Payload:   snapshotBook(after),
+			CreatedAt: &now,
+		},
+		nextAttemptAt: now,
+	}
+	s.outbox = append(s.outbox, event)
+	for _, fn := range s.listeners {
+		fn(copyEvent(&event.Event))
+	}
+}
+
+func copyAuditEntry(in *models.AuditEntry) *models.AuditEntry {
+	out := *in
+	out.Before = append(out.Before[:0:0], in.Before...)
+	out.After = append(out.After[:0:0], in.After...)
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	return &out
+}
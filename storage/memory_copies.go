@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) CreateCopy(_ context.Context, item *models.Copy) (*uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[item.BookID]; !ok {
+		return nil, ErrBookNotFound
+	}
+	if s.barcodeExists(item) {
+		return nil, ErrCopyExists
+	}
+
+	now := memNow()
+	stored := copyCopy(item)
+	stored.ID = uuid.New()
+	stored.CreatedAt = &now
+	stored.UpdatedAt = &now
+	s.copies[stored.BookID] = append(s.copies[stored.BookID], stored)
+	s.books[stored.BookID].Version++
+
+	id := stored.ID
+	return &id, nil
+}
+
+func (s *memStore) UpdateCopy(_ context.Context, item *models.Copy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.findCopy(item.BookID, item.ID)
+	if current == nil {
+		return ErrCopyNotFound
+	}
+	if s.barcodeExists(item) {
+		return ErrCopyExists
+	}
+
+	now := memNow()
+	current.Barcode = item.Barcode
+	current.Location = item.Location
+	current.Condition = item.Condition
+	current.Status = item.Status
+	current.UpdatedAt = &now
+	s.books[current.BookID].Version++
+
+	return nil
+}
+
+func (s *memStore) DeleteCopy(_ context.Context, bookID, copyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[bookID]; !ok {
+		return ErrCopyNotFound
+	}
+
+	copies := s.copies[bookID]
+	for i, item := range copies {
+		if item.ID == copyID {
+			s.copies[bookID] = append(copies[:i:i], copies[i+1:]...)
+			s.books[bookID].Version++
+			return nil
+		}
+	}
+
+	return ErrCopyNotFound
+}
+
+func (s *memStore) GetCopy(_ context.Context, bookID, copyID uuid.UUID) (*models.Copy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item := s.findCopy(bookID, copyID)
+	if item == nil {
+		return nil, ErrCopyNotFound
+	}
+
+	return copyCopy(item), nil
+}
+
+func (s *memStore) ListCopies(_ context.Context, bookID uuid.UUID) ([]*models.Copy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.books[bookID]; !ok {
+		return nil, ErrBookNotFound
+	}
+
+	copies := make([]*models.Copy, 0, len(s.copies[bookID]))
+	for _, item := range s.copies[bookID] {
+		copies = append(copies, copyCopy(item))
+	}
+
+	sort.Slice(copies, func(i, j int) bool {
+		if copies[i].Barcode != copies[j].Barcode {
+			return copies[i].Barcode < copies[j].Barcode
+		}
+		return bytes.Compare(copies[i].ID[:], copies[j].ID[:]) < 0
+	})
+
+	return copies, nil
+}
+
+// findCopy returns the stored copy of the book, if any, skipping books in the trash. Callers must hold the lock
+func (s *memStore) findCopy(bookID, copyID uuid.UUID) *models.Copy {
+	if _, ok := s.books[bookID]; !ok {
+		return nil
+	}
+	for _, item := range s.copies[bookID] {
+		if item.ID == copyID {
+			return item
+		}
+	}
+	return nil
+}
+
+// barcodeExists reports whether another copy has the barcode. Callers must hold the lock
+func (s *memStore) barcodeExists(item *models.Copy) bool {
+	for _, copies := range s.copies {
+		for _, other := range copies {
+			if other.ID != item.ID && other.Barcode == item.Barcode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyCounts rolls up the copies of the book. Callers must hold the lock
+func (s *memStore) copyCounts(bookID uuid.UUID) models.CopyCounts {
+	counts := models.CopyCounts{Total: len(s.copies[bookID])}
+	for _, item := range s.copies[bookID] {
+		if item.Status == models.CopyStatusAvailable {
+			counts.Available++
+		}
+	}
+	return counts
+}
+
+func copyCopy(in *models.Copy) *models.Copy {
+	out := *in
+	for _, ts := range []**time.Time{&out.CreatedAt, &out.UpdatedAt} {
+		if *ts != nil {
+			copied := **ts
+			*ts = &copied
+		}
+	}
+	return &out
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) AccrueFines(_ context.Context, policy models.FinePolicy) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fines := make(map[uuid.UUID]*models.Fine, len(s.fines))
+	for _, fine := range s.fines {
+		fines[fine.LoanID] = fine
+	}
+
+	now := memNow()
+	var accrued int
+	for _, loans := range s.loans {
+		for _, loan := range loans {
+			if loan.MemberID == nil {
+				continue
+			}
+
+			end := now
+			if loan.ReturnedAt != nil {
+				end = *loan.ReturnedAt
+			}
+			amount := policy.Amount(end.Sub(loan.DueAt))
+			if amount == 0 {
+				continue
+			}
+
+			fine, ok := fines[loan.ID]
+			switch {
+			case !ok:
+				createdAt, updatedAt := now, now
+				fine = &models.Fine{
+					ID:        uuid.New(),
+					LoanID:    loan.ID,
+					MemberID:  *loan.MemberID,
+					Status:    models.FineStatusOpen,
+					CreatedAt: &createdAt,
+					UpdatedAt: &updatedAt,
+				}
+				s.fines[fine.ID] = fine
+			case fine.Status != models.FineStatusOpen:
+				continue
+			case loan.ReturnedAt != nil && !fine.UpdatedAt.Before(*loan.ReturnedAt):
+				continue
+			default:
+				updatedAt := now
+				fine.UpdatedAt = &updatedAt
+			}
+
+			fine.Amount = amount
+			accrued++
+		}
+	}
+
+	return accrued, nil
+}
+
+func (s *memStore) ListMemberFines(_ context.Context, memberID uuid.UUID) ([]*models.Fine, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.members[memberID]; !ok {
+		return nil, ErrMemberNotFound
+	}
+
+	var fines []*models.Fine
+	for _, fine := range s.fines {
+		if fine.MemberID == memberID {
+			fines = append(fines, copyFine(fine))
+		}
+	}
+
+	sort.Slice(fines, func(i, j int) bool {
+		if !fines[i].CreatedAt.Equal(*fines[j].CreatedAt) {
+			return fines[i].CreatedAt.After(*fines[j].CreatedAt)
+		}
+		return bytes.Compare(fines[i].ID[:], fines[j].ID[:]) < 0
+	})
+
+	return fines, nil
+}
+
+func (s *memStore) PayFine(_ context.Context, fineID uuid.UUID) (*models.Fine, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fine, ok := s.fines[fineID]
+	if !ok {
+		return nil, ErrFineNotFound
+	}
+	if fine.Status == models.FineStatusPaid {
+		return nil, ErrFinePaid
+	}
+	for _, loan := range s.loans {
+		for _, l := range loan {
+			if l.ID == fine.LoanID && l.ReturnedAt == nil {
+				return nil, ErrFineAccruing
+			}
+		}
+	}
+
+	now := memNow()
+	paidAt, updatedAt := now, now
+	fine.Status = models.FineStatusPaid
+	fine.PaidAt = &paidAt
+	fine.UpdatedAt = &updatedAt
+
+	return copyFine(fine), nil
+}
+
+func (s *memStore) TryLock(_ context.Context, key int64) (func(), bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locks[key] {
+		return nil, false, nil
+	}
+	s.locks[key] = true
+
+	unlock := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.locks, key)
+	}
+
+	return unlock, true, nil
+}
+
+func copyFine(in *models.Fine) *models.Fine {
+	out := *in
+	for _, ts := range []**time.Time{&out.CreatedAt, &out.UpdatedAt, &out.PaidAt} {
+		if *ts != nil {
+			copied := **ts
+			*ts = &copied
+		}
+	}
+	return &out
+}
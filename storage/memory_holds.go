@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) PlaceHold(_ context.Context, hold *models.Hold) (*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[hold.BookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	now := memNow()
+	s.settleHolds(book, now)
+	if book.Status == models.BookStatusCheckedIn {
+		return nil, ErrBookAvailable
+	}
+
+	member, ok := s.members[hold.MemberID]
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+	if member.Status != models.MemberStatusActive {
+		return nil, ErrMemberSuspended
+	}
+
+	if loan := s.activeLoan(book.ID); loan != nil && loan.MemberID != nil && *loan.MemberID == member.ID {
+		return nil, ErrHoldOwnLoan
+	}
+	for _, other := range s.activeHolds(book.ID) {
+		if other.MemberID == member.ID {
+			return nil, ErrHoldExists
+		}
+	}
+
+	stored := copyHold(hold)
+	stored.ID = uuid.New()
+	stored.Status = models.HoldStatusWaiting
+	stored.PickupWindow = hold.PickupWindow.Truncate(time.Second)
+	stored.CreatedAt = &now
+	stored.ReadyAt, stored.ExpiresAt, stored.ClosedAt = nil, nil, nil
+	s.holds[book.ID] = append(s.holds[book.ID], stored)
+
+	return copyHold(stored), nil
+}
+
+func (s *memStore) CancelHold(_ context.Context, bookID, holdID uuid.UUID) (*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[bookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	var hold *models.Hold
+	for _, h := range s.holds[bookID] {
+		if h.ID == holdID {
+			hold = h
+		}
+	}
+	if hold == nil {
+		return nil, ErrHoldNotFound
+	}
+	if !hold.Status.IsActive() {
+		return nil, ErrHoldNotActive
+	}
+
+	now := memNow()
+	hold.Status = models.HoldStatusCancelled
+	hold.ClosedAt = &now
+	s.settleHolds(book, now)
+
+	return copyHold(hold), nil
+}
+
+func (s *memStore) ListHolds(_ context.Context, bookID uuid.UUID) ([]*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[bookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+	s.settleHolds(book, memNow())
+
+	var holds []*models.Hold
+	for _, hold := range s.activeHolds(bookID) {
+		holds = append(holds, copyHold(hold))
+	}
+
+	return holds, nil
+}
+
+// settleHolds mirrors settleHolds of the Postgres storage. Callers must hold the lock
+func (s *memStore) settleHolds(book *models.Book, now time.Time) {
+	for _, hold := range s.holds[book.ID] {
+		if hold.Status == models.HoldStatusReady && !hold.ExpiresAt.After(now) {
+			closedAt := now
+			hold.Status = models.HoldStatusExpired
+			hold.ClosedAt = &closedAt
+		}
+	}
+	if book.Status == models.BookStatusCheckedOut {
+		return
+	}
+
+	status := models.BookStatusCheckedIn
+	if s.readyHold(book.ID) != nil {
+		status = models.BookStatusReadyForPickup
+	} else {
+		for _, hold := range s.activeHolds(book.ID) {
+			readyAt, expiresAt := now, now.Add(hold.PickupWindow)
+			hold.Status = models.HoldStatusReady
+			hold.ReadyAt, hold.ExpiresAt = &readyAt, &expiresAt
+			status = models.BookStatusReadyForPickup
+			break
+		}
+	}
+
+	if status != book.Status {
+		updatedAt := now
+		book.Status = status
+		book.UpdatedAt = &updatedAt
+		book.Version++
+	}
+}
+
+func (s *memStore) ExpireHolds(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	var expired int
+	for _, book := range s.books {
+		if hold := s.readyHold(book.ID); hold != nil && !hold.ExpiresAt.After(now) {
+			s.settleHolds(book, now)
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// activeHolds returns the stored active holds of the book in queue order. Callers must hold the lock
+func (s *memStore) activeHolds(bookID uuid.UUID) []*models.Hold {
+	var holds []*models.Hold
+	for _, hold := range s.holds[bookID] {
+		if hold.Status.IsActive() {
+			holds = append(holds, hold)
+		}
+	}
+
+	sort.SliceStable(holds, func(i, j int) bool {
+		if !holds[i].CreatedAt.Equal(*holds[j].CreatedAt) {
+			return holds[i].CreatedAt.Before(*holds[j].CreatedAt)
+		}
+		return bytes.Compare(holds[i].ID[:], holds[j].ID[:]) < 0
+	})
+
+	return holds
+}
+
+// readyHold returns the stored hold ready for pickup of the book, if any. Callers must hold the lock
+func (s *memStore) readyHold(bookID uuid.UUID) *models.Hold {
+	for _, hold := range s.holds[bookID] {
+		if hold.Status == models.HoldStatusReady {
+			return hold
+		}
+	}
+	return nil
+}
+
+// checkBookNotInUse mirrors checkBookNotInUse of the Postgres storage. Callers must hold the lock
+func (s *memStore) checkBookNotInUse(book *models.Book, now time.Time) error {
+	if book.Status == models.BookStatusCheckedOut {
+		return fmt.Errorf("%w: return it before deleting the book", ErrBookCheckedOut)
+	}
+	for _, hold := range s.activeHolds(book.ID) {
+		if hold.Status == models.HoldStatusWaiting || hold.ExpiresAt.After(now) {
+			return fmt.Errorf("%w: cancel the holds before deleting the book", ErrBookOnHold)
+		}
+	}
+	return nil
+}
+
+// activeHoldsOf counts the active holds of the member. Callers must hold the lock
+func (s *memStore) activeHoldsOf(memberID uuid.UUID) int {
+	var count int
+	for _, holds := range s.holds {
+		for _, hold := range holds {
+			if hold.Status.IsActive() && hold.MemberID == memberID {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func copyHold(in *models.Hold) *models.Hold {
+	out := *in
+	for _, ts := range []**time.Time{&out.CreatedAt, &out.ReadyAt, &out.ExpiresAt, &out.ClosedAt} {
+		if *ts != nil {
+			copied := **ts
+			*ts = &copied
+		}
+	}
+	return &out
+}
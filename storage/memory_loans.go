@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) CheckoutBook(_ context.Context, loan *models.Loan) (*models.Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[loan.BookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	now := memNow()
+	s.settleHolds(book, now)
+
+	var hold *models.Hold
+	switch book.Status {
+	case models.BookStatusCheckedOut:
+		return nil, ErrBookCheckedOut
+	case models.BookStatusReadyForPickup:
+		hold = s.readyHold(book.ID)
+		if hold == nil || loan.MemberID == nil || hold.MemberID != *loan.MemberID {
+			return nil, ErrBookOnHold
+		}
+	}
+
+	member, err := s.checkBorrower(loan.MemberID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold != nil {
+		closedAt := now
+		hold.Status = models.HoldStatusFulfilled
+		hold.ClosedAt = &closedAt
+	}
+
+	stored := copyLoan(loan)
+	stored.Borrower = member.Name
+	stored.ID = uuid.New()
+	stored.CheckedOutAt = &now
+	stored.ReturnedAt = nil
+	if stored.DueAt.IsZero() {
+		stored.DueAt = now.Add(loan.Period)
+	}
+	s.loans[book.ID] = append(s.loans[book.ID], stored)
+
+	book.Status = models.BookStatusCheckedOut
+	book.UpdatedAt = &now
+	book.Version++
+
+	return copyLoan(stored), nil
+}
+
+func (s *memStore) ReturnBook(_ context.Context, bookID uuid.UUID) (*models.Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[bookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	loan := s.activeLoan(bookID)
+	if loan == nil {
+		return nil, ErrBookNotCheckedOut
+	}
+
+	now := memNow()
+	loan.ReturnedAt = &now
+
+	book.Status = models.BookStatusCheckedIn
+	book.UpdatedAt = &now
+	book.Version++
+	s.settleHolds(book, now)
+
+	return copyLoan(loan), nil
+}
+
+func (s *memStore) ListLoans(_ context.Context, bookID uuid.UUID) ([]*models.Loan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.books[bookID]; !ok {
+		return nil, ErrBookNotFound
+	}
+
+	var loans []*models.Loan
+	history := s.loans[bookID]
+	for i := len(history) - 1; i >= 0; i-- {
+		loans = append(loans, copyLoan(history[i]))
+	}
+
+	return loans, nil
+}
+
+// activeLoan returns the stored not returned loan of the book, if any. Callers must hold the lock
+func (s *memStore) activeLoan(bookID uuid.UUID) *models.Loan {
+	for _, loan := range s.loans[bookID] {
+		if loan.ReturnedAt == nil {
+			return loan
+		}
+	}
+	return nil
+}
+
+func copyLoan(in *models.Loan) *models.Loan {
+	out := *in
+	out.DueAt = in.DueAt.UTC().Truncate(time.Microsecond)
+	if in.MemberID != nil {
+		memberID := *in.MemberID
+		out.MemberID = &memberID
+	}
+	if in.CheckedOutAt != nil {
+		checkedOutAt := *in.CheckedOutAt
+		out.CheckedOutAt = &checkedOutAt
+	}
+	if in.ReturnedAt != nil {
+		returnedAt := *in.ReturnedAt
+		out.ReturnedAt = &returnedAt
+	}
+	return &out
+}
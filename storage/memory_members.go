@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) CreateMember(_ context.Context, member *models.Member) (*uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.memberExists(member) {
+		return nil, ErrMemberExists
+	}
+
+	now := memNow()
+	stored := copyMember(member)
+	stored.ID = uuid.New()
+	stored.CreatedAt = &now
+	stored.UpdatedAt = &now
+	s.members[stored.ID] = stored
+
+	id := stored.ID
+	return &id, nil
+}
+
+func (s *memStore) UpdateMember(_ context.Context, member *models.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.members[member.ID]
+	if !ok {
+		return ErrMemberNotFound
+	}
+	if s.memberExists(member) {
+		return ErrMemberExists
+	}
+
+	now := memNow()
+	stored := copyMember(member)
+	stored.CreatedAt = current.CreatedAt
+	stored.UpdatedAt = &now
+	s.members[stored.ID] = stored
+
+	return nil
+}
+
+func (s *memStore) DeleteMember(_ context.Context, memberID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[memberID]; !ok {
+		return ErrMemberNotFound
+	}
+	if s.activeLoansOf(memberID) > 0 {
+		return ErrMemberHasLoans
+	}
+	if s.activeHoldsOf(memberID) > 0 {
+		return ErrMemberHasHolds
+	}
+
+	delete(s.members, memberID)
+
+	// mirror ON DELETE SET NULL of loans.member_id
+	for _, loans := range s.loans {
+		for _, loan := range loans {
+			if loan.MemberID != nil && *loan.MemberID == memberID {
+				loan.MemberID = nil
+			}
+		}
+	}
+	// and ON DELETE CASCADE of fines.member_id
+	for id, fine := range s.fines {
+		if fine.MemberID == memberID {
+			delete(s.fines, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *memStore) GetMember(_ context.Context, memberID uuid.UUID) (*models.Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	member, ok := s.members[memberID]
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+
+	return copyMember(member), nil
+}
+
+func (s *memStore) ListMembers(_ context.Context) ([]*models.Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var members []*models.Member
+	for _, member := range s.members {
+		members = append(members, copyMember(member))
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Name != members[j].Name {
+			return members[i].Name < members[j].Name
+		}
+		return members[i].ID.String() < members[j].ID.String()
+	})
+
+	return members, nil
+}
+
+// memberExists tells whether another member has the same email or card number.
+// Callers must hold the lock
+func (s *memStore) memberExists(member *models.Member) bool {
+	for _, other := range s.members {
+		if other.ID == member.ID {
+			continue
+		}
+		if strings.EqualFold(other.Email, member.Email) || other.CardNumber == member.CardNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBorrower makes sure the member may borrow one more book. Callers must hold the lock
+func (s *memStore) checkBorrower(memberID *uuid.UUID) (*models.Member, error) {
+	if memberID == nil {
+		return nil, ErrMemberNotFound
+	}
+
+	member, ok := s.members[*memberID]
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+	if member.Status != models.MemberStatusActive {
+		return nil, ErrMemberSuspended
+	}
+	if s.activeLoansOf(member.ID) >= member.BorrowingLimit {
+		return nil, ErrBorrowingLimitReached
+	}
+
+	return member, nil
+}
+
+// activeLoansOf counts the not returned loans of the member. Callers must hold the lock
+func (s *memStore) activeLoansOf(memberID uuid.UUID) int {
+	var count int
+	for _, loans := range s.loans {
+		for _, loan := range loans {
+			if loan.ReturnedAt == nil && loan.MemberID != nil && *loan.MemberID == memberID {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func copyMember(in *models.Member) *models.Member {
+	out := *in
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	if in.UpdatedAt != nil {
+		updatedAt := *in.UpdatedAt
+		out.UpdatedAt = &updatedAt
+	}
+	return &out
+}
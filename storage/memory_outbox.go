@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// memEvent is an event of the outbox with its delivery state
+type memEvent struct {
+	models.Event
+	nextAttemptAt time.Time
+	failed        bool
+	lastError     string
+}
+
+func (s *memStore) PendingEvents(_ context.Context, limit int) ([]*models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := memNow()
+	var events []*models.Event
+	for _, event := range s.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.failed || event.nextAttemptAt.After(now) {
+			continue
+		}
+		events = append(events, copyEvent(&event.Event))
+	}
+
+	return events, nil
+}
+
+func (s *memStore) AckEvent(_ context.Context, eventID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, event := range s.outbox {
+		if event.ID == eventID {
+			s.outbox = append(s.outbox[:i:i], s.outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memStore) RetryEvent(_ context.Context, eventID int64, delay time.Duration, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event := s.findEvent(eventID); event != nil {
+		event.Attempts++
+		event.lastError = reason
+		event.nextAttemptAt = memNow().Add(delay)
+	}
+	return nil
+}
+
+func (s *memStore) FailEvent(_ context.Context, eventID int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event := s.findEvent(eventID); event != nil {
+		event.Attempts++
+		event.lastError = reason
+		event.failed = true
+	}
+	return nil
+}
+
+// ListenEvents never loses events, lost is not called
+func (s *memStore) ListenEvents(ctx context.Context, fn func(event *models.Event), _ func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastListenerID++
+	id := s.lastListenerID
+	s.listeners[id] = fn
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.listeners, id)
+	}()
+
+	return nil
+}
+
+// findEvent returns the event of the outbox, if any. Callers must hold the lock
+func (s *memStore) findEvent(eventID int64) *memEvent {
+	for _, event := range s.outbox {
+		if event.ID == eventID {
+			return event
+		}
+	}
+	return nil
+}
+
+func copyEvent(in *models.Event) *models.Event {
+	out := *in
+	out.Payload = append(out.Payload[:0:0], in.Payload...)
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	return &out
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBooks(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	publishDate := time.Date(2021, 7, 7, 0, 0, 0, 0, time.UTC)
+	first, err := s.CreateBook(ctx, &models.Book{Title: "1", Author: "a", PublishDate: &publishDate, Rating: 1, Status: models.BookStatusCheckedIn})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	second, err := s.CreateBook(ctx, &models.Book{Title: "2", Author: "b", Rating: 2, Status: models.BookStatusCheckedIn})
+	require.NoError(t, err)
+
+	book, err := s.GetBook(ctx, *first)
+	require.NoError(t, err)
+	assert.Equal(t, "1", book.Title)
+	assert.Equal(t, publishDate, *book.PublishDate)
+	assert.Equal(t, book.CreatedAt, book.UpdatedAt)
+
+	// mutating a returned book must not affect the stored one
+	book.Title = "changed"
+	book, err = s.GetBook(ctx, *first)
+	require.NoError(t, err)
+	assert.Equal(t, "1", book.Title)
+
+	list, err := s.ListBooks(ctx, ListBooksQuery{})
+	require.NoError(t, err)
+	require.Len(t, list.Books, 2)
+	assert.Equal(t, *second, list.Books[0].ID)
+	assert.Equal(t, *first, list.Books[1].ID)
+	assert.Empty(t, list.NextCursor)
+
+	time.Sleep(time.Millisecond)
+	book.Title = "updated"
+	require.NoError(t, s.UpdateBook(ctx, book))
+	updated, err := s.GetBook(ctx, *first)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", updated.Title)
+	assert.Equal(t, book.CreatedAt, updated.CreatedAt)
+	assert.True(t, updated.UpdatedAt.After(*updated.CreatedAt))
+
+	assert.Equal(t, ErrBookNotFound, s.UpdateBook(ctx, &models.Book{ID: uuid.New()}))
+
+	require.NoError(t, s.DeleteBook(ctx, *first, 0))
+	assert.Equal(t, ErrBookNotFound, s.DeleteBook(ctx, *first, 0))
+	_, err = s.GetBook(ctx, *first)
+	assert.Equal(t, ErrBookNotFound, err)
+}
+
+func TestMemoryListBooksPaging(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	authors := []string{"b", "a", "c", "a", "b", "c", "a"}
+	for i, author := range authors {
+		publishDate := time.Date(2000+i, 1, 1, 0, 0, 0, 0, time.UTC)
+		_, err := s.CreateBook(ctx, &models.Book{
+			Title:       "title",
+			Author:      author,
+			PublishDate: &publishDate,
+			Rating:      i%3 + 1,
+			Status:      models.BookStatusCheckedIn,
+		})
+		require.NoError(t, err)
+	}
+
+	for field := range sortFields {
+		for _, sort := range []string{field, "-" + field} {
+			t.Run(sort, func(t *testing.T) {
+				all, err := s.ListBooks(ctx, ListBooksQuery{Sort: sort})
+				require.NoError(t, err)
+				require.Len(t, all.Books, len(authors))
+
+				// paging through with any limit must return the same books in the same order
+				var (
+					paged  []*models.Book
+					cursor string
+				)
+				for {
+					page, err := s.ListBooks(ctx, ListBooksQuery{Sort: sort, Limit: 2, Cursor: cursor})
+					require.NoError(t, err)
+					paged = append(paged, page.Books...)
+					if page.NextCursor == "" {
+						break
+					}
+					cursor = page.NextCursor
+				}
+				assert.Equal(t, all.Books, paged)
+			})
+		}
+	}
+
+	from := time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC)
+	filtered, err := s.ListBooks(ctx, ListBooksQuery{Author: "a", PublishedFrom: &from, PublishedTo: &to, MaxRating: 1, WithTotal: true})
+	require.NoError(t, err)
+	require.Len(t, filtered.Books, 1)
+	assert.Equal(t, 2003, filtered.Books[0].PublishDate.Year())
+	assert.Equal(t, 1, *filtered.TotalCount)
+
+	_, err = s.ListBooks(ctx, ListBooksQuery{Sort: "unknown"})
+	assert.True(t, errors.Is(err, ErrInvalidSort))
+	_, err = s.ListBooks(ctx, ListBooksQuery{Cursor: "garbage"})
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+
+	page, err := s.ListBooks(ctx, ListBooksQuery{Sort: "title", Limit: 1})
+	require.NoError(t, err)
+	_, err = s.ListBooks(ctx, ListBooksQuery{Sort: "author", Cursor: page.NextCursor})
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+}
+
+func TestMemorySearchBooks(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	for _, book := range []*models.Book{
+		{Title: "The Lord of the Rings", Author: "J. R. R. Tolkien", Publisher: "Allen & Unwin"},
+		{Title: "The Hobbit", Author: "J. R. R. Tolkien", Publisher: "Allen & Unwin"},
+		{Title: "Tolkien: A Biography", Author: "Humphrey Carpenter", Publisher: "Allen & Unwin"},
+		{Title: "Dune", Author: "Frank Herbert", Publisher: "Chilton Books"},
+	} {
+		_, err := s.CreateBook(ctx, book)
+		require.NoError(t, err)
+	}
+
+	hits, err := s.SearchBooks(ctx, SearchBooksQuery{Query: "tolk"})
+	require.NoError(t, err)
+	require.Len(t, hits, 3)
+	// title matches rank higher than author matches
+	assert.Equal(t, "Tolkien: A Biography", hits[0].Book.Title)
+	assert.Equal(t, "<b>Tolkien</b>: A Biography", hits[0].Highlights.Title)
+	assert.Equal(t, "J. R. R. <b>Tolkien</b>", hits[1].Highlights.Author)
+
+	hits, err = s.SearchBooks(ctx, SearchBooksQuery{Query: "HOBB tolkien"})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "The <b>Hobbit</b>", hits[0].Highlights.Title)
+	assert.Equal(t, "Allen &amp; Unwin", hits[0].Highlights.Publisher)
+
+	hits, err = s.SearchBooks(ctx, SearchBooksQuery{Query: "tolkien", Limit: 1})
+	require.NoError(t, err)
+	assert.Len(t, hits, 1)
+
+	hits, err = s.SearchBooks(ctx, SearchBooksQuery{Query: "unwin dune"})
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+
+	_, err = s.SearchBooks(ctx, SearchBooksQuery{Query: " - & "})
+	assert.True(t, errors.Is(err, ErrEmptySearchQuery))
+}
+
+func TestMemoryTrash(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	bookID, err := s.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	_, err = s.CreateCopy(ctx, &models.Copy{BookID: *bookID, Barcode: "1", Condition: models.CopyConditionGood, Status: models.CopyStatusAvailable})
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteBook(ctx, *bookID, 0))
+	_, err = s.GetBook(ctx, *bookID)
+	assert.Equal(t, ErrBookNotFound, err)
+	_, err = s.ListCopies(ctx, *bookID)
+	assert.Equal(t, ErrBookNotFound, err)
+	list, err := s.ListBooks(ctx, ListBooksQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, list.Books)
+
+	trash, err := s.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.NotNil(t, trash[0].DeletedAt)
+
+	// books deleted within the retention are kept
+	purged, err := s.PurgeBooks(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	book, err := s.RestoreBook(ctx, *bookID)
+	require.NoError(t, err)
+	assert.Nil(t, book.DeletedAt)
+	assert.Equal(t, 1, book.Copies.Total)
+	_, err = s.RestoreBook(ctx, *bookID)
+	assert.Equal(t, ErrBookNotFound, err)
+
+	require.NoError(t, s.DeleteBook(ctx, *bookID, 0))
+	time.Sleep(time.Millisecond)
+	purged, err = s.PurgeBooks(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	trash, err = s.ListTrash(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, trash)
+	_, err = s.RestoreBook(ctx, *bookID)
+	assert.Equal(t, ErrBookNotFound, err)
+}
+
+func TestMemoryAudit(t *testing.T) {
+	ctx := WithAuditInfo(context.Background(), AuditInfo{Actor: "tester", RequestID: "req-1"})
+	s := NewMemory()
+
+	bookID, err := s.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	_, err = s.PatchBook(context.Background(), &models.Book{ID: *bookID, Title: "2"}, []BookField{BookFieldTitle})
+	require.NoError(t, err)
+	// failed changes are not recorded
+	assert.Equal(t, ErrBookVersionMismatch, s.DeleteBook(ctx, *bookID, 1))
+
+	result, err := s.ListBookHistory(ctx, *bookID, AuditQuery{})
+	require.NoError(t, err)
+	history := result.Entries
+	require.Len(t, history, 2)
+	assert.Equal(t, models.AuditActionUpdate, history[0].Action)
+	assert.Equal(t, AnonymousActor, history[0].Actor)
+	var before, after bookSnapshot
+	require.NoError(t, json.Unmarshal(history[0].Before, &before))
+	require.NoError(t, json.Unmarshal(history[0].After, &after))
+	assert.NotNil(t, before.UpdatedAt)
+	before.UpdatedAt = nil
+	assert.Equal(t, bookSnapshot{ID: *bookID, Title: "1", Author: "a", Rating: 1, Status: models.BookStatusCheckedIn, Version: 1}, before)
+	assert.Equal(t, "2", after.Title)
+	assert.Equal(t, 2, after.Version)
+	assert.Equal(t, models.AuditActionCreate, history[1].Action)
+	assert.Equal(t, "tester", history[1].Actor)
+	assert.Equal(t, "req-1", history[1].RequestID)
+	assert.Nil(t, history[1].Before)
+
+	// the history is kept after the book is purged
+	require.NoError(t, s.DeleteBook(ctx, *bookID, 0))
+	_, err = s.PurgeBooks(ctx, -time.Hour)
+	require.NoError(t, err)
+	result, err = s.ListBookHistory(ctx, *bookID, AuditQuery{})
+	require.NoError(t, err)
+	history = result.Entries
+	assert.Len(t, history, 3)
+
+	_, err = s.ListBookHistory(ctx, uuid.New(), AuditQuery{})
+	assert.Equal(t, ErrBookNotFound, err)
+
+	to := *history[0].CreatedAt
+	all, err := s.ListAudit(ctx, AuditQuery{To: &to})
+	require.NoError(t, err)
+	assert.Len(t, all.Entries, 2)
+	all, err = s.ListAudit(ctx, AuditQuery{From: &to, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, all.Entries, 1)
+	assert.Equal(t, models.AuditActionDelete, all.Entries[0].Action)
+}
+
+func TestMemoryWebhooks(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	all, err := s.CreateWebhook(ctx, &models.Webhook{URL: "http://all", Secret: "secret", Active: true})
+	require.NoError(t, err)
+	deleted, err := s.CreateWebhook(ctx, &models.Webhook{
+		URL: "http://deleted", Secret: "secret", Active: true, EventTypes: []models.EventType{models.EventBookDeleted},
+	})
+	require.NoError(t, err)
+	_, err = s.CreateWebhook(ctx, &models.Webhook{URL: "http://inactive", Secret: "secret"})
+	require.NoError(t, err)
+
+	// events are queued once for the active webhooks subscribed to them
+	eventID := int64(1)
+	event := &models.WebhookDelivery{EventID: &eventID, EventType: models.EventBookCreated, Payload: []byte(`{}`)}
+	queued, err := s.EnqueueWebhookDeliveries(ctx, event)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	queued, err = s.EnqueueWebhookDeliveries(ctx, event)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+
+	pending, err := s.PendingWebhookDeliveries(ctx, 10, nil)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, *all, pending[0].WebhookID)
+	skipped, err := s.PendingWebhookDeliveries(ctx, 10, []uuid.UUID{*all})
+	require.NoError(t, err)
+	assert.Empty(t, skipped)
+
+	delivery, err := s.RecordWebhookAttempt(ctx, pending[0].ID, models.WebhookAttempt{
+		Status: models.WebhookDeliveryPending, StatusCode: 500, Error: "failed", RetryIn: time.Hour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, 500, delivery.LastStatusCode)
+	pending, err = s.PendingWebhookDeliveries(ctx, 10, nil)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// updates without a secret keep it, the ones without an active state keep it too
+	inactive := false
+	require.NoError(t, s.UpdateWebhook(ctx, &models.Webhook{ID: *deleted, URL: "http://deleted"}, &inactive))
+	webhook, err := s.GetWebhook(ctx, *deleted)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", webhook.Secret)
+	assert.False(t, webhook.Active)
+	require.NoError(t, s.UpdateWebhook(ctx, &models.Webhook{ID: *deleted, URL: "http://deleted"}, nil))
+	webhook, err = s.GetWebhook(ctx, *deleted)
+	require.NoError(t, err)
+	assert.False(t, webhook.Active)
+
+	log, err := s.ListWebhookDeliveries(ctx, *all, WebhookDeliveryQuery{Status: models.WebhookDeliveryPending})
+	require.NoError(t, err)
+	assert.Len(t, log, 1)
+
+	require.NoError(t, s.DeleteWebhook(ctx, *all))
+	_, err = s.ListWebhookDeliveries(ctx, *all, WebhookDeliveryQuery{})
+	assert.Equal(t, ErrWebhookNotFound, err)
+	_, err = s.RecordWebhookAttempt(ctx, delivery.ID, models.WebhookAttempt{Status: models.WebhookDeliveryDelivered})
+	assert.Equal(t, ErrWebhookNotFound, err)
+}
+
+func TestMemoryHoldsExpire(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	bookID, err := s.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+
+	var members []uuid.UUID
+	for _, name := range []string{"borrower", "first", "second"} {
+		id, err := s.CreateMember(ctx, &models.Member{Name: name, Email: name, CardNumber: name, Status: models.MemberStatusActive, BorrowingLimit: 1})
+		require.NoError(t, err)
+		members = append(members, *id)
+	}
+
+	_, err = s.CheckoutBook(ctx, &models.Loan{BookID: *bookID, MemberID: &members[0], DueAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	// holds with no pickup window expire as soon as they are ready
+	for _, memberID := range members[1:] {
+		_, err = s.PlaceHold(ctx, &models.Hold{BookID: *bookID, MemberID: memberID})
+		require.NoError(t, err)
+	}
+
+	_, err = s.ReturnBook(ctx, *bookID)
+	require.NoError(t, err)
+
+	holds, err := s.ListHolds(ctx, *bookID)
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, members[2], holds[0].MemberID)
+	assert.Equal(t, models.HoldStatusReady, holds[0].Status)
+
+	holds, err = s.ListHolds(ctx, *bookID)
+	require.NoError(t, err)
+	assert.Empty(t, holds)
+
+	book, err := s.GetBook(ctx, *bookID)
+	require.NoError(t, err)
+	assert.Equal(t, models.BookStatusCheckedIn, book.Status)
+}
+
+func TestMemoryFines(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+	policy := models.FinePolicy{DailyRate: 10, GraceDays: 1, MaxAmount: 25}
+
+	bookID, err := s.CreateBook(ctx, &models.Book{Title: "1", Author: "a", Rating: 1})
+	require.NoError(t, err)
+	memberID, err := s.CreateMember(ctx, &models.Member{Name: "m", Email: "m", CardNumber: "m", Status: models.MemberStatusActive, BorrowingLimit: 1})
+	require.NoError(t, err)
+
+	// two full days overdue, one of them in the grace period
+	_, err = s.CheckoutBook(ctx, &models.Loan{BookID: *bookID, MemberID: memberID, DueAt: time.Now().Add(-50 * time.Hour)})
+	require.NoError(t, err)
+
+	accrued, err := s.AccrueFines(ctx, policy)
+	require.NoError(t, err)
+	assert.Equal(t, 1, accrued)
+
+	fines, err := s.ListMemberFines(ctx, *memberID)
+	require.NoError(t, err)
+	require.Len(t, fines, 1)
+	assert.Equal(t, int64(10), fines[0].Amount)
+	assert.Equal(t, models.FineStatusOpen, fines[0].Status)
+
+	_, err = s.PayFine(ctx, fines[0].ID)
+	assert.True(t, errors.Is(err, ErrFineAccruing))
+
+	// the fine is capped and settled once the book is returned
+	s.(*memStore).loans[*bookID][0].DueAt = time.Now().Add(-10 * 24 * time.Hour)
+	_, err = s.ReturnBook(ctx, *bookID)
+	require.NoError(t, err)
+
+	accrued, err = s.AccrueFines(ctx, policy)
+	require.NoError(t, err)
+	assert.Equal(t, 1, accrued)
+
+	accrued, err = s.AccrueFines(ctx, policy)
+	require.NoError(t, err)
+	assert.Equal(t, 0, accrued)
+
+	fine, err := s.PayFine(ctx, fines[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), fine.Amount)
+	assert.Equal(t, models.FineStatusPaid, fine.Status)
+	assert.NotNil(t, fine.PaidAt)
+
+	_, err = s.PayFine(ctx, fines[0].ID)
+	assert.True(t, errors.Is(err, ErrFinePaid))
+
+	_, err = s.PayFine(ctx, uuid.New())
+	assert.True(t, errors.Is(err, ErrFineNotFound))
+}
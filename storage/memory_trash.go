@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) ListTrash(_ context.Context) ([]*models.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	books := make([]*models.Book, 0, len(s.trash))
+	for _, book := range s.trash {
+		books = append(books, s.readBook(book))
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		if !books[i].DeletedAt.Equal(*books[j].DeletedAt) {
+			return books[i].DeletedAt.After(*books[j].DeletedAt)
+		}
+		return books[i].ID.String() < books[j].ID.String()
+	})
+
+	return books, nil
+}
+
+func (s *memStore) RestoreBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.trash[bookID]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+
+	now := memNow()
+	before := copyBook(book)
+	book.DeletedAt = nil
+	book.UpdatedAt = &now
+	book.Version++
+	s.books[bookID] = book
+	delete(s.trash, bookID)
+	s.recordChange(ctx, models.AuditActionRestore, before, book)
+
+	return s.readBook(book), nil
+}
+
+func (s *memStore) PurgeBooks(_ context.Context, retention time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	var purged int
+	for bookID, book := range s.trash {
+		if !book.DeletedAt.Before(now.Add(-retention)) {
+			continue
+		}
+
+		// mirror ON DELETE CASCADE of the book rows
+		for _, loan := range s.loans[bookID] {
+			for id, fine := range s.fines {
+				if fine.LoanID == loan.ID {
+					delete(s.fines, id)
+				}
+			}
+		}
+		delete(s.trash, bookID)
+		delete(s.loans, bookID)
+		delete(s.holds, bookID)
+		delete(s.copies, bookID)
+		purged++
+	}
+
+	return purged, nil
+}
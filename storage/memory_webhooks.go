@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *memStore) CreateWebhook(_ context.Context, webhook *models.Webhook) (*uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	stored := copyWebhook(webhook)
+	stored.ID = uuid.New()
+	stored.CreatedAt = &now
+	stored.UpdatedAt = &now
+	s.webhooks[stored.ID] = stored
+
+	id := stored.ID
+	return &id, nil
+}
+
+func (s *memStore) UpdateWebhook(_ context.Context, webhook *models.Webhook, active *bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.webhooks[webhook.ID]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+
+	now := memNow()
+	stored := copyWebhook(webhook)
+	if stored.Secret == "" {
+		stored.Secret = current.Secret
+	}
+	stored.Active = current.Active
+	if active != nil {
+		stored.Active = *active
+	}
+	stored.CreatedAt = current.CreatedAt
+	stored.UpdatedAt = &now
+	s.webhooks[stored.ID] = stored
+
+	return nil
+}
+
+func (s *memStore) DeleteWebhook(_ context.Context, webhookID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[webhookID]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, webhookID)
+
+	deliveries := s.webhookDeliveries[:0]
+	for _, delivery := range s.webhookDeliveries {
+		if delivery.WebhookID != webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	s.webhookDeliveries = deliveries
+	return nil
+}
+
+func (s *memStore) GetWebhook(_ context.Context, webhookID uuid.UUID) (*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[webhookID]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	return copyWebhook(webhook), nil
+}
+
+func (s *memStore) ListWebhooks(_ context.Context) ([]*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]*models.Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(*webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(*webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID.String() < webhooks[j].ID.String()
+	})
+
+	return webhooks, nil
+}
+
+func (s *memStore) EnqueueWebhookDeliveries(_ context.Context, delivery *models.WebhookDelivery) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queued int
+	for _, webhook := range s.webhooks {
+		if !webhook.Active || !webhook.Subscribes(delivery.EventType) || s.eventQueued(webhook.ID, delivery.EventID) {
+			continue
+		}
+
+		queue := copyWebhookDelivery(delivery)
+		queue.WebhookID = webhook.ID
+		s.queueWebhookDelivery(queue)
+		queued++
+	}
+
+	return queued, nil
+}
+
+func (s *memStore) CreateWebhookDelivery(_ context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[delivery.WebhookID]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	return copyWebhookDelivery(s.queueWebhookDelivery(copyWebhookDelivery(delivery))), nil
+}
+
+func (s *memStore) PendingWebhookDeliveries(_ context.Context, limit int, skip []uuid.UUID) ([]*models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	skipped := make(map[uuid.UUID]bool, len(skip))
+	for _, id := range skip {
+		skipped[id] = true
+	}
+
+	now := memNow()
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range s.webhookDeliveries {
+		if delivery.Status != models.WebhookDeliveryPending || delivery.NextAttemptAt.After(now) || skipped[delivery.WebhookID] {
+			continue
+		}
+		if webhook := s.webhooks[delivery.WebhookID]; webhook == nil || !webhook.Active {
+			continue
+		}
+		deliveries = append(deliveries, copyWebhookDelivery(delivery))
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(*deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (s *memStore) RecordWebhookAttempt(_ context.Context, deliveryID uuid.UUID, attempt models.WebhookAttempt) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var delivery *models.WebhookDelivery
+	for _, d := range s.webhookDeliveries {
+		if d.ID == deliveryID {
+			delivery = d
+			break
+		}
+	}
+	if delivery == nil {
+		// the webhook was deleted with its deliveries
+		return nil, ErrWebhookNotFound
+	}
+
+	now := memNow()
+	nextAttemptAt := now.Add(attempt.RetryIn)
+	delivery.Status = attempt.Status
+	delivery.Attempts++
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+	delivery.NextAttemptAt = &nextAttemptAt
+	delivery.UpdatedAt = &now
+	delivery.DeliveredAt = nil
+	if attempt.Status == models.WebhookDeliveryDelivered {
+		delivery.DeliveredAt = &now
+	}
+
+	return copyWebhookDelivery(delivery), nil
+}
+
+func (s *memStore) ListWebhookDeliveries(_ context.Context, webhookID uuid.UUID, query WebhookDeliveryQuery) ([]*models.WebhookDelivery, error) {
+	query = query.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.webhooks[webhookID]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for i := len(s.webhookDeliveries) - 1; i >= 0 && len(deliveries) < query.Limit; i-- {
+		delivery := s.webhookDeliveries[i]
+		if delivery.WebhookID != webhookID || (query.Status != "" && delivery.Status != query.Status) {
+			continue
+		}
+		deliveries = append(deliveries, copyWebhookDelivery(delivery))
+	}
+
+	return deliveries, nil
+}
+
+// eventQueued reports whether the event is already queued for the webhook. Callers must hold the lock
+func (s *memStore) eventQueued(webhookID uuid.UUID, eventID *int64) bool {
+	if eventID == nil {
+		return false
+	}
+	for _, delivery := range s.webhookDeliveries {
+		if delivery.WebhookID == webhookID && delivery.EventID != nil && *delivery.EventID == *eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// queueWebhookDelivery stores the delivery as pending and returns it. Callers must hold the lock
+func (s *memStore) queueWebhookDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	now := memNow()
+	nextAttemptAt := now
+	delivery.ID = uuid.New()
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = &nextAttemptAt
+	delivery.LastStatusCode = 0
+	delivery.LastError = ""
+	delivery.CreatedAt = &now
+	delivery.UpdatedAt = &now
+	delivery.DeliveredAt = nil
+	s.webhookDeliveries = append(s.webhookDeliveries, delivery)
+	return delivery
+}
+
+func copyWebhook(in *models.Webhook) *models.Webhook {
+	out := *in
+	out.EventTypes = append(out.EventTypes[:0:0], in.EventTypes...)
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	if in.UpdatedAt != nil {
+		updatedAt := *in.UpdatedAt
+		out.UpdatedAt = &updatedAt
+	}
+	return &out
+}
+
+func copyWebhookDelivery(in *models.WebhookDelivery) *models.WebhookDelivery {
+	out := *in
+	out.Payload = append(out.Payload[:0:0], in.Payload...)
+	if in.EventID != nil {
+		eventID := *in.EventID
+		out.EventID = &eventID
+	}
+	if in.NextAttemptAt != nil {
+		nextAttemptAt := *in.NextAttemptAt
+		out.NextAttemptAt = &nextAttemptAt
+	}
+	if in.CreatedAt != nil {
+		createdAt := *in.CreatedAt
+		out.CreatedAt = &createdAt
+	}
+	if in.UpdatedAt != nil {
+		updatedAt := *in.UpdatedAt
+		out.UpdatedAt = &updatedAt
+	}
+	if in.DeliveredAt != nil {
+		deliveredAt := *in.DeliveredAt
+		out.DeliveredAt = &deliveredAt
+	}
+	return &out
+}
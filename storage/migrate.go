@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationLockKey is the Postgres advisory lock key held while migrating,
+// so replicas starting at the same time don't apply migrations concurrently
+const migrationLockKey int64 = 0x626f6f6b73 // "books"
+
+var (
+	ErrMigrationChecksum = errors.New("applied migration checksum mismatch")
+	ErrMigrationUnknown  = errors.New("unknown migration version")
+	ErrNoDownMigration   = errors.New("migration has no down script")
+	ErrMigrationsPending = errors.New("migrations are pending")
+)
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	*Migration
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	version   int64
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+func NewMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// LoadMigrations reads <version>_<name>.(up|down).sql files from the root of fsys
+// and returns them ordered by version
+func LoadMigrations(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies all pending migrations and returns the applied ones
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	// migrations applied by a newer build are left in place
+	return m.migrate(ctx, m.migrations[len(m.migrations)-1].Version, false)
+}
+
+// Down reverts the latest applied migration. It returns nil if nothing is applied
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var reverted *Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return nil
+		}
+
+		reverted = m.find(applied[len(applied)-1].version)
+		if reverted == nil {
+			return fmt.Errorf("%w: %d", ErrMigrationUnknown, applied[len(applied)-1].version)
+		}
+
+		return m.revert(ctx, conn, reverted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
+
+// To applies or reverts migrations until the given version is the latest applied one.
+// Version 0 reverts all migrations. It returns the applied or reverted migrations
+func (m *Migrator) To(ctx context.Context, version int64) ([]*Migration, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("%w: %d", ErrMigrationUnknown, version)
+	}
+	return m.migrate(ctx, version, true)
+}
+
+// Status returns all known migrations along with their applied state
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var statuses []*MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		appliedAt := make(map[int64]time.Time, len(applied))
+		for _, a := range applied {
+			appliedAt[a.version] = a.appliedAt
+		}
+
+		for _, migration := range m.migrations {
+			status := &MigrationStatus{Migration: migration}
+			if at, ok := appliedAt[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Pending returns the known migrations not applied yet. Unlike Status, it doesn't wait for
+// migrations in progress, so it's cheap enough for health checks
+func (m *Migrator) Pending(ctx context.Context) ([]*Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.verify(ctx, conn)
+	if err != nil {
+		if isUndefinedTable(err) {
+			// nothing was ever migrated
+			return m.migrations, nil
+		}
+		return nil, err
+	}
+
+	isApplied := make(map[int64]bool, len(applied))
+	for _, a := range applied {
+		isApplied[a.version] = true
+	}
+
+	var pending []*Migration
+	for _, migration := range m.migrations {
+		if !isApplied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) migrate(ctx context.Context, target int64, revert bool) ([]*Migration, error) {
+	var done []*Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		isApplied := make(map[int64]bool, len(applied))
+		for _, a := range applied {
+			isApplied[a.version] = true
+		}
+
+		// revert everything above the target, latest first
+		for i := len(applied) - 1; revert && i >= 0 && applied[i].version > target; i-- {
+			migration := m.find(applied[i].version)
+			if migration == nil {
+				return fmt.Errorf("%w: %d", ErrMigrationUnknown, applied[i].version)
+			}
+			if err := m.revert(ctx, conn, migration); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version > target || isApplied[migration.Version] {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration *Migration) error {
+	return inConnTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, insertMigration, migration.Version, migration.Name, migration.Checksum)
+		return err
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration *Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+	}
+
+	return inConnTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, deleteMigration, migration.Version)
+		return err
+	})
+}
+
+// verify returns applied migrations ordered by version and makes sure
+// none of the known ones were changed after being applied
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) ([]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, listMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		if migration := m.find(a.version); migration != nil && migration.Checksum != a.checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMigrationChecksum, migration.Version, migration.Name)
+		}
+		applied = append(applied, a)
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, lockMigrations, migrationLockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), unlockMigrations, migrationLockKey)
+
+	if _, err = conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
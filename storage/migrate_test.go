@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/alexkaplun/books-test/storage/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":    {Data: []byte("CREATE INDEX;")},
+		"0001_create.up.sql":       {Data: []byte("CREATE TABLE;")},
+		"0001_create.down.sql":     {Data: []byte("DROP TABLE;")},
+		"0010_later.up.sql":        {Data: []byte("ALTER TABLE;")},
+		"README.md":                {Data: []byte("ignored")},
+		"0003_not_a_migration.sql": {Data: []byte("ignored")},
+	}
+
+	loaded, err := LoadMigrations(fsys)
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+
+	assert.Equal(t, int64(1), loaded[0].Version)
+	assert.Equal(t, "create", loaded[0].Name)
+	assert.Equal(t, "CREATE TABLE;", loaded[0].Up)
+	assert.Equal(t, "DROP TABLE;", loaded[0].Down)
+	assert.Len(t, loaded[0].Checksum, 64)
+
+	assert.Equal(t, int64(2), loaded[1].Version)
+	assert.Empty(t, loaded[1].Down)
+	assert.Equal(t, int64(10), loaded[2].Version)
+
+	_, err = LoadMigrations(fstest.MapFS{"0001_create.down.sql": {Data: []byte("DROP TABLE;")}})
+	assert.Error(t, err)
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"0001_create.up.sql":  {Data: []byte("CREATE TABLE;")},
+		"0001_other.down.sql": {Data: []byte("DROP TABLE;")},
+	})
+	assert.Error(t, err)
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, loaded)
+
+	for i, m := range loaded {
+		assert.Equal(t, int64(i+1), m.Version, "migration versions must be sequential")
+		assert.NotEmpty(t, m.Down, "migration %d_%s must have a down script", m.Version, m.Name)
+	}
+}
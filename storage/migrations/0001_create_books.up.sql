@@ -0,0 +1,15 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+-- IF NOT EXISTS keeps databases bootstrapped before migrations were introduced working
+CREATE TABLE IF NOT EXISTS books (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	title			VARCHAR(255)	NOT NULL,
+	author			VARCHAR(255)	NOT NULL,
+	publisher		VARCHAR(255)	NULL,
+	publish_date	DATE			NULL,
+	rating			INTEGER			NULL,
+	status			VARCHAR(64)		NOT NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
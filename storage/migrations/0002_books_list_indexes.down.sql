@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS books_publisher_idx;
+DROP INDEX IF EXISTS books_author_idx;
+DROP INDEX IF EXISTS books_created_at_id_idx;
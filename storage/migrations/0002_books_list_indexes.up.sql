@@ -0,0 +1,4 @@
+-- keyset pagination of the default listing order
+CREATE INDEX books_created_at_id_idx ON books (created_at, id);
+CREATE INDEX books_author_idx ON books (author);
+CREATE INDEX books_publisher_idx ON books (publisher);
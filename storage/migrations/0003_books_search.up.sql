@@ -0,0 +1,8 @@
+-- the simple configuration doesn't stem, which suits names and keeps prefix matching predictable
+ALTER TABLE books ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('simple', coalesce(author, '')), 'B') ||
+	setweight(to_tsvector('simple', coalesce(publisher, '')), 'C')
+) STORED;
+
+CREATE INDEX books_search_vector_idx ON books USING GIN (search_vector);
@@ -0,0 +1,20 @@
+CREATE TABLE loans (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	book_id			UUID			NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+	borrower		VARCHAR(255)	NOT NULL,
+	checked_out_at	TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	due_at			TIMESTAMP		NOT NULL,
+	returned_at		TIMESTAMP		NULL
+);
+
+CREATE INDEX loans_book_id_idx ON loans (book_id, checked_out_at);
+
+-- a book can have only one active loan
+CREATE UNIQUE INDEX loans_active_book_idx ON loans (book_id) WHERE returned_at IS NULL;
+
+-- books checked out before loans were tracked get a loan of an unknown borrower,
+-- so they can be returned
+INSERT INTO loans (book_id, borrower, checked_out_at, due_at)
+SELECT id, 'unknown', updated_at, updated_at + INTERVAL '14 days'
+FROM books
+WHERE status = 'CheckedOut';
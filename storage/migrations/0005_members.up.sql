@@ -0,0 +1,19 @@
+CREATE TABLE members (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	name			VARCHAR(255)	NOT NULL,
+	email			VARCHAR(255)	NOT NULL,
+	card_number		VARCHAR(64)		NOT NULL,
+	status			VARCHAR(64)		NOT NULL,
+	borrowing_limit	INTEGER			NOT NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX members_email_idx ON members (lower(email));
+CREATE UNIQUE INDEX members_card_number_idx ON members (card_number);
+
+-- loans made before members were introduced keep only the borrower name
+ALTER TABLE loans ADD COLUMN member_id UUID NULL REFERENCES members (id) ON DELETE SET NULL;
+
+CREATE INDEX loans_active_member_idx ON loans (member_id) WHERE returned_at IS NULL;
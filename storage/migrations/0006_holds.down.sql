@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS holds;
+
+UPDATE books SET status = 'CheckedIn' WHERE status = 'ReadyForPickup';
@@ -0,0 +1,19 @@
+CREATE TABLE holds (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	book_id			UUID			NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+	member_id		UUID			NOT NULL REFERENCES members (id) ON DELETE CASCADE,
+	status			VARCHAR(64)		NOT NULL,
+	-- how long the book waits for pickup once the hold is ready, captured when the hold is placed
+	pickup_window	INTEGER			NOT NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ready_at		TIMESTAMP		NULL,
+	expires_at		TIMESTAMP		NULL,
+	closed_at		TIMESTAMP		NULL
+);
+
+CREATE INDEX holds_queue_idx ON holds (book_id, created_at, id) WHERE status IN ('Waiting', 'Ready');
+CREATE INDEX holds_member_idx ON holds (member_id) WHERE status IN ('Waiting', 'Ready');
+
+-- a member can queue for a book only once
+CREATE UNIQUE INDEX holds_active_member_idx ON holds (book_id, member_id) WHERE status IN ('Waiting', 'Ready');
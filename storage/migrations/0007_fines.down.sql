@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS loans_due_idx;
+DROP TABLE IF EXISTS fines;
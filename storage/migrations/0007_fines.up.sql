@@ -0,0 +1,18 @@
+CREATE TABLE fines (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	loan_id			UUID			NOT NULL REFERENCES loans (id) ON DELETE CASCADE,
+	member_id		UUID			NOT NULL REFERENCES members (id) ON DELETE CASCADE,
+	-- amount in minor currency units
+	amount			BIGINT			NOT NULL,
+	status			VARCHAR(64)		NOT NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	paid_at			TIMESTAMP		NULL
+);
+
+-- a loan accrues a single fine, updated until the book is returned
+CREATE UNIQUE INDEX fines_loan_idx ON fines (loan_id);
+CREATE INDEX fines_member_idx ON fines (member_id, created_at);
+
+CREATE INDEX loans_due_idx ON loans (due_at) WHERE returned_at IS NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE copies (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	book_id			UUID			NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+	barcode			VARCHAR(64)		NOT NULL,
+	location		VARCHAR(255)	NULL,
+	condition		VARCHAR(64)		NOT NULL,
+	status			VARCHAR(64)		NOT NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX copies_barcode_idx ON copies (barcode);
+CREATE INDEX copies_book_idx ON copies (book_id, status);
@@ -0,0 +1,2 @@
+-- version is incremented on every change of the book and backs the ETag of the book resource
+ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
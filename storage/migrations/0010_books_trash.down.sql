@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS books_deleted_at_idx;
+-- books in the trash would come back to life otherwise
+DELETE FROM books WHERE deleted_at IS NOT NULL;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+-- deleted books are kept in the trash until they are restored or purged
+ALTER TABLE books ADD COLUMN deleted_at TIMESTAMP NULL;
+
+CREATE INDEX books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
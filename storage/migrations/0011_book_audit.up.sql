@@ -0,0 +1,15 @@
+-- book_audit outlives the books, so it has no foreign key to books
+CREATE TABLE book_audit (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	book_id			UUID			NOT NULL,
+	action			VARCHAR(64)		NOT NULL,
+	actor			VARCHAR(255)	NOT NULL,
+	request_id		VARCHAR(128)	NOT NULL DEFAULT '',
+	before			JSONB			NULL,
+	after			JSONB			NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX book_audit_book_idx ON book_audit (book_id, created_at);
+CREATE INDEX book_audit_created_at_idx ON book_audit (created_at);
@@ -0,0 +1,16 @@
+-- outbox holds the book events committed with the changes until they are delivered
+CREATE TABLE outbox (
+	id				BIGSERIAL		NOT NULL PRIMARY KEY,
+	event_type		VARCHAR(64)		NOT NULL,
+	book_id			UUID			NOT NULL,
+	payload			JSONB			NOT NULL,
+	-- Pending events are delivered, Failed ones ran out of attempts and are kept for inspection
+	status			VARCHAR(64)		NOT NULL DEFAULT 'Pending',
+	attempts		INTEGER			NOT NULL DEFAULT 0,
+	last_error		TEXT			NULL,
+	next_attempt_at	TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at, id) WHERE status = 'Pending';
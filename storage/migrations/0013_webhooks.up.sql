@@ -0,0 +1,36 @@
+CREATE TABLE webhooks (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	url				VARCHAR(2048)	NOT NULL,
+	-- empty event_types subscribe to all events
+	event_types		TEXT[]			NOT NULL DEFAULT '{}',
+	secret			VARCHAR(255)	NOT NULL,
+	active			BOOLEAN			NOT NULL DEFAULT TRUE,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- webhook_deliveries is the delivery log of the webhooks, the outbox fans the events out into it
+CREATE TABLE webhook_deliveries (
+	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+	webhook_id		UUID			NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	-- event_id is NULL for test deliveries
+	event_id		BIGINT			NULL,
+	event_type		VARCHAR(64)		NOT NULL,
+	payload			JSONB			NOT NULL,
+	-- Pending deliveries are attempted, Dead ones ran out of attempts
+	status			VARCHAR(64)		NOT NULL DEFAULT 'Pending',
+	attempts		INTEGER			NOT NULL DEFAULT 0,
+	next_attempt_at	TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_status_code	INTEGER		NULL,
+	last_error		TEXT			NULL,
+
+	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at	TIMESTAMP		NULL
+);
+
+-- an event redelivered by the outbox is queued once per webhook
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries (webhook_id, event_id);
+CREATE INDEX webhook_deliveries_log_idx ON webhook_deliveries (webhook_id, created_at);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'Pending';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS book_audit_book_created_at_id_idx;
+DROP INDEX IF EXISTS book_audit_created_at_id_idx;
+CREATE INDEX book_audit_book_idx ON book_audit (book_id, created_at);
+CREATE INDEX book_audit_created_at_idx ON book_audit (created_at);
@@ -0,0 +1,5 @@
+-- keyset pagination of the audit log and the history of a book, latest first
+DROP INDEX IF EXISTS book_audit_created_at_idx;
+DROP INDEX IF EXISTS book_audit_book_idx;
+CREATE INDEX book_audit_created_at_id_idx ON book_audit (created_at, id);
+CREATE INDEX book_audit_book_created_at_id_idx ON book_audit (book_id, created_at, id);
@@ -0,0 +1,11 @@
+// Package migrations holds the versioned SQL migrations of the books schema.
+//
+// Every migration is a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Applied migrations must never be edited, as their
+// checksums are verified on every run; add a new migration instead.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
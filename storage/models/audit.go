@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEntry records a change of a book
+type AuditEntry struct {
+	ID     uuid.UUID
+	BookID uuid.UUID
+	Action AuditAction
+	// Actor identifies who made the change
+	Actor     string
+	RequestID string
+	// Before and After are JSON snapshots of the book, Before is empty for created books
+	Before    json.RawMessage
+	After     json.RawMessage
+	CreatedAt *time.Time
+}
+
+type AuditAction string
+
+const (
+	AuditActionCreate  AuditAction = "Create"
+	AuditActionUpdate  AuditAction = "Update"
+	AuditActionDelete  AuditAction = "Delete"
+	AuditActionRestore AuditAction = "Restore"
+)
@@ -16,6 +16,13 @@ type Book struct {
 	Status      BookStatus
 	CreatedAt   *time.Time
 	UpdatedAt   *time.Time
+	// Version is incremented on every change of the book or its copies.
+	// Writes with a non-zero version apply only to that version of the book
+	Version int
+	// Copies is read-only, copies are managed on their own
+	Copies CopyCounts
+	// DeletedAt is set for books in the trash
+	DeletedAt *time.Time
 }
 
 type BookStatus string
@@ -23,4 +30,6 @@ type BookStatus string
 const (
 	BookStatusCheckedIn  BookStatus = "CheckedIn"
 	BookStatusCheckedOut BookStatus = "CheckedOut"
+	// BookStatusReadyForPickup books are kept for the member of the first hold in the queue
+	BookStatusReadyForPickup BookStatus = "ReadyForPickup"
 )
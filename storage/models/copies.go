@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Copy is a physical item of a book
+type Copy struct {
+	ID        uuid.UUID
+	BookID    uuid.UUID
+	Barcode   string
+	Location  string
+	Condition CopyCondition
+	Status    CopyStatus
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+type CopyCondition string
+
+const (
+	CopyConditionNew     CopyCondition = "New"
+	CopyConditionGood    CopyCondition = "Good"
+	CopyConditionFair    CopyCondition = "Fair"
+	CopyConditionPoor    CopyCondition = "Poor"
+	CopyConditionDamaged CopyCondition = "Damaged"
+)
+
+type CopyStatus string
+
+const (
+	CopyStatusAvailable CopyStatus = "Available"
+	CopyStatusInRepair  CopyStatus = "InRepair"
+	CopyStatusLost      CopyStatus = "Lost"
+	CopyStatusWithdrawn CopyStatus = "Withdrawn"
+)
+
+// CopyCounts rolls up the copies of a book
+type CopyCounts struct {
+	Total     int
+	Available int
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a change of a book waiting in the outbox to be delivered
+type Event struct {
+	// ID orders the events by the time they were committed
+	ID     int64
+	Type   EventType
+	BookID uuid.UUID
+	// Payload is the snapshot of the book after the change
+	Payload json.RawMessage
+	// Attempts is the number of failed deliveries
+	Attempts  int
+	CreatedAt *time.Time
+}
+
+type EventType string
+
+const (
+	EventBookCreated  EventType = "book.created"
+	EventBookUpdated  EventType = "book.updated"
+	EventBookDeleted  EventType = "book.deleted"
+	EventBookRestored EventType = "book.restored"
+)
+
+// EventWebhookTest is sent to a webhook on request to test it, it's not a change of a book
+const EventWebhookTest EventType = "webhook.test"
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Fine struct {
+	ID       uuid.UUID
+	LoanID   uuid.UUID
+	MemberID uuid.UUID
+	// Amount is in minor currency units
+	Amount    int64
+	Status    FineStatus
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+	PaidAt    *time.Time
+}
+
+type FineStatus string
+
+const (
+	FineStatusOpen FineStatus = "Open"
+	FineStatusPaid FineStatus = "Paid"
+)
+
+// FinePolicy defines how overdue loans are fined
+type FinePolicy struct {
+	// DailyRate is charged for every full day overdue past the grace period, in minor currency units
+	DailyRate int64
+	// GraceDays are full days overdue that are not charged
+	GraceDays int
+	// MaxAmount caps a single fine, 0 means no cap
+	MaxAmount int64
+}
+
+// Amount returns the fine for a loan that is overdue for the given duration
+func (p FinePolicy) Amount(overdue time.Duration) int64 {
+	days := int(overdue / (24 * time.Hour))
+	if days <= p.GraceDays {
+		return 0
+	}
+
+	amount := p.DailyRate * int64(days-p.GraceDays)
+	if p.MaxAmount > 0 && amount > p.MaxAmount {
+		amount = p.MaxAmount
+	}
+	return amount
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Hold struct {
+	ID       uuid.UUID
+	BookID   uuid.UUID
+	MemberID uuid.UUID
+	Status   HoldStatus
+	// PickupWindow is how long the book waits for the member once the hold is ready
+	PickupWindow time.Duration
+	CreatedAt    *time.Time
+	ReadyAt      *time.Time
+	ExpiresAt    *time.Time
+	ClosedAt     *time.Time
+}
+
+type HoldStatus string
+
+const (
+	// HoldStatusWaiting holds are queued until the book is returned
+	HoldStatusWaiting HoldStatus = "Waiting"
+	// HoldStatusReady holds wait for the member to pick the book up until they expire
+	HoldStatusReady     HoldStatus = "Ready"
+	HoldStatusFulfilled HoldStatus = "Fulfilled"
+	HoldStatusCancelled HoldStatus = "Cancelled"
+	HoldStatusExpired   HoldStatus = "Expired"
+)
+
+// IsActive tells whether the hold is still in the queue
+func (s HoldStatus) IsActive() bool {
+	return s == HoldStatusWaiting || s == HoldStatusReady
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Loan struct {
+	ID       uuid.UUID
+	BookID   uuid.UUID
+	MemberID *uuid.UUID
+	// Borrower is the member name at checkout
+	Borrower     string
+	CheckedOutAt *time.Time
+	DueAt        time.Time
+	ReturnedAt   *time.Time
+	// Period sets the due time of a checkout without DueAt from the checkout time, it's not stored
+	Period time.Duration
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Member struct {
+	ID             uuid.UUID
+	Name           string
+	Email          string
+	CardNumber     string
+	Status         MemberStatus
+	BorrowingLimit int
+	CreatedAt      *time.Time
+	UpdatedAt      *time.Time
+}
+
+type MemberStatus string
+
+const (
+	MemberStatusActive    MemberStatus = "Active"
+	MemberStatusSuspended MemberStatus = "Suspended"
+)
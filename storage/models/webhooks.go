@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a subscription of a URL to book events
+type Webhook struct {
+	ID  uuid.UUID
+	URL string
+	// EventTypes are the events delivered to the webhook, all events when empty
+	EventTypes []EventType
+	// Secret signs the deliveries
+	Secret    string
+	Active    bool
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+// Subscribes reports whether events of the type are delivered to the webhook
+func (w *Webhook) Subscribes(eventType EventType) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is an event to be delivered to a webhook, with the result of the last attempt
+type WebhookDelivery struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	// EventID is the id of the book event, nil for test deliveries
+	EventID   *int64
+	EventType EventType
+	// Payload is the body posted to the webhook
+	Payload  json.RawMessage
+	Status   WebhookDeliveryStatus
+	Attempts int
+	// NextAttemptAt is when a pending delivery is attempted next
+	NextAttemptAt *time.Time
+	// LastStatusCode is the response status of the last attempt, 0 when there was no response
+	LastStatusCode int
+	LastError      string
+	CreatedAt      *time.Time
+	UpdatedAt      *time.Time
+	DeliveredAt    *time.Time
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "Pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "Delivered"
+	// WebhookDeliveryDead deliveries ran out of attempts, they are kept in the log
+	WebhookDeliveryDead WebhookDeliveryStatus = "Dead"
+)
+
+// WebhookAttempt is the result of an attempt to deliver to a webhook
+type WebhookAttempt struct {
+	// Status of the delivery after the attempt
+	Status     WebhookDeliveryStatus
+	StatusCode int
+	Error      string
+	// RetryIn postpones the next attempt of a pending delivery
+	RetryIn time.Duration
+}
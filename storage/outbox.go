@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// auditEvents are the events of the audited changes
+var auditEvents = map[models.AuditAction]models.EventType{
+	models.AuditActionCreate:  models.EventBookCreated,
+	models.AuditActionUpdate:  models.EventBookUpdated,
+	models.AuditActionDelete:  models.EventBookDeleted,
+	models.AuditActionRestore: models.EventBookRestored,
+}
+
+func (s *storeImpl) PendingEvents(ctx context.Context, limit int) ([]*models.Event, error) {
+	rows, err := s.db.QueryContext(ctx, pendingEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.Event
+	for rows.Next() {
+		var (
+			event   models.Event
+			payload []byte
+		)
+		if err = rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.BookID,
+			&payload,
+			&event.Attempts,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *storeImpl) AckEvent(ctx context.Context, eventID int64) error {
+	_, err := s.db.ExecContext(ctx, ackEvent, eventID)
+	return err
+}
+
+func (s *storeImpl) RetryEvent(ctx context.Context, eventID int64, delay time.Duration, reason string) error {
+	_, err := s.db.ExecContext(ctx, retryEvent, eventID, delay.Seconds(), reason)
+	return err
+}
+
+func (s *storeImpl) FailEvent(ctx context.Context, eventID int64, reason string) error {
+	_, err := s.db.ExecContext(ctx, failEvent, eventID, reason)
+	return err
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+// BookField is a column of the book that can be patched
+type BookField string
+
+const (
+	BookFieldTitle       BookField = "title"
+	BookFieldAuthor      BookField = "author"
+	BookFieldPublisher   BookField = "publisher"
+	BookFieldPublishDate BookField = "publishDate"
+	BookFieldRating      BookField = "rating"
+)
+
+var ErrUnknownBookField = newError(KindInvalid, "unknown book field")
+
+// bookFieldColumns maps patchable fields to their columns and values of the book
+var bookFieldColumns = map[BookField]struct {
+	column string
+	value  func(book *models.Book) interface{}
+}{
+	BookFieldTitle:       {"title", func(b *models.Book) interface{} { return b.Title }},
+	BookFieldAuthor:      {"author", func(b *models.Book) interface{} { return b.Author }},
+	BookFieldPublisher:   {"publisher", func(b *models.Book) interface{} { return b.Publisher }},
+	BookFieldPublishDate: {"publish_date", func(b *models.Book) interface{} { return b.PublishDate }},
+	BookFieldRating:      {"rating", func(b *models.Book) interface{} { return b.Rating }},
+}
+
+// PatchBook updates only the given fields of the book and returns the updated book.
+// Without fields the book is returned as is
+func (s *storeImpl) PatchBook(ctx context.Context, book *models.Book, fields []BookField) (*models.Book, error) {
+	if len(fields) == 0 {
+		current, err := s.GetBook(ctx, book.ID)
+		if err == nil && book.Version != 0 && current.Version != book.Version {
+			return nil, ErrBookVersionMismatch
+		}
+		return current, err
+	}
+
+	sets := make([]string, 0, len(fields)+2)
+	args := []interface{}{book.ID, book.Version}
+	for _, field := range fields {
+		col, ok := bookFieldColumns[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownBookField, field)
+		}
+		args = append(args, col.value(book))
+		sets = append(sets, fmt.Sprintf("%s = $%d", col.column, len(args)))
+	}
+	sets = append(sets, "updated_at = CURRENT_TIMESTAMP", "version = version + 1")
+
+	stmt := fmt.Sprintf(patchBook, strings.Join(sets, ", "))
+	var patched *models.Book
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockAuditedBook(ctx, tx, book.ID)
+		if err != nil {
+			return err
+		}
+		if err = checkBookVersion(before, book.Version); err != nil {
+			return err
+		}
+
+		if patched, err = scanBook(tx.QueryRowContext(ctx, stmt, args...)); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, models.AuditActionUpdate, book.ID, before)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return patched, nil
+}
@@ -1,27 +1,35 @@
 package storage
 
 const (
-	initSql = `
-CREATE TABLE books (
-	id				UUID			DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
-	title			VARCHAR(255)	NOT NULL,
-	author			VARCHAR(255)	NOT NULL,
-	publisher		VARCHAR(255)	NULL,
-	publish_date	DATE			NULL,
-	rating			INTEGER			NULL,
-	status			VARCHAR(64)		NOT NULL,
-
-	created_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	updated_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
+	createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version			BIGINT			NOT NULL PRIMARY KEY,
+	name			VARCHAR(255)	NOT NULL,
+	checksum		VARCHAR(64)		NOT NULL,
+	applied_at		TIMESTAMP		NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 `
 
-	booksTableExists = `
-SELECT EXISTS (
-   SELECT FROM information_schema.tables 
-   WHERE  table_schema = 'public'
-   AND    table_name   = 'books'
-   );
+	lockMigrations = `SELECT pg_advisory_lock($1)`
+
+	unlockMigrations = `SELECT pg_advisory_unlock($1)`
+
+	listMigrations = `
+SELECT version, checksum, applied_at
+FROM schema_migrations
+ORDER BY version
+`
+
+	insertMigration = `
+INSERT INTO schema_migrations
+	(version, name, checksum)
+VALUES
+	($1, $2, $3)
+`
+
+	deleteMigration = `
+DELETE FROM schema_migrations
+WHERE version = $1
 `
 
 	createBook = `
@@ -33,30 +41,577 @@ RETURNING
 	id
 `
 
+	// deleteBook, updateBook and patchBook change any version of the book when the expected version is 0.
+	// Deleted books are moved to the trash, every other query skips them
 	deleteBook = `
-DELETE FROM books
-WHERE id = $1
+UPDATE books
+SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND ($2::integer = 0 OR version = $2::integer)
 `
 
 	updateBook = `
 UPDATE books
-SET title = $2, author = $3, publisher = $4, publish_date = $5, rating = $6, status = $7,
-	updated_at = CURRENT_TIMESTAMP
+SET title = $2, author = $3, publisher = $4, publish_date = $5, rating = $6,
+	updated_at = CURRENT_TIMESTAMP, version = version + 1
 WHERE 
-	id = $1
+	id = $1 AND deleted_at IS NULL AND ($7::integer = 0 OR version = $7::integer)
+RETURNING
+	version
+`
+
+	// bookCopyCounts selects the total and the available number of copies of every book row
+	bookCopyCounts = `
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id),
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id AND copies.status = 'Available')`
+
+	// patchBook is completed with the SET list of the patched columns
+	patchBook = `
+UPDATE books
+SET %s
+WHERE id = $1 AND deleted_at IS NULL AND ($2::integer = 0 OR version = $2::integer)
+RETURNING
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `
 `
 
 	getBook = `
 SELECT 
-	id, title, author, publisher, publish_date, rating, status, created_at, updated_at
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `
 FROM books
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
 
+	// listBooks and countBooks are completed with conditions built from ListBooksQuery
 	listBooks = `
 SELECT 
-	id, title, author, publisher, publish_date, rating, status, created_at, updated_at
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `
+FROM books
+`
+
+	countBooks = `
+SELECT COUNT(*)
+FROM books
+`
+
+	searchBooks = `
+SELECT 
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `,
+	ts_rank(search_vector, query) AS rank
+FROM books, to_tsquery('simple', $1) AS query
+WHERE search_vector @@ query AND deleted_at IS NULL
+ORDER BY rank DESC, created_at DESC
+LIMIT $2
+`
+
+	lockBookStatus = `
+SELECT status
+FROM books
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE
+`
+
+	setBookStatus = `
+UPDATE books
+SET status = $2, updated_at = CURRENT_TIMESTAMP, version = version + 1
+WHERE id = $1
+`
+
+	// bumpBookVersion marks a change of the book resource outside the books row, e.g. of its copies
+	bumpBookVersion = `
+UPDATE books
+SET version = version + 1
+WHERE id = $1
+`
+
+	bookExists = `
+SELECT EXISTS (SELECT FROM books WHERE id = $1 AND deleted_at IS NULL)
+`
+
+	bookHistoryExists = `
+SELECT EXISTS (SELECT FROM books WHERE id = $1) OR EXISTS (SELECT FROM book_audit WHERE book_id = $1)
+`
+
+	// auditBook selects the columns of getBook followed by deleted_at, including books in the trash
+	auditBook = `
+SELECT
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `,
+	deleted_at
+FROM books
+WHERE id = $1
+`
+
+	lockAuditBook = auditBook + `FOR UPDATE
+`
+
+	createAuditEntry = `
+INSERT INTO book_audit
+	(book_id, action, actor, request_id, before, after)
+VALUES
+	($1, $2, $3, $4, $5, $6)
+`
+
+	// listAudit is completed with conditions built from AuditQuery
+	listAudit = `
+SELECT
+	id, book_id, action, actor, request_id, before, after, created_at
+FROM book_audit
+`
+
+	// importedBooks reads the imported books back to record them in the audit log
+	importedBooks = `
+SELECT
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `
+FROM books
+WHERE id = ANY ($1::uuid[])
+`
+
+	// importEvents queues the events of the audit entries of imported books in the order of the entries,
+	// notifying the listeners of eventsChannel like createEvent
+	importEvents = `
+WITH event AS (
+	INSERT INTO outbox
+		(event_type, book_id, payload)
+	SELECT
+		$1::text, book_audit.book_id, book_audit.after
+	FROM unnest($2::uuid[]) WITH ORDINALITY AS imported (id, n)
+	JOIN book_audit ON book_audit.id = imported.id
+	ORDER BY imported.n
+	RETURNING
+		id, event_type, book_id, payload, created_at
+)
+SELECT pg_notify('` + eventsChannel + `', row_to_json(event)::text)
+FROM event
+`
+
+	// createEvent notifies the listeners of eventsChannel with the event once the transaction commits
+	createEvent = `
+WITH event AS (
+	INSERT INTO outbox
+		(event_type, book_id, payload)
+	VALUES
+		($1, $2, $3)
+	RETURNING
+		id, event_type, book_id, payload, created_at
+)
+SELECT pg_notify('` + eventsChannel + `', row_to_json(event)::text)
+FROM event
+`
+
+	pendingEvents = `
+SELECT
+	id, event_type, book_id, payload, attempts, created_at
+FROM outbox
+WHERE status = 'Pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY id
+LIMIT $1
+`
+
+	ackEvent = `
+DELETE FROM outbox
+WHERE id = $1
+`
+
+	// retryEvent schedules the next attempt of the event in $2 seconds
+	retryEvent = `
+UPDATE outbox
+SET attempts = attempts + 1, last_error = $3,
+	next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+WHERE id = $1
+`
+
+	failEvent = `
+UPDATE outbox
+SET status = 'Failed', attempts = attempts + 1, last_error = $2
+WHERE id = $1
+`
+
+	createWebhook = `
+INSERT INTO webhooks
+	(url, event_types, secret, active)
+VALUES
+	($1, $2, $3, $4)
+RETURNING
+	id
+`
+
+	// updateWebhook keeps the secret when $4 is empty and the active state when $5 is null
+	updateWebhook = `
+UPDATE webhooks
+SET url = $2, event_types = $3, secret = COALESCE(NULLIF($4, ''), secret), active = COALESCE($5, active),
+	updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+	deleteWebhook = `
+DELETE FROM webhooks
+WHERE id = $1
+`
+
+	getWebhook = `
+SELECT
+	id, url, event_types, secret, active, created_at, updated_at
+FROM webhooks
+WHERE id = $1
+`
+
+	listWebhooks = `
+SELECT
+	id, url, event_types, secret, active, created_at, updated_at
+FROM webhooks
+ORDER BY created_at, id
+`
+
+	webhookExists = `
+SELECT EXISTS (SELECT FROM webhooks WHERE id = $1)
+`
+
+	enqueueWebhookDeliveries = `
+INSERT INTO webhook_deliveries
+	(webhook_id, event_id, event_type, payload)
+SELECT
+	id, $1::bigint, $2::text, $3::jsonb
+FROM webhooks
+WHERE active AND (cardinality(event_types) = 0 OR $2::text = ANY (event_types))
+ON CONFLICT (webhook_id, event_id) DO NOTHING
+`
+
+	createWebhookDelivery = `
+INSERT INTO webhook_deliveries
+	(webhook_id, event_id, event_type, payload)
+VALUES
+	($1, $2, $3, $4)
+RETURNING
+	` + webhookDeliveryColumns + `
+`
+
+	pendingWebhookDeliveries = `
+SELECT
+	` + webhookDeliveryColumns + `
+FROM webhook_deliveries
+WHERE status = 'Pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+	AND EXISTS (SELECT FROM webhooks WHERE webhooks.id = webhook_id AND active)
+	AND webhook_id <> ALL ($2::uuid[])
+ORDER BY next_attempt_at, created_at
+LIMIT $1
+`
+
+	// recordWebhookAttempt schedules the next attempt of a pending delivery in $5 seconds
+	recordWebhookAttempt = `
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, last_status_code = NULLIF($3, 0), last_error = NULLIF($4, ''),
+	next_attempt_at = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second',
+	delivered_at = CASE WHEN $2 = 'Delivered' THEN CURRENT_TIMESTAMP END,
+	updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+RETURNING
+	` + webhookDeliveryColumns + `
+`
+
+	// listWebhookDeliveries is completed with conditions built from WebhookDeliveryQuery
+	listWebhookDeliveries = `
+SELECT
+	` + webhookDeliveryColumns + `
+FROM webhook_deliveries
+`
+
+	webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, updated_at, delivered_at`
+
+	listTrash = `
+SELECT
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `,
+	deleted_at
 FROM books
-ORDER BY created_at DESC
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC, id
+`
+
+	restoreBook = `
+UPDATE books
+SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING
+	id, title, author, publisher, publish_date, rating, status, created_at, updated_at, version,
+	` + bookCopyCounts + `
+`
+
+	// purgeBooks deletes the books in the trash for longer than $1 seconds, with their rows
+	// of the other tables
+	purgeBooks = `
+DELETE FROM books
+WHERE deleted_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+`
+
+	// createLoan is due at $4 or $5 seconds after the checkout. Both are stored in the session time zone,
+	// like the CURRENT_TIMESTAMP the due time is compared with
+	createLoan = `
+INSERT INTO loans
+	(book_id, member_id, borrower, due_at)
+VALUES
+	($1, $2, $3, COALESCE($4::timestamptz, CURRENT_TIMESTAMP + $5 * INTERVAL '1 second'))
+RETURNING
+	id, checked_out_at, due_at
+`
+
+	returnLoan = `
+UPDATE loans
+SET returned_at = CURRENT_TIMESTAMP
+WHERE book_id = $1 AND returned_at IS NULL
+RETURNING
+	id, book_id, member_id, borrower, checked_out_at, due_at, returned_at
+`
+
+	listLoans = `
+SELECT
+	id, book_id, member_id, borrower, checked_out_at, due_at, returned_at
+FROM loans
+WHERE book_id = $1
+ORDER BY checked_out_at DESC
+`
+
+	createMember = `
+INSERT INTO members
+	(name, email, card_number, status, borrowing_limit)
+VALUES
+	($1, $2, $3, $4, $5)
+RETURNING
+	id
+`
+
+	updateMember = `
+UPDATE members
+SET name = $2, email = $3, card_number = $4, status = $5, borrowing_limit = $6,
+	updated_at = CURRENT_TIMESTAMP
+WHERE
+	id = $1
+`
+
+	deleteMember = `
+DELETE FROM members
+WHERE id = $1
+`
+
+	getMember = `
+SELECT
+	id, name, email, card_number, status, borrowing_limit, created_at, updated_at
+FROM members
+WHERE id = $1
+`
+
+	lockMember = `
+SELECT
+	id, name, email, card_number, status, borrowing_limit, created_at, updated_at
+FROM members
+WHERE id = $1
+FOR UPDATE
+`
+
+	listMembers = `
+SELECT
+	id, name, email, card_number, status, borrowing_limit, created_at, updated_at
+FROM members
+ORDER BY name COLLATE "C", id
+`
+
+	countActiveLoans = `
+SELECT COUNT(*)
+FROM loans
+WHERE member_id = $1 AND returned_at IS NULL
+`
+
+	createHold = `
+INSERT INTO holds
+	(book_id, member_id, status, pickup_window)
+VALUES
+	($1, $2, $3, $4)
+RETURNING
+	id, created_at
+`
+
+	getHold = `
+SELECT
+	id, book_id, member_id, status, pickup_window, created_at, ready_at, expires_at, closed_at
+FROM holds
+WHERE id = $1 AND book_id = $2
+`
+
+	listActiveHolds = `
+SELECT
+	id, book_id, member_id, status, pickup_window, created_at, ready_at, expires_at, closed_at
+FROM holds
+WHERE book_id = $1 AND status IN ('Waiting', 'Ready')
+ORDER BY created_at, id
+`
+
+	closeHold = `
+UPDATE holds
+SET status = $2, closed_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+	expireReadyHolds = `
+UPDATE holds
+SET status = 'Expired', closed_at = CURRENT_TIMESTAMP
+WHERE book_id = $1 AND status = 'Ready' AND expires_at <= CURRENT_TIMESTAMP
+`
+
+	listBooksWithExpiredHolds = `
+SELECT DISTINCT book_id
+FROM holds
+WHERE status = 'Ready' AND expires_at <= CURRENT_TIMESTAMP
+`
+
+	promoteNextHold = `
+UPDATE holds
+SET status = 'Ready', ready_at = CURRENT_TIMESTAMP,
+	expires_at = CURRENT_TIMESTAMP + pickup_window * INTERVAL '1 second'
+WHERE id = (
+	SELECT id
+	FROM holds
+	WHERE book_id = $1 AND status = 'Waiting'
+	ORDER BY created_at, id
+	LIMIT 1
+)
+`
+
+	readyHold = `
+SELECT
+	id, book_id, member_id, status, pickup_window, created_at, ready_at, expires_at, closed_at
+FROM holds
+WHERE book_id = $1 AND status = 'Ready'
+`
+
+	memberHoldExists = `
+SELECT EXISTS (
+	SELECT FROM holds
+	WHERE book_id = $1 AND member_id = $2 AND status IN ('Waiting', 'Ready')
+)
+`
+
+	// bookHoldExists skips ready holds past their pickup window, they are expired on the next settle
+	bookHoldExists = `
+SELECT EXISTS (
+	SELECT FROM holds
+	WHERE book_id = $1 AND (status = 'Waiting' OR (status = 'Ready' AND expires_at > CURRENT_TIMESTAMP))
+)
+`
+
+	countMemberActiveHolds = `
+SELECT COUNT(*)
+FROM holds
+WHERE member_id = $1 AND status IN ('Waiting', 'Ready')
+`
+
+	activeLoanMember = `
+SELECT member_id
+FROM loans
+WHERE book_id = $1 AND returned_at IS NULL
+`
+
+	// accrueFines creates or updates open fines of overdue loans according to models.FinePolicy:
+	// $1 daily rate, $2 grace days, $3 max amount. Loans returned since their fine was last
+	// updated get the final amount, other returned loans are skipped
+	accrueFines = `
+INSERT INTO fines (loan_id, member_id, amount, status)
+SELECT
+	id, member_id,
+	CASE
+		WHEN $3::bigint > 0 THEN LEAST($3::bigint, $1::bigint * (days - $2::integer))
+		ELSE $1::bigint * (days - $2::integer)
+	END,
+	'Open'
+FROM (
+	SELECT
+		l.id, l.member_id,
+		FLOOR(EXTRACT(EPOCH FROM COALESCE(l.returned_at, CURRENT_TIMESTAMP) - l.due_at) / 86400)::integer AS days
+	FROM loans l
+	LEFT JOIN fines f ON f.loan_id = l.id
+	WHERE l.member_id IS NOT NULL
+		AND l.due_at < COALESCE(l.returned_at, CURRENT_TIMESTAMP)
+		AND (f.id IS NULL OR (f.status = 'Open' AND (l.returned_at IS NULL OR f.updated_at < l.returned_at)))
+) overdue
+WHERE days > $2::integer
+ON CONFLICT (loan_id) DO UPDATE
+SET amount = EXCLUDED.amount, updated_at = CURRENT_TIMESTAMP
+WHERE fines.status = 'Open'
+`
+
+	listMemberFines = `
+SELECT
+	id, loan_id, member_id, amount, status, created_at, updated_at, paid_at
+FROM fines
+WHERE member_id = $1
+ORDER BY created_at DESC, id
+`
+
+	lockFine = `
+SELECT
+	f.id, f.loan_id, f.member_id, f.amount, f.status, f.created_at, f.updated_at, f.paid_at,
+	l.returned_at IS NOT NULL
+FROM fines f
+JOIN loans l ON l.id = f.loan_id
+WHERE f.id = $1
+FOR UPDATE OF f
+`
+
+	payFine = `
+UPDATE fines
+SET status = 'Paid', paid_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+RETURNING
+	id, loan_id, member_id, amount, status, created_at, updated_at, paid_at
+`
+
+	memberExists = `
+SELECT EXISTS (SELECT FROM members WHERE id = $1)
+`
+
+	tryAdvisoryLock = `SELECT pg_try_advisory_lock($1)`
+
+	advisoryUnlock = `SELECT pg_advisory_unlock($1)`
+
+	createCopy = `
+INSERT INTO copies
+	(book_id, barcode, location, condition, status)
+VALUES
+	($1, $2, $3, $4, $5)
+RETURNING
+	id
+`
+
+	// copies of books in the trash are not found until the book is restored
+	updateCopy = `
+UPDATE copies
+SET barcode = $3, location = $4, condition = $5, status = $6,
+	updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND book_id = $2 AND ` + copyBookLive + `
+`
+
+	deleteCopy = `
+DELETE FROM copies
+WHERE id = $1 AND book_id = $2 AND ` + copyBookLive + `
+`
+
+	getCopy = `
+SELECT
+	id, book_id, barcode, location, condition, status, created_at, updated_at
+FROM copies
+WHERE id = $1 AND book_id = $2 AND ` + copyBookLive + `
+`
+
+	copyBookLive = `EXISTS (SELECT FROM books WHERE books.id = copies.book_id AND deleted_at IS NULL)`
+
+	listCopies = `
+SELECT
+	id, book_id, barcode, location, condition, status, created_at, updated_at
+FROM copies
+WHERE book_id = $1
+ORDER BY barcode COLLATE "C", id
 `
 )
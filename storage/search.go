@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/alexkaplun/books-test/storage/models"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+
+	highlightStart = "<b>"
+	highlightStop  = "</b>"
+)
+
+var ErrEmptySearchQuery = newError(KindInvalid, "search query has no words")
+
+// searchWeights mirror the default ts_rank weights of the title (A), author (B)
+// and publisher (C) parts of books.search_vector
+var searchWeights = struct{ title, author, publisher float64 }{1.0, 0.4, 0.2}
+
+type SearchBooksQuery struct {
+	// Query is free text; every word of it must prefix match a word of the title, author or publisher
+	Query string
+	// Limit defaults to DefaultSearchLimit and is capped by MaxSearchLimit
+	Limit int
+}
+
+type BookSearchHit struct {
+	Book       *models.Book
+	Rank       float64
+	Highlights BookHighlights
+}
+
+// BookHighlights hold the searched fields as HTML, escaped, with the matching words wrapped in <b></b>
+type BookHighlights struct {
+	Title     string
+	Author    string
+	Publisher string
+}
+
+func (q SearchBooksQuery) normalize() (SearchBooksQuery, []string, error) {
+	tokens := searchTokens(q.Query)
+	if len(tokens) == 0 {
+		return q, nil, ErrEmptySearchQuery
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+
+	return q, tokens, nil
+}
+
+// tsQuery builds a to_tsquery('simple', ...) expression requiring all tokens as prefixes.
+// Tokens contain only letters and digits, so no escaping is needed
+func tsQuery(tokens []string) string {
+	parts := make([]string, len(tokens))
+	for i, token := range tokens {
+		parts[i] = token + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// searchTokens splits text into lowercase words of letters and digits
+func searchTokens(text string) []string {
+	spans := wordSpans(text)
+	tokens := make([]string, len(spans))
+	for i, span := range spans {
+		tokens[i] = strings.ToLower(text[span[0]:span[1]])
+	}
+	return tokens
+}
+
+// wordSpans returns byte offsets of the words in text
+func wordSpans(text string) [][2]int {
+	var (
+		spans [][2]int
+		start = -1
+	)
+	for i, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWordRune && start < 0:
+			start = i
+		case !isWordRune && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// naiveSearch is the storage independent equivalent of the Postgres full-text search,
+// used by backends without one. Books are expected in the created_at DESC order
+func naiveSearch(books []*models.Book, query SearchBooksQuery, tokens []string) []*BookSearchHit {
+	var hits []*BookSearchHit
+	for _, book := range books {
+		title, titleMatches := highlight(book.Title, tokens)
+		author, authorMatches := highlight(book.Author, tokens)
+		publisher, publisherMatches := highlight(book.Publisher, tokens)
+
+		matched := make(map[string]bool)
+		for _, set := range []map[string]bool{titleMatches, authorMatches, publisherMatches} {
+			for token := range set {
+				matched[token] = true
+			}
+		}
+		if len(matched) != len(uniqueTokens(tokens)) {
+			continue
+		}
+
+		hits = append(hits, &BookSearchHit{
+			Book: book,
+			Rank: searchWeights.title*float64(len(titleMatches)) +
+				searchWeights.author*float64(len(authorMatches)) +
+				searchWeights.publisher*float64(len(publisherMatches)),
+			Highlights: BookHighlights{
+				Title:     title,
+				Author:    author,
+				Publisher: publisher,
+			},
+		})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Rank > hits[j].Rank
+	})
+
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+
+	return hits
+}
+
+// highlight escapes text as HTML, wraps the words prefixed by any of the tokens and returns
+// the matched tokens
+func highlight(text string, tokens []string) (string, map[string]bool) {
+	var (
+		b       strings.Builder
+		matched = make(map[string]bool)
+		last    int
+	)
+	for _, span := range wordSpans(text) {
+		word := strings.ToLower(text[span[0]:span[1]])
+
+		hit := false
+		for _, token := range tokens {
+			if strings.HasPrefix(word, token) {
+				matched[token] = true
+				hit = true
+			}
+		}
+		if !hit {
+			continue
+		}
+
+		b.WriteString(html.EscapeString(text[last:span[0]]))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(text[span[0]:span[1]]))
+		b.WriteString(highlightStop)
+		last = span[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+
+	return b.String(), matched
+}
+
+func uniqueTokens(tokens []string) map[string]bool {
+	set := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		set[token] = true
+	}
+	return set
+}
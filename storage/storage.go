@@ -3,28 +3,180 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/alexkaplun/books-test/storage/migrations"
 	"github.com/alexkaplun/books-test/storage/models"
 	"github.com/google/uuid"
 
 	_ "github.com/lib/pq"
 )
 
+// Storage records the changes of books in the audit log, with the AuditInfo of the context
 type Storage interface {
+	MemberStorage
+	CopyStorage
+	OutboxStorage
+	EventFeed
+	WebhookStorage
+	HealthStorage
+
 	CreateBook(ctx context.Context, book *models.Book) (*uuid.UUID, error)
-	DeleteBook(ctx context.Context, bookID uuid.UUID) error
+	// ImportBooks creates all the books or none of them and sets their ids. Every book is recorded
+	// as created by CreateBook, the books are inserted at once
+	ImportBooks(ctx context.Context, books []*models.Book) error
+	// DeleteBook moves the book of the given version, or of any version when it is 0, to the trash.
+	// Books in the trash are not found by the other methods until restored.
+	// The conditional writes of books fail with ErrBookVersionMismatch when the book has changed
+	DeleteBook(ctx context.Context, bookID uuid.UUID, version int) error
+	// UpdateBook stores the new version of the book in book.Version
 	UpdateBook(ctx context.Context, book *models.Book) error
+	// PatchBook updates only the given fields of the book, leaving concurrent changes
+	// of other fields intact, and returns the updated book
+	PatchBook(ctx context.Context, book *models.Book, fields []BookField) (*models.Book, error)
 	GetBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error)
-	ListBooks(ctx context.Context) ([]*models.Book, error)
+	ListBooks(ctx context.Context, query ListBooksQuery) (*ListBooksResult, error)
+	// ExportBooks calls fn with every book matching the filters of the query, in its order and starting
+	// after its cursor. Limit and WithTotal are ignored. Books are read from a consistent snapshot a batch
+	// at a time, the export stops at the first error of fn and returns it
+	ExportBooks(ctx context.Context, query ListBooksQuery, fn func(book *models.Book) error) error
+	SearchBooks(ctx context.Context, query SearchBooksQuery) ([]*BookSearchHit, error)
+
+	// ListTrash returns the books in the trash, latest deleted first
+	ListTrash(ctx context.Context) ([]*models.Book, error)
+	// RestoreBook moves the book back from the trash and returns it
+	RestoreBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error)
+	// PurgeBooks permanently deletes the books in the trash for longer than the retention,
+	// with their loans, holds, fines and copies. It returns the number of books purged
+	PurgeBooks(ctx context.Context, retention time.Duration) (int, error)
+
+	// ListBookHistory returns a page of the audit log of the book, latest first. It's kept after the book is purged
+	ListBookHistory(ctx context.Context, bookID uuid.UUID, query AuditQuery) (*ListAuditResult, error)
+	// ListAudit returns a page of the audit log of all books, latest first
+	ListAudit(ctx context.Context, query AuditQuery) (*ListAuditResult, error)
+
+	// CheckoutBook creates the loan of the member and marks the book checked out. The loan is due
+	// at DueAt or, without it, Period after the checkout. The member must be active and below the borrowing limit
+	CheckoutBook(ctx context.Context, loan *models.Loan) (*models.Loan, error)
+	// ReturnBook closes the active loan of the book and marks it checked in
+	ReturnBook(ctx context.Context, bookID uuid.UUID) (*models.Loan, error)
+	// ListLoans returns the loan history of the book, latest first
+	ListLoans(ctx context.Context, bookID uuid.UUID) ([]*models.Loan, error)
+
+	// PlaceHold queues the member for a book that is not available
+	PlaceHold(ctx context.Context, hold *models.Hold) (*models.Hold, error)
+	// CancelHold removes the hold from the queue. A cancelled ready hold passes the book to the next member
+	CancelHold(ctx context.Context, bookID, holdID uuid.UUID) (*models.Hold, error)
+	// ListHolds returns the active holds of the book in queue order
+	ListHolds(ctx context.Context, bookID uuid.UUID) ([]*models.Hold, error)
+	// ExpireHolds expires the ready holds past their pickup window and passes the books on.
+	// It returns the number of books settled
+	ExpireHolds(ctx context.Context) (int, error)
+
+	// AccrueFines fines every overdue loan of a member by the policy. Fines of active loans grow
+	// on every run and are settled once the book is returned. It returns the number of fines updated
+	AccrueFines(ctx context.Context, policy models.FinePolicy) (int, error)
+	// ListMemberFines returns the fines of the member, latest first
+	ListMemberFines(ctx context.Context, memberID uuid.UUID) ([]*models.Fine, error)
+	// PayFine marks the fine paid. Fines of books not returned yet can't be paid
+	PayFine(ctx context.Context, fineID uuid.UUID) (*models.Fine, error)
+
+	// Close closes the connections to the database, the storage can't be used afterwards
+	Close() error
+	// DBStats returns the statistics of the database connection pool, zero without a database
+	DBStats() sql.DBStats
+
+	// TryLock takes the exclusive lock of the key shared by all replicas without waiting.
+	// When acquired, the lock is held until unlock is called
+	TryLock(ctx context.Context, key int64) (unlock func(), acquired bool, err error)
+}
+
+type MemberStorage interface {
+	CreateMember(ctx context.Context, member *models.Member) (*uuid.UUID, error)
+	UpdateMember(ctx context.Context, member *models.Member) error
+	// DeleteMember fails with ErrMemberHasLoans while the member has books checked out
+	// and with ErrMemberHasHolds while the member has active holds
+	DeleteMember(ctx context.Context, memberID uuid.UUID) error
+	GetMember(ctx context.Context, memberID uuid.UUID) (*models.Member, error)
+	ListMembers(ctx context.Context) ([]*models.Member, error)
+}
+
+type CopyStorage interface {
+	// CreateCopy adds a copy to the book. Barcodes are unique across all books
+	CreateCopy(ctx context.Context, item *models.Copy) (*uuid.UUID, error)
+	UpdateCopy(ctx context.Context, item *models.Copy) error
+	DeleteCopy(ctx context.Context, bookID, copyID uuid.UUID) error
+	GetCopy(ctx context.Context, bookID, copyID uuid.UUID) (*models.Copy, error)
+	// ListCopies returns the copies of the book ordered by barcode
+	ListCopies(ctx context.Context, bookID uuid.UUID) ([]*models.Copy, error)
+}
+
+// OutboxStorage holds the events of book changes, committed with the changes, until they are delivered
+type OutboxStorage interface {
+	// PendingEvents returns up to limit events due for delivery, oldest first
+	PendingEvents(ctx context.Context, limit int) ([]*models.Event, error)
+	// AckEvent removes the delivered event from the outbox
+	AckEvent(ctx context.Context, eventID int64) error
+	// RetryEvent records a failed delivery and postpones the next one by the delay
+	RetryEvent(ctx context.Context, eventID int64, delay time.Duration, reason string) error
+	// FailEvent records the last failed delivery, the event is not delivered anymore
+	FailEvent(ctx context.Context, eventID int64, reason string) error
+}
+
+// EventFeed streams the events of book changes committed by all replicas as they happen
+type EventFeed interface {
+	// ListenEvents calls fn with every event committed from now on until the context is done.
+	// It returns once listening, fn and lost are called from another goroutine and must not block.
+	// Events committed while the connection to the database is reestablished are missed,
+	// lost is called once it's back
+	ListenEvents(ctx context.Context, fn func(event *models.Event), lost func()) error
+}
+
+// WebhookStorage holds the webhooks subscribed to book events and the log of their deliveries
+type WebhookStorage interface {
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) (*uuid.UUID, error)
+	// UpdateWebhook keeps the secret of the webhook when webhook.Secret is empty
+	// and its active state when active is nil, webhook.Active is ignored
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook, active *bool) error
+	// DeleteWebhook deletes the webhook with its delivery log
+	DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error
+	GetWebhook(ctx context.Context, webhookID uuid.UUID) (*models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]*models.Webhook, error)
+
+	// EnqueueWebhookDeliveries queues the delivery of the event to every active webhook subscribed to it.
+	// An event is queued once per webhook, however often it's enqueued. It returns the number of deliveries queued
+	EnqueueWebhookDeliveries(ctx context.Context, delivery *models.WebhookDelivery) (int, error)
+	// CreateWebhookDelivery queues a single delivery to the webhook of delivery.WebhookID
+	CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error)
+	// PendingWebhookDeliveries returns up to limit deliveries due to active webhooks, oldest first.
+	// The deliveries of the skipped webhooks are left out
+	PendingWebhookDeliveries(ctx context.Context, limit int, skip []uuid.UUID) ([]*models.WebhookDelivery, error)
+	// RecordWebhookAttempt records the attempt to deliver and returns the updated delivery
+	RecordWebhookAttempt(ctx context.Context, deliveryID uuid.UUID, attempt models.WebhookAttempt) (*models.WebhookDelivery, error)
+	// ListWebhookDeliveries returns the delivery log of the webhook, latest first
+	ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, query WebhookDeliveryQuery) ([]*models.WebhookDelivery, error)
+}
+
+// HealthStorage checks the database for the health of the service
+type HealthStorage interface {
+	// Ping makes sure the database is reachable
+	Ping(ctx context.Context) error
+	// CheckMigrations fails with ErrMigrationsPending until all known migrations are applied
+	CheckMigrations(ctx context.Context) error
 }
 
 type Params struct {
 	ConnString string
+	// SkipMigrations disables applying pending migrations on start
+	SkipMigrations bool
 }
 
 type storeImpl struct {
 	db *sql.DB
+	// connString opens the connections listening to notifications
+	connString string
+	migrator   *Migrator
 }
 
 func NewPostgres(params Params) (Storage, error) {
@@ -33,32 +185,54 @@ func NewPostgres(params Params) (Storage, error) {
 		return nil, err
 	}
 
+	migrator, err := NewMigrator(db, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
 	store := &storeImpl{
-		db: db,
+		db:         db,
+		connString: params.ConnString,
+		migrator:   migrator,
 	}
 
-	if err = store.init(); err != nil {
-		return nil, err
+	if !params.SkipMigrations {
+		if err = store.init(); err != nil {
+			return nil, classify(err)
+		}
 	}
 
-	return store, nil
+	return &classifiedStore{store: store}, nil
+}
+
+func (s *storeImpl) Close() error {
+	return s.db.Close()
+}
+
+func (s *storeImpl) DBStats() sql.DBStats {
+	return s.db.Stats()
 }
 
 func (s *storeImpl) init() error {
-	// allow up to 5 seconds to init the database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// allow up to 30 seconds to migrate the database, including waiting for other replicas
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	tableExists, err := s.initialized(ctx)
+	_, err := s.migrator.Up(ctx)
+	return err
+}
+
+func (s *storeImpl) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *storeImpl) CheckMigrations(ctx context.Context) error {
+	pending, err := s.migrator.Pending(ctx)
 	if err != nil {
 		return err
 	}
-
-	if !tableExists {
-		if err = s.createBooksTable(ctx); err != nil {
-			return err
-		}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrMigrationsPending, len(pending), len(s.migrator.migrations))
 	}
-
 	return nil
 }
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores returns the stores the tests of both backends run against, the in-memory one
+// and the Postgres one when BOOKS_TEST_DB is set to its connection string,
+// e.g. BOOKS_TEST_DB="host=localhost port=5433 user=postgres password=postgres dbname=postgres sslmode=disable"
+func testStores(t *testing.T) map[string]Storage {
+	stores := map[string]Storage{"memory": NewMemory()}
+
+	if connString := os.Getenv("BOOKS_TEST_DB"); connString != "" {
+		store, err := NewPostgres(Params{ConnString: connString})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			store.Close()
+		})
+		stores["postgres"] = store
+	}
+	return stores
+}
+
+func TestPatchBookVersion(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			id, err := s.CreateBook(ctx, &models.Book{Title: "patch", Author: "a", Rating: 1, Status: models.BookStatusCheckedIn})
+			require.NoError(t, err)
+			book, err := s.GetBook(ctx, *id)
+			require.NoError(t, err)
+			require.Equal(t, 1, book.Version)
+
+			patched, err := s.PatchBook(ctx, &models.Book{ID: *id, Title: "first", Version: 1}, []BookField{BookFieldTitle})
+			require.NoError(t, err)
+			assert.Equal(t, 2, patched.Version)
+
+			// a second patch of the same version is a lost update
+			_, err = s.PatchBook(ctx, &models.Book{ID: *id, Title: "second", Version: 1}, []BookField{BookFieldTitle})
+			assert.Equal(t, ErrBookVersionMismatch, err)
+
+			book, err = s.GetBook(ctx, *id)
+			require.NoError(t, err)
+			assert.Equal(t, "first", book.Title)
+			assert.Equal(t, 2, book.Version)
+		})
+	}
+}
+
+func TestDeleteBookInUse(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			bookID, err := s.CreateBook(ctx, &models.Book{Title: "in use", Author: "a", Rating: 1})
+			require.NoError(t, err)
+
+			var members []uuid.UUID
+			for i := 0; i < 2; i++ {
+				unique := uuid.New().String()
+				id, err := s.CreateMember(ctx, &models.Member{Name: unique, Email: unique, CardNumber: unique,
+					Status: models.MemberStatusActive, BorrowingLimit: 1})
+				require.NoError(t, err)
+				members = append(members, *id)
+			}
+
+			// the loan couldn't be returned from the trash
+			_, err = s.CheckoutBook(ctx, &models.Loan{BookID: *bookID, MemberID: &members[0], DueAt: time.Now().Add(time.Hour)})
+			require.NoError(t, err)
+			err = s.DeleteBook(ctx, *bookID, 0)
+			assert.True(t, errors.Is(err, ErrBookCheckedOut), "got %v", err)
+
+			// neither could the hold, waiting for the return and then ready for pickup
+			hold, err := s.PlaceHold(ctx, &models.Hold{BookID: *bookID, MemberID: members[1], PickupWindow: time.Hour})
+			require.NoError(t, err)
+			_, err = s.ReturnBook(ctx, *bookID)
+			require.NoError(t, err)
+			err = s.DeleteBook(ctx, *bookID, 0)
+			assert.True(t, errors.Is(err, ErrBookOnHold), "got %v", err)
+
+			_, err = s.GetBook(ctx, *bookID)
+			require.NoError(t, err)
+
+			_, err = s.CancelHold(ctx, *bookID, hold.ID)
+			require.NoError(t, err)
+			require.NoError(t, s.DeleteBook(ctx, *bookID, 0))
+		})
+	}
+}
+
+func TestListAuditPaging(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			since := time.Now().Add(-time.Second)
+			bookID, err := s.CreateBook(ctx, &models.Book{Title: "0", Author: "a", Rating: 1})
+			require.NoError(t, err)
+			for i := 1; i < 5; i++ {
+				_, err = s.PatchBook(ctx, &models.Book{ID: *bookID, Title: strconv.Itoa(i)}, []BookField{BookFieldTitle})
+				require.NoError(t, err)
+			}
+
+			// the pages of the history follow each other, latest first
+			var titles []string
+			query := AuditQuery{Limit: 2}
+			for page := 0; ; page++ {
+				require.Less(t, page, 3)
+				result, err := s.ListBookHistory(ctx, *bookID, query)
+				require.NoError(t, err)
+				for _, entry := range result.Entries {
+					var after bookSnapshot
+					require.NoError(t, json.Unmarshal(entry.After, &after))
+					titles = append(titles, after.Title)
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				query.Cursor = result.NextCursor
+			}
+			assert.Equal(t, []string{"4", "3", "2", "1", "0"}, titles)
+
+			// the audit of all books is paged the same way
+			first, err := s.ListAudit(ctx, AuditQuery{From: &since, Limit: 3})
+			require.NoError(t, err)
+			require.Len(t, first.Entries, 3)
+			require.NotEmpty(t, first.NextCursor)
+			next, err := s.ListAudit(ctx, AuditQuery{From: &since, Limit: 3, Cursor: first.NextCursor})
+			require.NoError(t, err)
+			require.NotEmpty(t, next.Entries)
+			assert.False(t, next.Entries[0].CreatedAt.After(*first.Entries[2].CreatedAt))
+			for _, entry := range next.Entries {
+				assert.NotEqual(t, first.Entries[2].ID, entry.ID)
+			}
+
+			_, err = s.ListAudit(ctx, AuditQuery{Cursor: "invalid"})
+			assert.Equal(t, ErrInvalidCursor, err)
+		})
+	}
+}
+
+func TestCheckoutDueAt(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			unique := uuid.New().String()
+			memberID, err := s.CreateMember(ctx, &models.Member{Name: unique, Email: unique, CardNumber: unique,
+				Status: models.MemberStatusActive, BorrowingLimit: 2})
+			require.NoError(t, err)
+
+			// the loan period runs from the checkout, as recorded by the storage
+			bookID, err := s.CreateBook(ctx, &models.Book{Title: "period", Author: "a", Rating: 1})
+			require.NoError(t, err)
+			loan, err := s.CheckoutBook(ctx, &models.Loan{BookID: *bookID, MemberID: memberID, Period: 48 * time.Hour})
+			require.NoError(t, err)
+			require.NotNil(t, loan.CheckedOutAt)
+			assert.True(t, loan.DueAt.Equal(loan.CheckedOutAt.Add(48*time.Hour)), "due at %v", loan.DueAt)
+
+			// a due time is kept as given
+			dueAt := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 3)
+			bookID, err = s.CreateBook(ctx, &models.Book{Title: "due date", Author: "a", Rating: 1})
+			require.NoError(t, err)
+			loan, err = s.CheckoutBook(ctx, &models.Loan{BookID: *bookID, MemberID: memberID, DueAt: dueAt, Period: time.Hour})
+			require.NoError(t, err)
+			assert.True(t, loan.DueAt.Equal(dueAt), "due at %v", loan.DueAt)
+		})
+	}
+}
+
+func TestRelayNotificationsReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := make(chan *pq.Notification)
+	events := make(chan *models.Event, 1)
+	lost := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relayNotifications(ctx, notifications, func() {},
+			func(event *models.Event) { events <- event },
+			func() { lost <- struct{}{} })
+	}()
+
+	notifications <- &pq.Notification{Extra: `{"id": 7, "event_type": "book.created",
+		"book_id": "3f7c8a5e-3b7e-4a8e-9c43-0f4a7d2d6b11", "payload": {}, "created_at": "2020-01-02T03:04:05.123456"}`}
+	assert.Equal(t, int64(7), (<-events).ID)
+
+	// the listener sends nil once it's reconnected
+	notifications <- nil
+	<-lost
+
+	cancel()
+	<-done
+	assert.Empty(t, events)
+}
+
+func TestSearchBooksEscapesHighlights(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			word := "w" + strings.ReplaceAll(uuid.New().String(), "-", "")
+			_, err := s.CreateBook(ctx, &models.Book{Title: `<img src=x onerror="alert(1)"> ` + word, Author: "a & b", Rating: 1})
+			require.NoError(t, err)
+
+			hits, err := s.SearchBooks(ctx, SearchBooksQuery{Query: word})
+			require.NoError(t, err)
+			require.Len(t, hits, 1)
+			assert.Equal(t, `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <b>`+word+`</b>`, hits[0].Highlights.Title)
+			assert.Equal(t, "a &amp; b", hits[0].Highlights.Author)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+)
+
+func (s *storeImpl) ListTrash(ctx context.Context) ([]*models.Book, error) {
+	rows, err := s.db.QueryContext(ctx, listTrash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*models.Book
+	for rows.Next() {
+		var deletedAt time.Time
+		book, err := scanBook(rows, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		book.DeletedAt = &deletedAt
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+func (s *storeImpl) RestoreBook(ctx context.Context, bookID uuid.UUID) (*models.Book, error) {
+	var book *models.Book
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockAuditedBook(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt == nil {
+			return ErrBookNotFound
+		}
+
+		if book, err = scanBook(tx.QueryRowContext(ctx, restoreBook, bookID)); err != nil {
+			return err
+		}
+
+		return recordChange(ctx, tx, models.AuditActionRestore, bookID, before)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return book, nil
+}
+
+func (s *storeImpl) PurgeBooks(ctx context.Context, retention time.Duration) (int, error) {
+	res, err := s.db.ExecContext(ctx, purgeBooks, int64(retention/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(purged), nil
+}
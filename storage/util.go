@@ -1,19 +1,254 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
 
-func (s *storeImpl) initialized(ctx context.Context) (bool, error) {
-	var exists bool
-	if err := s.db.QueryRowContext(ctx, booksTableExists).Scan(&exists); err != nil {
-		return false, err
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBook scans a row selected with the columns of getBook, followed by the extra columns
+func scanBook(row rowScanner, extra ...interface{}) (*models.Book, error) {
+	var book models.Book
+	dest := append([]interface{}{
+		&book.ID,
+		&book.Title,
+		&book.Author,
+		&book.Publisher,
+		&book.PublishDate,
+		&book.Rating,
+		&book.Status,
+		&book.CreatedAt,
+		&book.UpdatedAt,
+		&book.Version,
+		&book.Copies.Total,
+		&book.Copies.Available,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// scanLoan scans a row selected with the columns of listLoans
+func scanLoan(row rowScanner) (*models.Loan, error) {
+	var loan models.Loan
+	if err := row.Scan(
+		&loan.ID,
+		&loan.BookID,
+		&loan.MemberID,
+		&loan.Borrower,
+		&loan.CheckedOutAt,
+		&loan.DueAt,
+		&loan.ReturnedAt,
+	); err != nil {
+		return nil, err
 	}
 
-	return exists, nil
+	return &loan, nil
 }
 
-func (s *storeImpl) createBooksTable(ctx context.Context) error {
-	if _, err := s.db.ExecContext(ctx, initSql); err != nil {
+// scanMember scans a row selected with the columns of getMember
+func scanMember(row rowScanner) (*models.Member, error) {
+	var member models.Member
+	if err := row.Scan(
+		&member.ID,
+		&member.Name,
+		&member.Email,
+		&member.CardNumber,
+		&member.Status,
+		&member.BorrowingLimit,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// scanWebhook scans a row selected with the columns of getWebhook
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var (
+		webhook    models.Webhook
+		eventTypes []string
+	)
+	if err := row.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		pq.Array(&eventTypes),
+		&webhook.Secret,
+		&webhook.Active,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	for _, eventType := range eventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, models.EventType(eventType))
+	}
+	return &webhook, nil
+}
+
+// scanWebhookDelivery scans a row selected with webhookDeliveryColumns
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var (
+		delivery   models.WebhookDelivery
+		payload    []byte
+		statusCode sql.NullInt64
+		lastError  sql.NullString
+	)
+	if err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&statusCode,
+		&lastError,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+		&delivery.DeliveredAt,
+	); err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+	delivery.LastStatusCode = int(statusCode.Int64)
+	delivery.LastError = lastError.String
+	return &delivery, nil
+}
+
+// scanHold scans a row selected with the columns of getHold
+func scanHold(row rowScanner) (*models.Hold, error) {
+	var (
+		hold         models.Hold
+		pickupWindow int64
+	)
+	if err := row.Scan(
+		&hold.ID,
+		&hold.BookID,
+		&hold.MemberID,
+		&hold.Status,
+		&pickupWindow,
+		&hold.CreatedAt,
+		&hold.ReadyAt,
+		&hold.ExpiresAt,
+		&hold.ClosedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	hold.PickupWindow = time.Duration(pickupWindow) * time.Second
+	return &hold, nil
+}
+
+// scanFine scans a row selected with the columns of listMemberFines, followed by the extra columns
+func scanFine(row rowScanner, extra ...interface{}) (*models.Fine, error) {
+	var fine models.Fine
+	dest := append([]interface{}{
+		&fine.ID,
+		&fine.LoanID,
+		&fine.MemberID,
+		&fine.Amount,
+		&fine.Status,
+		&fine.CreatedAt,
+		&fine.UpdatedAt,
+		&fine.PaidAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	return &fine, nil
+}
+
+// scanCopy scans a row selected with the columns of getCopy
+func scanCopy(row rowScanner) (*models.Copy, error) {
+	var item models.Copy
+	var location sql.NullString
+	if err := row.Scan(
+		&item.ID,
+		&item.BookID,
+		&item.Barcode,
+		&location,
+		&item.Condition,
+		&item.Status,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	item.Location = location.String
+
+	return &item, nil
+}
+
+// isUniqueViolation tells whether the error is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
+
+func (s *storeImpl) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
 		return err
 	}
-	return nil
+
+	return tx.Commit()
+}
+
+// lockBook locks the book row until the end of the transaction and returns its status
+func lockBook(ctx context.Context, tx *sql.Tx, bookID uuid.UUID) (models.BookStatus, error) {
+	var status models.BookStatus
+	if err := tx.QueryRowContext(ctx, lockBookStatus, bookID).Scan(&status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrBookNotFound
+		}
+		return "", err
+	}
+	return status, nil
+}
+
+func inConnTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
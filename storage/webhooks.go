@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/alexkaplun/books-test/storage/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebhookDeliveryQuery filters the delivery log of a webhook. Zero values mean no filter
+type WebhookDeliveryQuery struct {
+	Status models.WebhookDeliveryStatus
+	// Limit defaults to DefaultListLimit and is capped by MaxListLimit
+	Limit int
+}
+
+func (q WebhookDeliveryQuery) normalize() WebhookDeliveryQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+	return q
+}
+
+func (s *storeImpl) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*uuid.UUID, error) {
+	var id uuid.UUID
+	if err := s.db.QueryRowContext(ctx, createWebhook,
+		webhook.URL, eventTypesParam(webhook.EventTypes), webhook.Secret, webhook.Active,
+	).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func (s *storeImpl) UpdateWebhook(ctx context.Context, webhook *models.Webhook, active *bool) error {
+	res, err := s.db.ExecContext(ctx, updateWebhook,
+		webhook.ID,
+		webhook.URL,
+		eventTypesParam(webhook.EventTypes),
+		webhook.Secret,
+		active,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *storeImpl) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx, deleteWebhook, webhookID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *storeImpl) GetWebhook(ctx context.Context, webhookID uuid.UUID) (*models.Webhook, error) {
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, getWebhook, webhookID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *storeImpl) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, listWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (s *storeImpl) EnqueueWebhookDeliveries(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	res, err := s.db.ExecContext(ctx, enqueueWebhookDeliveries,
+		delivery.EventID, delivery.EventType, jsonParam(delivery.Payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	queued, err := res.RowsAffected()
+	return int(queued), err
+}
+
+func (s *storeImpl) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	created, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, createWebhookDelivery,
+		delivery.WebhookID, delivery.EventID, delivery.EventType, jsonParam(delivery.Payload),
+	))
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (s *storeImpl) PendingWebhookDeliveries(ctx context.Context, limit int, skip []uuid.UUID) ([]*models.WebhookDelivery, error) {
+	skipIDs := make([]string, len(skip))
+	for i, id := range skip {
+		skipIDs[i] = id.String()
+	}
+	return queryWebhookDeliveries(ctx, s.db, pendingWebhookDeliveries, limit, pq.Array(skipIDs))
+}
+
+func (s *storeImpl) RecordWebhookAttempt(ctx context.Context, deliveryID uuid.UUID, attempt models.WebhookAttempt) (*models.WebhookDelivery, error) {
+	delivery, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, recordWebhookAttempt,
+		deliveryID, attempt.Status, attempt.StatusCode, attempt.Error, attempt.RetryIn.Seconds(),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the webhook was deleted with its deliveries
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (s *storeImpl) ListWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, query WebhookDeliveryQuery) ([]*models.WebhookDelivery, error) {
+	query = query.normalize()
+
+	conds := []string{"webhook_id = $1"}
+	args := []interface{}{webhookID}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	stmt := fmt.Sprintf("%s%sORDER BY created_at DESC, id\nLIMIT %d", listWebhookDeliveries, whereClause(conds), query.Limit)
+	deliveries, err := queryWebhookDeliveries(ctx, s.db, stmt, args...)
+	if err != nil || len(deliveries) > 0 {
+		return deliveries, err
+	}
+
+	var exists bool
+	if err = s.db.QueryRowContext(ctx, webhookExists, webhookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+	return deliveries, nil
+}
+
+func queryWebhookDeliveries(ctx context.Context, db *sql.DB, stmt string, args ...interface{}) ([]*models.WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// eventTypesParam passes the event types to a TEXT[] column
+func eventTypesParam(eventTypes []models.EventType) interface{} {
+	values := make([]string, len(eventTypes))
+	for i, eventType := range eventTypes {
+		values[i] = string(eventType)
+	}
+	return pq.Array(values)
+}